@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/events"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditDenied  = "denied"
+)
+
+// AuditRecord a control operation recorded in the audit log
+type AuditRecord struct {
+	// RFC3339 time of the operation
+	Time string `json:"time"`
+	// the authenticated user or token, anonymous if no authentication is needed
+	Principal string `json:"principal"`
+	// the remote address, or the uid of the peer process of the unix socket
+	Source string `json:"source"`
+	// xmlrpc, rest, webgui or signal
+	Transport string `json:"transport"`
+	Operation string `json:"operation"`
+	// the program, the group like "group:*" or "all", empty for supervisord
+	Target string `json:"target,omitempty"`
+	// the signal, the rolling restart batch size and so on
+	Detail string `json:"detail,omitempty"`
+	// success, failure or denied
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditLogResult the records read from the audit log
+type AuditLogResult struct {
+	Records []AuditRecord `json:"records"`
+}
+
+// auditLog appends the audit records to the audit_logfile as json lines
+type auditLog struct {
+	file string
+	lock sync.Mutex
+	f    *os.File
+}
+
+// openAuditLog opens the audit log file for appending, it is created with
+// mode 0600 if it does not exist
+func openAuditLog(file string) (*auditLog, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, f: f}, nil
+}
+
+func (a *auditLog) write(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the audit log file
+func (a *auditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.f.Close()
+}
+
+// readAuditLog reads the last max records accepted by the filter from the
+// audit log file, the lines which are not valid records are skipped
+func readAuditLog(file string, filter func(record *AuditRecord) bool, max int) ([]AuditRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]AuditRecord, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || !filter(&record) {
+			continue
+		}
+		records = append(records, record)
+		if max > 0 && len(records) > max {
+			records = records[len(records)-max:]
+		}
+	}
+	return records, scanner.Err()
+}
+
+// setAuditLog opens the audit_logfile of the supervisord section, the audit
+// log is disabled if it is not set
+func (s *Supervisor) setAuditLog() {
+	file := ""
+	if supervisordConf, ok := s.config.GetSupervisord(); ok {
+		env := config.NewStringExpression("here", s.config.GetConfigFileDir())
+		var err error
+		file, err = env.Eval(supervisordConf.GetString("audit_logfile", ""))
+		if err != nil {
+			log.Error("invalid audit_logfile: ", err)
+			file = ""
+		}
+	}
+	prev := s.auditLog.Load()
+	if prev != nil && prev.file == file {
+		return
+	}
+	var a *auditLog
+	if file != "" {
+		var err error
+		if a, err = openAuditLog(file); err != nil {
+			log.WithFields(log.Fields{"file": file}).Error("fail to open audit log: ", err)
+		}
+	}
+	s.auditLog.Store(a)
+	if prev != nil {
+		prev.Close()
+	}
+}
+
+// audit records the control operation requested by the http request and
+// emits the AUDIT event
+func (s *Supervisor) audit(r *http.Request, operation string, target string, detail string, err error) {
+	record := &AuditRecord{Principal: "anonymous",
+		Source:    getRequestSource(r),
+		Transport: getRequestTransport(r),
+		Operation: operation,
+		Target:    target,
+		Detail:    detail,
+		Result:    auditSuccess}
+	if user := getRequestUser(r); user != nil {
+		record.Principal = user.name
+	}
+	if err != nil {
+		record.Result = auditFailure
+		record.Error = err.Error()
+	}
+	s.writeAudit(record)
+}
+
+// auditSignal records the operation done for the signal received by
+// supervisord, the sender of the signal is unknown
+func (s *Supervisor) auditSignal(signal string, operation string, err error) {
+	record := &AuditRecord{Principal: "unknown",
+		Source:    "local",
+		Transport: "signal",
+		Operation: operation,
+		Detail:    signal,
+		Result:    auditSuccess}
+	if err != nil {
+		record.Result = auditFailure
+		record.Error = err.Error()
+	}
+	s.writeAudit(record)
+}
+
+// auditDeniedRequest records the control operation denied by the access
+// control, the requests only reading the state and the logs are not recorded
+func (s *Supervisor) auditDeniedRequest(r *http.Request, req *accessRequest) {
+	if req.permission == permissionView || req.permission == permissionLog {
+		return
+	}
+	operation := req.operation
+	if operation == "" {
+		operation = r.Method + " " + r.URL.Path
+	}
+	record := &AuditRecord{Principal: "anonymous",
+		Source:    getRequestSource(r),
+		Transport: getRequestTransport(r),
+		Operation: operation,
+		Target:    strings.Join(req.names, ","),
+		Result:    auditDenied}
+	if user := getRequestUser(r); user != nil {
+		record.Principal = user.name
+	}
+	s.writeAudit(record)
+}
+
+func (s *Supervisor) writeAudit(record *AuditRecord) {
+	record.Time = time.Now().Format(time.RFC3339Nano)
+	if a := s.auditLog.Load(); a != nil {
+		if err := a.write(record); err != nil {
+			log.WithFields(log.Fields{"file": a.file}).Error("fail to write audit log: ", err)
+		}
+	}
+	message := record.Detail
+	if record.Error != "" {
+		message = record.Error
+	}
+	events.EmitEvent(events.CreateAuditEvent(record.Principal,
+		record.Source,
+		record.Transport,
+		record.Operation,
+		record.Target,
+		record.Result,
+		message))
+}
+
+// getAuditLogFile gets the file of the audit log, empty if it is disabled
+func (s *Supervisor) getAuditLogFile() string {
+	if a := s.auditLog.Load(); a != nil {
+		return a.file
+	}
+	return ""
+}
+
+// getRequestSource gets the remote address of the request, or the uid of the
+// peer process if the request is from the unix socket
+func getRequestSource(r *http.Request) string {
+	if cred := getRequestPeerCredentials(r); cred != nil {
+		return fmt.Sprintf("unix:uid=%d", cred.uid)
+	}
+	// the remote address of the unix socket is empty or "@"
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "unix"
+	}
+	return r.RemoteAddr
+}
+
+// getRequestTransport gets how the request is sent: the XML-RPC interface,
+// the restful interface, or the restful interface called by the web GUI
+// which is served on the same origin
+func getRequestTransport(r *http.Request) string {
+	if r.URL.Path == "/RPC2" {
+		return "xmlrpc"
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "same-origin" {
+		return "webgui"
+	}
+	return "rest"
+}
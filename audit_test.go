@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	s := newTestSupervisor(t, "[supervisord]\naudit_logfile=%(here)s/audit.log\n")
+	s.setAuditLog()
+	defer s.auditLog.Load().Close()
+	file := s.getAuditLogFile()
+	if file != filepath.Join(s.config.GetConfigFileDir(), "audit.log") {
+		t.Fatalf("unexpected audit log file %s", file)
+	}
+
+	r := httptest.NewRequest("POST", "/RPC2", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	reply := struct{ Success bool }{}
+	if err := s.AddProcessGroup(r, &struct{ Name string }{Name: "web"}, &reply); err == nil || reply.Success {
+		t.Error("expected adding the process group is not supported")
+	}
+	s.auditSignal("SIGTERM", "shutdown", nil)
+
+	// the lines which are not records are skipped
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not a record\n")
+	f.Close()
+	s.audit(httptest.NewRequest("POST", "/program/stop/web", nil), "stopProcess", "web", "", nil)
+
+	records, err := readAuditLog(file, func(record *AuditRecord) bool { return true }, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	if record := records[0]; record.Operation != "addProcessGroup" || record.Target != "web" || record.Result != auditFailure ||
+		record.Transport != "xmlrpc" || record.Source != "10.0.0.1:1234" || record.Principal != "anonymous" || record.Error == "" {
+		t.Errorf("unexpected record of the failed operation %+v", record)
+	}
+	if record := records[1]; record.Operation != "shutdown" || record.Transport != "signal" || record.Detail != "SIGTERM" || record.Result != auditSuccess {
+		t.Errorf("unexpected record of the signal %+v", record)
+	}
+	if record := records[2]; record.Operation != "stopProcess" || record.Transport != "rest" || record.Result != auditSuccess || record.Time == "" {
+		t.Errorf("unexpected record of the rest request %+v", record)
+	}
+
+	records, err = readAuditLog(file, func(record *AuditRecord) bool { return record.Transport != "signal" }, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Operation != "stopProcess" {
+		t.Errorf("expected the last record accepted by the filter, got %+v", records)
+	}
+}
+
+func TestGetRequestTransport(t *testing.T) {
+	r := httptest.NewRequest("POST", "/RPC2", nil)
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	if transport := getRequestTransport(r); transport != "xmlrpc" {
+		t.Errorf("expected xmlrpc, got %s", transport)
+	}
+	r = httptest.NewRequest("POST", "/program/start/web", nil)
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	if transport := getRequestTransport(r); transport != "webgui" {
+		t.Errorf("expected webgui for the same origin request, got %s", transport)
+	}
+	for _, site := range []string{"", "cross-site", "none"} {
+		r = httptest.NewRequest("POST", "/program/start/web", nil)
+		if site != "" {
+			r.Header.Set("Sec-Fetch-Site", site)
+		}
+		if transport := getRequestTransport(r); transport != "rest" {
+			t.Errorf("expected rest with Sec-Fetch-Site %q, got %s", site, transport)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+
+	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/process"
+	log "github.com/sirupsen/logrus"
+)
+
+// getChildLogDir gets the childlogdir of the supervisord section, the
+// directory of the AUTO log files. The default is the temporary directory
+func (s *Supervisor) getChildLogDir() string {
+	supervisordConf, ok := s.config.GetSupervisord()
+	if !ok {
+		return os.TempDir()
+	}
+	env := config.NewStringExpression("here", s.config.GetConfigFileDir())
+	dir, err := env.Eval(supervisordConf.GetString("childlogdir", ""))
+	if err != nil {
+		log.Error("invalid childlogdir: ", err)
+		return os.TempDir()
+	}
+	if dir == "" {
+		return os.TempDir()
+	}
+	return dir
+}
+
+// setChildLogSettings passes the childlogdir and the strip_ansi of the
+// supervisord section to the programs. When supervisord is started, the AUTO
+// log files left by the previous supervisord with the same identifier are
+// removed unless nocleanup is true or supervisord is started by upgrade
+func (s *Supervisor) setChildLogSettings(restart bool) {
+	childLogDir := s.getChildLogDir()
+	stripAnsi := false
+	noCleanup := false
+	if supervisordConf, ok := s.config.GetSupervisord(); ok {
+		stripAnsi = supervisordConf.GetBool("strip_ansi", false)
+		noCleanup = supervisordConf.GetBool("nocleanup", false)
+	}
+	if restart && !noCleanup && !isStartedByUpgrade() {
+		if err := process.CleanAutoLogFiles(childLogDir, s.GetSupervisorID()); err != nil {
+			log.WithFields(log.Fields{"childlogdir": childLogDir}).Warn("fail to clean the AUTO log files: ", err)
+		}
+	}
+	s.procMgr.SetChildLogSettings(childLogDir, stripAnsi)
+}
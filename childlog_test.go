@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetChildLogDir(t *testing.T) {
+	s := newTestSupervisor(t, "[supervisord]\nchildlogdir=%(here)s/logs\n")
+	if dir := s.getChildLogDir(); dir != filepath.Join(s.config.GetConfigFileDir(), "logs") {
+		t.Errorf("expected the childlogdir relative to the config file, got %s", dir)
+	}
+	s = newTestSupervisor(t, "[supervisord]\nchildlogdir=/var/log/supervisor\n")
+	if dir := s.getChildLogDir(); dir != "/var/log/supervisor" {
+		t.Errorf("expected childlogdir /var/log/supervisor, got %s", dir)
+	}
+	s = newTestSupervisor(t, "[supervisord]\n")
+	if dir := s.getChildLogDir(); dir != os.TempDir() {
+		t.Errorf("expected the temporary directory without childlogdir, got %s", dir)
+	}
+}
+
+func TestSetChildLogSettings(t *testing.T) {
+	s := newTestSupervisor(t, "[supervisord]\nchildlogdir=%(here)s\n\n[program:web]\ncommand=/bin/ls\nstdout_logfile=AUTO\n")
+	s.setChildLogSettings(false)
+	// the settings are used by the processes created after them like reload
+	s.procMgr.Remove("web")
+	proc := s.procMgr.CreateProcess(s.GetSupervisorID(), s.config.GetProgram("web"))
+	if dir := filepath.Dir(proc.GetStdoutLogfile()); dir != s.config.GetConfigFileDir() {
+		t.Errorf("expected the AUTO log file in the childlogdir, got %s", proc.GetStdoutLogfile())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -117,9 +118,14 @@ func (c *Config) createEntry(name string, configDir string) *Entry {
 	return entry
 }
 
-// Load the configuration and return loaded programs
-func (c *Config) Load() ([]string, error) {
+// Load the configuration and return loaded programs. The loaded
+// configuration is checked by the validators, the previous configuration is
+// restored if any of them fails
+func (c *Config) Load(validators ...func(*Config) error) ([]string, error) {
 	myini := ini.NewIni()
+	// the entries are parsed in place, they are restored if the loaded
+	// configuration is invalid
+	saved := c.save()
 	c.ProgramGroup = NewProcessGroup()
 	log.WithFields(log.Fields{"file": c.configFile}).Info("load configuration from file")
 	myini.LoadFile(c.configFile)
@@ -129,7 +135,56 @@ func (c *Config) Load() ([]string, error) {
 		log.WithFields(log.Fields{"file": f}).Info("load configuration from file")
 		myini.LoadFile(f)
 	}
-	return c.parse(myini), nil
+	loadedPrograms := c.parse(myini)
+	programs := c.GetEntries(func(entry *Entry) bool {
+		return entry.IsProgram()
+	})
+	if err := CheckDependsOnCycle(programs); err != nil {
+		c.restore(saved)
+		return nil, err
+	}
+	for _, validate := range validators {
+		if err := validate(c); err != nil {
+			c.restore(saved)
+			return nil, err
+		}
+	}
+	return loadedPrograms, nil
+}
+
+// savedConfig the entries of the configuration before it is loaded again
+type savedConfig struct {
+	entries      map[string]*Entry
+	values       map[*Entry]Entry
+	programGroup *ProcessGroup
+}
+
+// save copies the entries and their values, so they can be restored after
+// they are parsed in place
+func (c *Config) save() *savedConfig {
+	saved := &savedConfig{entries: make(map[string]*Entry),
+		values:       make(map[*Entry]Entry),
+		programGroup: c.ProgramGroup}
+	for name, entry := range c.entries {
+		saved.entries[name] = entry
+		value := *entry
+		value.keyValues = make(map[string]string)
+		for k, v := range entry.keyValues {
+			value.keyValues[k] = v
+		}
+		saved.values[entry] = value
+	}
+	return saved
+}
+
+// restore restores the saved entries, the entries used by the programs get
+// their saved values back
+func (c *Config) restore(saved *savedConfig) {
+	for entry, value := range saved.values {
+		*entry = value
+	}
+	c.entries = saved.entries
+	c.ProgramGroup = saved.programGroup
 }
 
 func (c *Config) getIncludeFiles(cfg *ini.Ini) []string {
@@ -171,7 +226,14 @@ func (c *Config) parse(cfg *ini.Ini) []string {
 	c.parseGroup(cfg)
 	loadedPrograms := c.parseProgram(cfg)
 
-	// parse non-group, non-program and non-eventlistener sections
+	// parse non-group, non-program and non-eventlistener sections, the
+	// removed sections and parameters are dropped, so a removed user is
+	// not kept after reload
+	for name, entry := range c.entries {
+		if !entry.IsGroup() && !entry.IsProgram() && !entry.IsEventListener() {
+			delete(c.entries, name)
+		}
+	}
 	for _, section := range cfg.Sections() {
 		if !strings.HasPrefix(section.Name, "group:") && !strings.HasPrefix(section.Name, "program:") && !strings.HasPrefix(section.Name, "eventlistener:") {
 			entry := c.createEntry(section.Name, c.GetConfigFileDir())
@@ -240,6 +302,26 @@ func (c *Config) GetSupervisorctl() (*Entry, bool) {
 	return entry, ok
 }
 
+// GetUsers returns "users" configuration section
+func (c *Config) GetUsers() (*Entry, bool) {
+	entry, ok := c.entries["users"]
+	return entry, ok
+}
+
+// GetRoles returns configuration entries of all roles
+func (c *Config) GetRoles() []*Entry {
+	return c.GetEntries(func(entry *Entry) bool {
+		return strings.HasPrefix(entry.Name, "role:")
+	})
+}
+
+// GetTokens returns configuration entries of all tokens
+func (c *Config) GetTokens() []*Entry {
+	return c.GetEntries(func(entry *Entry) bool {
+		return strings.HasPrefix(entry.Name, "token:")
+	})
+}
+
 // GetEntries returns configuration entries by filter
 func (c *Config) GetEntries(filterFunc func(entry *Entry) bool) []*Entry {
 	result := make([]*Entry, 0)
@@ -298,6 +380,16 @@ func (c *Config) GetProgram(name string) *Entry {
 	return nil
 }
 
+// GetKeys returns the sorted keys of the entry
+func (c *Entry) GetKeys() []string {
+	keys := make([]string, 0, len(c.keyValues))
+	for key := range c.keyValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // GetBool gets value of key as bool
 func (c *Entry) GetBool(key string, defValue bool) bool {
 	value, ok := c.keyValues[key]
@@ -335,6 +427,19 @@ func (c *Entry) GetInt(key string, defValue int) int {
 	return defValue
 }
 
+// GetFloat gets value of the key as float64
+func (c *Entry) GetFloat(key string, defValue float64) float64 {
+	value, ok := c.keyValues[key]
+
+	if ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil {
+			return f
+		}
+	}
+	return defValue
+}
+
 func parseEnv(s string) *map[string]string {
 	result := make(map[string]string)
 	start := 0
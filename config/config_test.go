@@ -133,6 +133,38 @@ func TestGetUnitHttpServer(t *testing.T) {
 	}
 }
 
+func TestGetUsersAndRoles(t *testing.T) {
+	fileName, err := saveToTmpFile([]byte("[users]\nbob=secret\nalice={SHA}abc\n[role:web]\nusers=bob\n[role:viewer]\nusers=alice\n[token:deploy]\ntoken_sha256=abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fileName)
+	config := NewConfig(fileName)
+	if _, err = config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := config.GetUsers()
+	if !ok || fmt.Sprint(entry.GetKeys()) != "[alice bob]" {
+		t.Error("Fail to get the users")
+	}
+	if len(config.GetRoles()) != 2 {
+		t.Error("Fail to get the roles")
+	}
+	if len(config.GetTokens()) != 1 {
+		t.Error("Fail to get the tokens")
+	}
+
+	// the removed users and roles are dropped on reload
+	os.WriteFile(fileName, []byte("[users]\nalice={SHA}abc\n"), os.ModePerm)
+	if _, err = config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok = config.GetUsers()
+	if !ok || fmt.Sprint(entry.GetKeys()) != "[alice]" || len(config.GetRoles()) != 0 {
+		t.Error("the removed users and roles should be dropped on reload")
+	}
+}
+
 func TestProgramInGroup(t *testing.T) {
 	config, _ := parse([]byte("[program:test1]\nA=123\n[group:test]\nprograms=test1,test2\n[program:test2]\nB=hello\n[program:test3]\nC=tt"))
 	if config.GetProgram("test1").Group != "test" { // || config.GetProgram( "test2" ).Group != "test" || config.GetProgram( "test3" ).Group == "test" {
@@ -210,3 +242,31 @@ func TestDefaultParams(t *testing.T) {
 	}
 
 }
+
+func TestLoadKeepsConfigWithDependsOnCycle(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/web\ndepends_on=db\n[program:db]\ncommand=/bin/db\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig(fileName)
+	if _, err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	web := config.GetProgram("web")
+
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/web2\ndepends_on=db\n[program:db]\ncommand=/bin/db\ndepends_on=web\n[program:cache]\ncommand=/bin/cache\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(); err == nil {
+		t.Fatal("expected the configuration with depends_on cycle is not loaded")
+	}
+	if config.GetProgram("web") != web || web.GetString("command", "") != "/bin/web" {
+		t.Errorf("expected the previous program is kept, got command %s", web.GetString("command", ""))
+	}
+	if config.GetProgram("db").GetString("depends_on", "") != "" {
+		t.Error("expected the previous depends_on is kept")
+	}
+	if config.GetProgram("cache") != nil {
+		t.Error("expected the program of the invalid configuration is not added")
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 )
@@ -94,17 +95,86 @@ func (p *ProcessSorter) sortDepends() []string {
 	}
 
 	for len(finishedPrograms) < len(progsWithDependsInfo) {
+		progress := false
 		for progName := range p.dependsOnGraph {
 			if _, ok := finishedPrograms[progName]; !ok && p.inFinishedPrograms(progName, finishedPrograms) {
 				finishedPrograms[progName] = progName
 				progsStartOrder = append(progsStartOrder, progName)
+				progress = true
 			}
 		}
+		// the left programs are in a dependency cycle, which is reported
+		// by CheckDependsOnCycle when loading the configuration
+		if !progress {
+			left := make([]string, 0)
+			for progName := range p.dependsOnGraph {
+				if _, ok := finishedPrograms[progName]; !ok {
+					left = append(left, progName)
+				}
+			}
+			sort.Strings(left)
+			progsStartOrder = append(progsStartOrder, left...)
+			break
+		}
 	}
 
 	return progsStartOrder
 }
 
+// CheckDependsOnCycle checks the depends_on of the programs and returns
+// error if there is a dependency cycle, for example a -> b -> a
+func CheckDependsOnCycle(programConfigs []*Entry) error {
+	p := NewProcessSorter()
+	p.initDepends(programConfigs)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make(map[string]int)
+	path := make([]string, 0)
+
+	var visit func(progName string) error
+	visit = func(progName string) error {
+		switch states[progName] {
+		case visiting:
+			cycle := []string{progName}
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i]}, cycle...)
+				if path[i] == progName {
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle found in depends_on: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		states[progName] = visiting
+		path = append(path, progName)
+		for _, dependsOnProg := range p.dependsOnGraph[progName] {
+			if err := visit(dependsOnProg); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[progName] = visited
+		return nil
+	}
+
+	progNames := make([]string, 0)
+	for progName := range p.dependsOnGraph {
+		progNames = append(progNames, progName)
+	}
+	sort.Strings(progNames)
+	for _, progName := range progNames {
+		if err := visit(progName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *ProcessSorter) inFinishedPrograms(programName string, finishedPrograms map[string]string) bool {
 	if dependsOn, ok := p.dependsOnGraph[programName]; ok {
 		for _, dependProgram := range dependsOn {
@@ -90,3 +90,31 @@ func TestSortProgram(t *testing.T) {
 	}
 
 }
+
+func TestCheckDependsOnCycle(t *testing.T) {
+	entries := make([]*Entry, 0)
+	for i, dependsOn := range []string{"prog-2", "prog-3", "prog-1", ""} {
+		entry := NewEntry(".")
+		entry.Name = fmt.Sprintf("program:prog-%d", i+1)
+		if dependsOn != "" {
+			entry.keyValues["depends_on"] = dependsOn
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := CheckDependsOnCycle(entries); err == nil {
+		t.Error("dependency cycle is not reported")
+	}
+
+	// the sorter must not hang on the cycle
+	if len(sortProgram(entries)) != len(entries) {
+		t.Error("Program sort is incorrect")
+	}
+
+	entries[0].keyValues["depends_on"] = "prog-4"
+	entries[1].keyValues["depends_on"] = "prog-4"
+	entries[2].keyValues["depends_on"] = "prog-4"
+	if err := CheckDependsOnCycle(entries); err != nil {
+		t.Error("unexpected dependency cycle: ", err)
+	}
+}
@@ -7,8 +7,8 @@ import (
 
 var configTemplate = `[unix_http_server]
 file=/tmp/supervisord.sock
-#chmod=not support
-#chown=not support
+chmod=0700
+#chown=nobody:nogroup
 username=test1
 password={SHA}82ab876d1387bfafe46cc1c8a2ef074eae50cb1d
 
@@ -23,16 +23,16 @@ logfileMaxbytes=50MB
 logfileBackups=10
 loglevel=info
 pidfile=%(here)s/supervisord.pid
-#umask=not support
-#nodaemon=not support
-#minfds=not support
-#minprocs=not support
-#nocleanup=not support
-#childlogdir=not support
-#user=not support
-#directory=not support
-#strip_ansi=not support
-#environment=not support
+#umask=022
+#nodaemon=false
+#minfds=1024
+#minprocs=200
+#nocleanup=false
+#childlogdir=/tmp
+#user=chrism
+#directory=/tmp
+#strip_ansi=false
+#environment=KEY="val",KEY2="val2"
 identifier=supervisor
 
 [program:x]
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ochinchina/supervisord/config"
 	"github.com/ochinchina/supervisord/types"
@@ -16,6 +20,10 @@ type CtlCommand struct {
 	User      string `short:"u" long:"user" description:"the user name"`
 	Password  string `short:"P" long:"password" description:"the password"`
 	Verbose   bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
+	CA        string `long:"ca" description:"the CA file to verify the certificate of supervisord over https"`
+	Cert      string `long:"cert" description:"the client certificate file for mutual TLS"`
+	Key       string `long:"key" description:"the client key file for mutual TLS"`
+	Token     string `long:"token" env:"SUPERVISORD_TOKEN" description:"the bearer token sent instead of the user and password"`
 }
 
 // StatusCommand get the status of all supervisor managed programs
@@ -60,10 +68,22 @@ type RestartCommand struct {
 	} `positional-args:"yes" required:"yes"`
 }
 
+// RollingRestartCommand restart the processes of programs or groups a batch at a time
+type RollingRestartCommand struct {
+	BatchSize int `short:"b" long:"batch" description:"how many processes are restarted at a time" default:"1"`
+	Args      struct {
+		Programs []string `positional-arg-name:"Program" description:"Name of the Program or Group"`
+	} `positional-args:"yes" required:"yes"`
+}
+
 // ShutdownCommand shutdown the supervisor
 type ShutdownCommand struct {
 }
 
+// UpgradeCommand re-execute the supervisord binary without stopping the programs
+type UpgradeCommand struct {
+}
+
 // ReloadCommand reload all the programs
 type ReloadCommand struct {
 }
@@ -83,14 +103,56 @@ type SignalCommand struct {
 	} `positional-args:"yes" required:"yes"`
 }
 
+// HistoryCommand show the past runs of program
+type HistoryCommand struct {
+	Args struct {
+		Program string `positional-arg-name:"Program" description:"Name of the Program"`
+	} `positional-args:"yes" required:"yes"`
+}
+
 // LogtailCommand tail the stdout/stderr log of program through http interface
 type LogtailCommand struct {
 	LogType string `short:"t" long:"type" choice:"stdout" choice:"stderr" description:"the log type, stdout or stderr" default:"stdout"`
+	Follow  bool   `short:"f" long:"follow" description:"follow the log until interrupted"`
+	Lines   int    `short:"n" long:"lines" description:"output the last lines of the log" default:"10"`
+	Node    string `long:"node" description:"the node of the program, the local supervisord if not set"`
 	Args    struct {
 		Program string `positional-arg-name:"Program" description:"Name of the Program"`
 	} `positional-args:"yes" required:"yes"`
 }
 
+// ResetStateCommand forget the desired state of programs
+type ResetStateCommand struct {
+	Args struct {
+		Programs []string `positional-arg-name:"Program" description:"Name of the Program, all the programs if not set"`
+	} `positional-args:"yes"`
+}
+
+// GrepCommand search the current and rotated log files of programs
+type GrepCommand struct {
+	Regexp     bool   `short:"E" long:"regexp" description:"the pattern is a regular expression"`
+	IgnoreCase bool   `short:"i" long:"ignore-case" description:"ignore the case of the pattern"`
+	LogType    string `short:"t" long:"type" choice:"stdout" choice:"stderr" description:"search only the stdout or stderr log"`
+	Since      string `long:"since" description:"skip the lines before the RFC3339 time, unix seconds or a duration like 1h before now"`
+	MaxCount   int    `short:"m" long:"max-count" description:"the max number of matched lines of each node" default:"1000"`
+	Nodes      string `long:"nodes" description:"comma separated nodes to search, all the nodes if not set"`
+	Args       struct {
+		Pattern  string   `positional-arg-name:"Pattern" description:"the text to search"`
+		Programs []string `positional-arg-name:"Program" description:"Name of the Program, all the programs if not set"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// AuditCommand show the control operations recorded in the audit log
+type AuditCommand struct {
+	Lines     int    `short:"n" long:"lines" description:"output the last records of the audit log" default:"20"`
+	Principal string `long:"principal" description:"only the records of the user or token"`
+	Operation string `long:"operation" description:"only the records of the operation like stopProcess"`
+	Since     string `long:"since" description:"skip the records before the RFC3339 time, unix seconds or a duration like 1h before now"`
+	Args      struct {
+		Target string `positional-arg-name:"Target" description:"only the records of the program, the group like group:* or all"`
+	} `positional-args:"yes"`
+}
+
 var ctlCommand CtlCommand
 var statusCommand StatusCommand
 var startCommand StartCommand
@@ -98,11 +160,17 @@ var stopCommand StopCommand
 var startGroupCommand StartGroupCommand
 var stopGroupCommand StopGroupCommand
 var restartCommand RestartCommand
+var rollingRestartCommand RollingRestartCommand
 var shutdownCommand ShutdownCommand
+var upgradeCommand UpgradeCommand
 var reloadCommand ReloadCommand
 var pidCommand PidCommand
 var signalCommand SignalCommand
 var logtailCommand LogtailCommand
+var historyCommand HistoryCommand
+var grepCommand GrepCommand
+var resetStateCommand ResetStateCommand
+var auditCommand AuditCommand
 
 func (x *CtlCommand) getServerURL() string {
 	options.Configuration, _ = findSupervisordConf()
@@ -154,10 +222,37 @@ func (x *CtlCommand) getPassword() string {
 	return ""
 }
 
+// getOption gets the option from the command line or the supervisorctl
+// section of the configuration file
+func (x *CtlCommand) getOption(value string, name string) string {
+	options.Configuration, _ = findSupervisordConf()
+
+	if value != "" {
+		return value
+	} else if _, err := os.Stat(options.Configuration); err == nil {
+		myconfig := config.NewConfig(options.Configuration)
+		_, _ = myconfig.Load()
+		if entry, ok := myconfig.GetSupervisorctl(); ok {
+			return entry.GetString(name, "")
+		}
+	}
+	return ""
+}
+
 func (x *CtlCommand) createRPCClient() *xmlrpcclient.XMLRPCClient {
 	rpcc := xmlrpcclient.NewXMLRPCClient(x.getServerURL(), x.Verbose)
 	rpcc.SetUser(x.getUser())
 	rpcc.SetPassword(x.getPassword())
+	rpcc.SetToken(x.getOption(x.Token, "token"))
+	caFile, certFile, keyFile := x.getOption(x.CA, "tls_ca"), x.getOption(x.Cert, "tls_cert"), x.getOption(x.Key, "tls_key")
+	if caFile != "" || certFile != "" || keyFile != "" {
+		tlsConfig, err := xmlrpcclient.NewTLSConfig(caFile, certFile, keyFile)
+		if err != nil {
+			fmt.Printf("Fail to load the TLS settings: %v\n", err)
+			os.Exit(1)
+		}
+		rpcc.SetTLSConfig(tlsConfig)
+	}
 	return rpcc
 }
 
@@ -291,6 +386,35 @@ func (x *CtlCommand) restartProcesses(rpcc *xmlrpcclient.XMLRPCClient, processes
 	x._startStopProcesses(rpcc, "start", processes, "restarted", true)
 }
 
+// restart the processes of the programs or groups one batch at a time, it
+// stops at the first program failed to restart
+func (x *CtlCommand) rollingRestart(rpcc *xmlrpcclient.XMLRPCClient, programs []string, batchSize int) {
+	for _, program := range programs {
+		names, err := rpcc.RollingRestart(program, batchSize)
+		for _, name := range names {
+			fmt.Printf("%s: restarted\n", name)
+		}
+		if err != nil {
+			fmt.Printf("%s: rolling restart failed [%v]\n", program, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// upgrade the supervisord
+func (x *CtlCommand) upgrade(rpcc *xmlrpcclient.XMLRPCClient) {
+	if reply, err := rpcc.Upgrade(); err == nil {
+		if reply.Value {
+			fmt.Printf("Upgrading\n")
+		} else {
+			fmt.Printf("Hmmm! Something gone wrong?!\n")
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
 // shutdown the supervisord
 func (x *CtlCommand) shutdown(rpcc *xmlrpcclient.XMLRPCClient) {
 	if reply, err := rpcc.Shutdown(); err == nil {
@@ -348,6 +472,58 @@ func (x *CtlCommand) signal(rpcc *xmlrpcclient.XMLRPCClient, sigName string, pro
 }
 
 // get the pid of running program
+func (x *CtlCommand) showHistory(rpcc *xmlrpcclient.XMLRPCClient, process string) {
+	runs, err := rpcc.GetProcessHistory(process)
+	if err != nil {
+		fmt.Printf("program '%s' not found\n", process)
+		os.Exit(1)
+	}
+	for _, run := range runs {
+		// the empty string is decoded as raw xml
+		for _, field := range []*string{&run.Signal, &run.Stoppedby, &run.Stderrtail} {
+			if strings.ToLower(*field) == "<string></string>" {
+				*field = ""
+			}
+		}
+		exit := fmt.Sprintf("exit %d", run.Exitstatus)
+		if run.Signal != "" {
+			exit = "signal " + run.Signal
+		}
+		expected := "unexpected"
+		if run.Expected {
+			expected = "expected"
+		}
+		stoppedBy := run.Stoppedby
+		if stoppedBy == "" {
+			stoppedBy = "-"
+		}
+		fmt.Printf("%s  %s  pid %-7d %-14s %-10s stopped by %s\n",
+			time.Unix(int64(run.Start), 0).Format("2006-01-02 15:04:05"),
+			time.Unix(int64(run.Stop), 0).Format("2006-01-02 15:04:05"),
+			run.Pid, exit, expected, stoppedBy)
+		if tail := strings.TrimRight(run.Stderrtail, "\n"); tail != "" {
+			for _, line := range strings.Split(tail, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+}
+
+func (x *CtlCommand) resetState(rpcc *xmlrpcclient.XMLRPCClient, processes []string) {
+	names, err := rpcc.ResetProcessState(processes)
+	if err != nil {
+		fmt.Printf("Fail to reset the state: %v\n", err)
+		os.Exit(1)
+	}
+	if len(names) == 0 {
+		fmt.Println("No program state is reset")
+		return
+	}
+	for _, name := range names {
+		fmt.Printf("%s: state reset, autostart takes effect\n", name)
+	}
+}
+
 func (x *CtlCommand) getPid(rpcc *xmlrpcclient.XMLRPCClient, process string) {
 	procInfo, err := rpcc.GetProcessInfo(process)
 	if err != nil {
@@ -411,14 +587,112 @@ func (x *CtlCommand) inProcessMap(procInfo *types.ProcessInfo, processesMap map[
 	return false
 }
 
-func (x *CtlCommand) logTail(rpcc *xmlrpcclient.XMLRPCClient, program string, logType string) {
-	log, err := rpcc.TailProcessLog(program, 0, 10240, logType)
+func (x *CtlCommand) logTail(rpcc *xmlrpcclient.XMLRPCClient, program string, logType string, node string, lines int, follow bool) {
+	path := fmt.Sprintf("/program/log/%s/%s", url.PathEscape(program), logType)
+	if node != "" {
+		path = fmt.Sprintf("/program/log/%s/%s/%s", url.PathEscape(node), url.PathEscape(program), logType)
+	}
+	path = fmt.Sprintf("%s?lines=%d&follow=%v", path, lines, follow)
+	if lines <= 0 {
+		path = fmt.Sprintf("%s&since=0", path)
+	}
+	body, err := rpcc.GetStream(path)
 	if err != nil {
 		fmt.Printf("Fail to tail log of program %s: %v\n", program, err)
 		os.Exit(1)
 	}
-	os.Stdout.WriteString(log.LogData)
+	defer body.Close()
+	_, _ = io.Copy(os.Stdout, body)
+}
+
+func (x *CtlCommand) grep(rpcc *xmlrpcclient.XMLRPCClient, gc *GrepCommand) {
+	query := url.Values{}
+	query.Set("q", gc.Args.Pattern)
+	query.Set("regexp", fmt.Sprintf("%v", gc.Regexp))
+	query.Set("ignore_case", fmt.Sprintf("%v", gc.IgnoreCase))
+	query.Set("max", fmt.Sprintf("%d", gc.MaxCount))
+	for name, value := range map[string]string{"stream": gc.LogType,
+		"since":    gc.Since,
+		"nodes":    gc.Nodes,
+		"programs": strings.Join(gc.Args.Programs, ",")} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	body, err := rpcc.GetStream("/program/log/search?" + query.Encode())
+	if err != nil {
+		fmt.Printf("Fail to search log: %v\n", err)
+		os.Exit(1)
+	}
+	defer body.Close()
+	result := LogSearchResult{}
+	if err = json.NewDecoder(body).Decode(&result); err != nil {
+		fmt.Printf("Fail to decode the search result: %v\n", err)
+		os.Exit(1)
+	}
+	// the node is shown if the lines are found on more than one node
+	nodes := make(map[string]bool)
+	for _, match := range result.Matches {
+		nodes[match.Node] = true
+	}
+	for _, match := range result.Matches {
+		program := match.Program
+		if len(nodes) > 1 {
+			program = match.Node + "/" + program
+		}
+		fmt.Printf("%s:%s:%s:%d:%s\n", program, match.Stream, match.File, match.Line, match.Text)
+	}
+	for node, err := range result.Errors {
+		fmt.Fprintf(os.Stderr, "Fail to search log on node %s: %s\n", node, err)
+	}
+	if result.Truncated {
+		fmt.Fprintf(os.Stderr, "Only the first %d matched lines of each node are shown\n", gc.MaxCount)
+	}
+	if len(result.Matches) == 0 {
+		os.Exit(1)
+	}
+}
 
+func (x *CtlCommand) audit(rpcc *xmlrpcclient.XMLRPCClient, ac *AuditCommand) {
+	query := url.Values{}
+	query.Set("lines", fmt.Sprintf("%d", ac.Lines))
+	for name, value := range map[string]string{"principal": ac.Principal,
+		"operation": ac.Operation,
+		"since":     ac.Since,
+		"target":    ac.Args.Target} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	body, err := rpcc.GetStream("/supervisor/audit?" + query.Encode())
+	if err != nil {
+		fmt.Printf("Fail to read audit log: %v\n", err)
+		os.Exit(1)
+	}
+	defer body.Close()
+	result := AuditLogResult{}
+	if err = json.NewDecoder(body).Decode(&result); err != nil {
+		fmt.Printf("Fail to decode the audit log: %v\n", err)
+		os.Exit(1)
+	}
+	for _, record := range result.Records {
+		t := record.Time
+		if recordTime, err := time.Parse(time.RFC3339Nano, record.Time); err == nil {
+			t = recordTime.Local().Format("2006-01-02 15:04:05")
+		}
+		target := record.Target
+		if target == "" {
+			target = "-"
+		}
+		line := fmt.Sprintf("%s  %-16s %-22s %-7s %-20s %-16s %s", t, record.Principal, record.Source, record.Transport, record.Operation, target, record.Result)
+		if record.Detail != "" {
+			line += " " + record.Detail
+		}
+		if record.Error != "" {
+			line += ": " + record.Error
+		}
+		fmt.Println(line)
+	}
 }
 
 func (x *CtlCommand) getANSIColor(statename string) string {
@@ -470,12 +744,24 @@ func (rc *RestartCommand) Execute(args []string) error {
 	return nil
 }
 
+// Execute restart the processes of programs or groups a batch at a time
+func (rc *RollingRestartCommand) Execute(args []string) error {
+	ctlCommand.rollingRestart(ctlCommand.createRPCClient(), rc.Args.Programs, rc.BatchSize)
+	return nil
+}
+
 // Execute shutdown the supervisor
 func (sc *ShutdownCommand) Execute(args []string) error {
 	ctlCommand.shutdown(ctlCommand.createRPCClient())
 	return nil
 }
 
+// Execute re-execute the supervisord binary without stopping the programs
+func (uc *UpgradeCommand) Execute(args []string) error {
+	ctlCommand.upgrade(ctlCommand.createRPCClient())
+	return nil
+}
+
 // Execute stop the running programs and reload the supervisor configuration
 func (rc *ReloadCommand) Execute(args []string) error {
 	ctlCommand.reload(ctlCommand.createRPCClient())
@@ -495,9 +781,33 @@ func (pc *PidCommand) Execute(args []string) error {
 	return nil
 }
 
+// Execute show the past runs of program
+func (hc *HistoryCommand) Execute(args []string) error {
+	ctlCommand.showHistory(ctlCommand.createRPCClient(), hc.Args.Program)
+	return nil
+}
+
 // Execute tail the stdout/stderr of a program through http interface
 func (lc *LogtailCommand) Execute(args []string) error {
-	ctlCommand.logTail(ctlCommand.createRPCClient(), lc.Args.Program, lc.LogType)
+	ctlCommand.logTail(ctlCommand.createRPCClient(), lc.Args.Program, lc.LogType, lc.Node, lc.Lines, lc.Follow)
+	return nil
+}
+
+// Execute forget the desired state of programs
+func (rc *ResetStateCommand) Execute(args []string) error {
+	ctlCommand.resetState(ctlCommand.createRPCClient(), rc.Args.Programs)
+	return nil
+}
+
+// Execute search the log files of programs
+func (gc *GrepCommand) Execute(args []string) error {
+	ctlCommand.grep(ctlCommand.createRPCClient(), gc)
+	return nil
+}
+
+// Execute show the records of the audit log
+func (ac *AuditCommand) Execute(args []string) error {
+	ctlCommand.audit(ctlCommand.createRPCClient(), ac)
 	return nil
 }
 
@@ -530,10 +840,18 @@ func init() {
 		"restart programs",
 		"restart one or more programs",
 		&restartCommand)
+	_, _ = ctlCmd.AddCommand("rolling-restart",
+		"restart programs without downtime",
+		"restart the processes of programs, groups or programs with numprocs a batch at a time, each batch waits for the previous one to be running and ready",
+		&rollingRestartCommand)
 	_, _ = ctlCmd.AddCommand("shutdown",
 		"shutdown supervisord",
 		"shutdown supervisord",
 		&shutdownCommand)
+	_, _ = ctlCmd.AddCommand("upgrade",
+		"upgrade supervisord without stopping the programs",
+		"re-execute the supervisord binary, the running programs and the http server sockets are passed to the new supervisord",
+		&upgradeCommand)
 	_, _ = ctlCmd.AddCommand("reload",
 		"reload the programs",
 		"reload the programs",
@@ -550,4 +868,20 @@ func init() {
 		"get the standard output&standard error of the program",
 		"get the standard output&standard error of the program",
 		&logtailCommand)
+	_, _ = ctlCmd.AddCommand("history",
+		"show the past runs of the program",
+		"show the start/stop time, exit status and the stderr tail of the past runs of the program",
+		&historyCommand)
+	_, _ = ctlCmd.AddCommand("grep",
+		"search the log of the programs",
+		"search the current and rotated stdout/stderr log files of the programs on the local and remote nodes",
+		&grepCommand)
+	_, _ = ctlCmd.AddCommand("reset-state",
+		"forget the started/stopped state of programs",
+		"forget the started/stopped state of programs recorded by the manual start/stop, so their autostart takes effect when supervisord is restarted",
+		&resetStateCommand)
+	_, _ = ctlCmd.AddCommand("audit",
+		"show the audit log",
+		"show who started, stopped, signaled, reloaded or shut down what, when and from where, as recorded in the audit_logfile of supervisord",
+		&auditCommand)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ochinchina/supervisord/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// the events streamed if no event type filter is given
+var defaultStreamEvents = []string{"PROCESS_STATE", "PROCESS_GROUP", "SUPERVISOR_STATE_CHANGE", "TICK"}
+
+// EventStream pushes the supervisord events to the http clients with Server-Sent Events
+type EventStream struct {
+	router     *mux.Router
+	supervisor *Supervisor
+}
+
+// StreamEvent the json presentation of an event in the event stream
+type StreamEvent struct {
+	Serial  uint64            `json:"serial"`
+	Type    string            `json:"type"`
+	Node    string            `json:"node"`
+	Time    int64             `json:"time"`
+	Headers map[string]string `json:"headers"`
+	Data    string            `json:"data,omitempty"`
+}
+
+// NewEventStream creates EventStream object
+func NewEventStream(supervisor *Supervisor) *EventStream {
+	return &EventStream{router: mux.NewRouter(), supervisor: supervisor}
+}
+
+// CreateHandler creates http handler to stream the events
+func (es *EventStream) CreateHandler() http.Handler {
+	es.router.HandleFunc("/events/stream", es.StreamEvents).Methods("GET")
+	return es.router
+}
+
+// split comma separated query parameter
+func splitQueryParam(req *http.Request, name string) []string {
+	result := make([]string, 0)
+	for _, value := range req.URL.Query()[name] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// check if the event is about one of the programs. The program can be given
+// as program name, group:program or a pattern like web-*. The events which
+// are not about a program or group, like TICK, always match
+func matchEventPrograms(headers map[string]string, programs []string) bool {
+	processName, hasProcess := headers["processname"]
+	groupName, hasGroup := headers["groupname"]
+	if len(programs) == 0 || (!hasProcess && !hasGroup) {
+		return true
+	}
+	for _, program := range programs {
+		groupPattern, programPattern, ok := strings.Cut(program, ":")
+		if !ok {
+			groupPattern = "*"
+			programPattern = program
+		}
+		if !hasProcess {
+			// the process group events
+			if matched, _ := path.Match(groupPattern, groupName); matched && ok {
+				return true
+			}
+			if matched, _ := path.Match(programPattern, groupName); matched && !ok {
+				return true
+			}
+			continue
+		}
+		groupMatched, _ := path.Match(groupPattern, groupName)
+		programMatched, _ := path.Match(programPattern, processName)
+		if groupMatched && programMatched {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamEvents streams the events with Server-Sent Events. The streamed events
+// can be filtered by the query parameters:
+//
+//	events - comma separated event types, like PROCESS_STATE,TICK_5
+//	programs - comma separated programs, like web,db:*,worker-*
+func (es *EventStream) StreamEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	eventTypes := splitQueryParam(req, "events")
+	if len(eventTypes) == 0 {
+		eventTypes = defaultStreamEvents
+	}
+	programs := splitQueryParam(req, "programs")
+
+	subscription := events.Subscribe(eventTypes, 1000)
+	defer events.Unsubscribe(subscription)
+	log.WithFields(log.Fields{"remote": req.RemoteAddr, "events": strings.Join(eventTypes, ",")}).Info("start to stream events")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	node := es.supervisor.getNodeName()
+	for {
+		select {
+		case <-req.Context().Done():
+			log.WithFields(log.Fields{"remote": req.RemoteAddr, "dropped": subscription.GetDropped()}).Info("stop to stream events")
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-subscription.C:
+			headers, data := events.ParseEventBody(event.GetBody())
+			if !matchEventPrograms(headers, programs) {
+				continue
+			}
+			b, err := json.Marshal(StreamEvent{Serial: event.GetSerial(),
+				Type:    event.GetType(),
+				Node:    node,
+				Time:    time.Now().Unix(),
+				Headers: headers,
+				Data:    data})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.GetSerial(), event.GetType(), b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
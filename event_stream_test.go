@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/events"
+)
+
+func TestSplitQueryParam(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events/stream?events=PROCESS_STATE,+TICK_5&events=SUPERVISOR_STATE_CHANGE,", nil)
+	expected := []string{"PROCESS_STATE", "TICK_5", "SUPERVISOR_STATE_CHANGE"}
+	if result := splitQueryParam(req, "events"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	if result := splitQueryParam(req, "programs"); len(result) != 0 {
+		t.Errorf("expected no programs, got %v", result)
+	}
+}
+
+func TestMatchEventPrograms(t *testing.T) {
+	process := map[string]string{"processname": "web_1", "groupname": "web"}
+	group := map[string]string{"groupname": "backend"}
+	tick := map[string]string{"when": "1700000000"}
+	tests := []struct {
+		headers  map[string]string
+		programs []string
+		expected bool
+	}{
+		{process, nil, true},
+		{process, []string{"web_1"}, true},
+		{process, []string{"web_*"}, true},
+		{process, []string{"web:*"}, true},
+		{process, []string{"web:web_1"}, true},
+		{process, []string{"db", "web_1"}, true},
+		{process, []string{"db"}, false},
+		{process, []string{"backend:*"}, false},
+		{group, []string{"backend:*"}, true},
+		{group, []string{"backend"}, true},
+		{group, []string{"web:*"}, false},
+		{tick, []string{"db"}, true},
+	}
+	for i, test := range tests {
+		if matchEventPrograms(test.headers, test.programs) != test.expected {
+			t.Errorf("%d: expected %v for the headers %v and the programs %v", i, test.expected, test.headers, test.programs)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	s := newTestSupervisor(t, "[inet_http_server]\nport=:9001\nnodename=node1\n")
+	server := httptest.NewServer(NewEventStream(s).CreateHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events/stream?events=PROCESS_STATE&programs=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// the events are subscribed before the response header is sent
+	events.EmitEvent(events.CreateProcessStartingEvent("db", "db", "STOPPED", 0))
+	events.EmitEvent(events.NewTickEvent("TICK_5", time.Now().Unix()))
+	events.EmitEvent(events.CreateProcessStartingEvent("web", "web", "STOPPED", 0))
+
+	received := make(chan []string, 1)
+	go func() {
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() && scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+	var lines []string
+	select {
+	case lines = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event is streamed")
+	}
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id: ") || lines[1] != "event: PROCESS_STATE_STARTING" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("unexpected event %v", lines)
+	}
+	event := StreamEvent{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "PROCESS_STATE_STARTING" || event.Node != "node1" || event.Headers["processname"] != "web" || event.Headers["from_state"] != "STOPPED" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
@@ -282,7 +282,10 @@ var eventTypeDerives = map[string][]string{
 	"TICK_60":                          {"EVENT", "TICK"},
 	"TICK_3600":                        {"EVENT", "TICK"},
 	"PROCESS_GROUP_ADDED":              {"EVENT", "PROCESS_GROUP"},
-	"PROCESS_GROUP_REMOVED":            {"EVENT", "PROCESS_GROUP"}}
+	"PROCESS_GROUP_REMOVED":            {"EVENT", "PROCESS_GROUP"},
+	"PROCESS_LIMIT_EXCEEDED":           {"EVENT", "PROCESS_LIMIT"},
+	"PROCESS_CRASH":                    {"EVENT"},
+	"AUDIT":                            {"EVENT"}}
 var eventSerial uint64
 var eventListenerManager = NewEventListenerManager()
 var eventPoolSerial = NewEventPoolSerial()
@@ -331,20 +334,7 @@ func (em *EventListenerManager) registerEventListener(eventListenerName string,
 	listener *EventListener) {
 
 	em.namedListeners[eventListenerName] = listener
-	allEvents := make(map[string]bool)
-	for _, event := range events {
-		for k, values := range eventTypeDerives {
-			if event == k { // if it is a final event
-				allEvents[k] = true
-			} else { // if it is an abstract event, add all its derived events
-				for _, val := range values {
-					if val == event {
-						allEvents[k] = true
-					}
-				}
-			}
-		}
-	}
+	allEvents := expandEventTypes(events)
 	for event := range allEvents {
 		log.WithFields(log.Fields{"eventListener": eventListenerName, "event": event}).Info("register event listener")
 		if _, ok := em.eventListeners[event]; !ok {
@@ -384,6 +374,7 @@ func UnregisterEventListener(eventListenerName string) *EventListener {
 
 // EmitEvent emits event to all listeners managed by this manager
 func (em *EventListenerManager) EmitEvent(event Event) {
+	subscriptions.emitEvent(event)
 	listeners, ok := em.eventListeners[event.GetType()]
 	if ok {
 		log.WithFields(log.Fields{"event": event.GetType()}).Info("process event")
@@ -737,7 +728,8 @@ func CreateSupervisorStateChangeRunning() *SupervisorStateChangeEvent {
 	return r
 }
 
-func createSupervisorStateChangeStopping() *SupervisorStateChangeEvent {
+// CreateSupervisorStateChangeStopping creates SupervisorStateChangeEvent object
+func CreateSupervisorStateChangeStopping() *SupervisorStateChangeEvent {
 	r := &SupervisorStateChangeEvent{}
 	r.eventType = "SUPERVISOR_STATE_CHANGE_STOPPING"
 	r.serial = nextEventSerial()
@@ -818,3 +810,137 @@ func CreateProcessGroupRemovedEvent(groupName string) *ProcessGroupEvent {
 	r.serial = nextEventSerial()
 	return r
 }
+
+// ProcessLimitEvent the process resource limit event definition
+type ProcessLimitEvent struct {
+	BaseEvent
+	processName string
+	groupName   string
+	pid         int
+	limit       string
+	value       float64
+	threshold   float64
+	action      string
+}
+
+// GetBody returns body of process resource limit event
+func (pe *ProcessLimitEvent) GetBody() string {
+	return fmt.Sprintf("processname:%s groupname:%s pid:%d limit:%s value:%.2f threshold:%.2f action:%s",
+		pe.processName,
+		pe.groupName,
+		pe.pid,
+		pe.limit,
+		pe.value,
+		pe.threshold,
+		pe.action)
+}
+
+// CreateProcessLimitExceededEvent creates the event emitted when the memory
+// or cpu limit of the process is exceeded
+func CreateProcessLimitExceededEvent(processName string,
+	groupName string,
+	pid int,
+	limit string,
+	value float64,
+	threshold float64,
+	action string) *ProcessLimitEvent {
+	r := &ProcessLimitEvent{processName: processName,
+		groupName: groupName,
+		pid:       pid,
+		limit:     limit,
+		value:     value,
+		threshold: threshold,
+		action:    action}
+	r.eventType = "PROCESS_LIMIT_EXCEEDED"
+	r.serial = nextEventSerial()
+	return r
+}
+
+// ProcessCrashEvent the process crash event definition
+type ProcessCrashEvent struct {
+	BaseEvent
+	processName string
+	groupName   string
+	pid         int
+	signal      string
+	coreDumped  int
+	bundle      string
+}
+
+// GetBody returns body of process crash event
+func (pe *ProcessCrashEvent) GetBody() string {
+	return fmt.Sprintf("processname:%s groupname:%s pid:%d signal:%s core_dumped:%d bundle:%s",
+		pe.processName,
+		pe.groupName,
+		pe.pid,
+		pe.signal,
+		pe.coreDumped,
+		pe.bundle)
+}
+
+// CreateProcessCrashEvent creates the event emitted when the process is
+// terminated by a crash signal and its crash bundle is collected
+func CreateProcessCrashEvent(processName string,
+	groupName string,
+	pid int,
+	signal string,
+	coreDumped bool,
+	bundle string) *ProcessCrashEvent {
+	r := &ProcessCrashEvent{processName: processName,
+		groupName: groupName,
+		pid:       pid,
+		signal:    signal,
+		bundle:    bundle}
+	if coreDumped {
+		r.coreDumped = 1
+	}
+	r.eventType = "PROCESS_CRASH"
+	r.serial = nextEventSerial()
+	return r
+}
+
+// AuditEvent the event of a control operation on supervisord
+type AuditEvent struct {
+	BaseEvent
+	principal string
+	source    string
+	transport string
+	operation string
+	target    string
+	result    string
+	message   string
+}
+
+// GetBody returns body of audit event, the detail or error message of the
+// operation is in the second line
+func (ae *AuditEvent) GetBody() string {
+	return fmt.Sprintf("principal:%s source:%s transport:%s operation:%s target:%s result:%s\n%s",
+		ae.principal,
+		ae.source,
+		ae.transport,
+		ae.operation,
+		ae.target,
+		ae.result,
+		ae.message)
+}
+
+// CreateAuditEvent creates the event emitted when a control operation is
+// called through the http servers or a signal
+func CreateAuditEvent(principal string,
+	source string,
+	transport string,
+	operation string,
+	target string,
+	result string,
+	message string) *AuditEvent {
+	r := &AuditEvent{principal: principal,
+		source:    source,
+		transport: transport,
+		operation: operation,
+		target:    target,
+		result:    result,
+		message:   message}
+	r.eventType = "AUDIT"
+	r.serial = nextEventSerial()
+	return r
+}
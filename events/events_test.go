@@ -263,3 +263,13 @@ func TestProcessUnknownEvent(t *testing.T) {
 		t.Error("Fail to encode the process unknown event")
 	}
 }
+
+func TestAuditEvent(t *testing.T) {
+	event := CreateAuditEvent("alice", "127.0.0.1:5000", "xmlrpc", "stopProcess", "web", "failure", "NOT_RUNNING")
+	if event.GetType() != "AUDIT" {
+		t.Error("Fail to creating the audit event")
+	}
+	if event.GetBody() != "principal:alice source:127.0.0.1:5000 transport:xmlrpc operation:stopProcess target:web result:failure\nNOT_RUNNING" {
+		t.Error("Fail to encode the audit event")
+	}
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventSubscription receives the emitted events inside supervisord, for
+// example to push them to the HTTP clients. Unlike the EventListener, the
+// events are dropped if the subscriber does not consume them in time
+type EventSubscription struct {
+	// the subscribed event types, all the events are subscribed if it is empty
+	events map[string]bool
+	// C receives the subscribed events
+	C       chan Event
+	dropped atomic.Uint64
+}
+
+// GetDropped returns number of events dropped because the subscriber is slow
+func (es *EventSubscription) GetDropped() uint64 {
+	return es.dropped.Load()
+}
+
+func (es *EventSubscription) handleEvent(event Event) {
+	if len(es.events) > 0 && !es.events[event.GetType()] {
+		return
+	}
+	select {
+	case es.C <- event:
+	default:
+		es.dropped.Add(1)
+	}
+}
+
+type eventSubscriptions struct {
+	sync.RWMutex
+	subscriptions map[*EventSubscription]bool
+}
+
+var subscriptions = &eventSubscriptions{subscriptions: make(map[*EventSubscription]bool)}
+
+func (s *eventSubscriptions) emitEvent(event Event) {
+	s.RLock()
+	defer s.RUnlock()
+	for subscription := range s.subscriptions {
+		subscription.handleEvent(event)
+	}
+}
+
+// expand the abstract event types (like PROCESS_STATE) to their derived events
+func expandEventTypes(events []string) map[string]bool {
+	allEvents := make(map[string]bool)
+	for _, event := range events {
+		for k, values := range eventTypeDerives {
+			if event == k { // if it is a final event
+				allEvents[k] = true
+			} else { // if it is an abstract event, add all its derived events
+				for _, val := range values {
+					if val == event {
+						allEvents[k] = true
+					}
+				}
+			}
+		}
+	}
+	return allEvents
+}
+
+// Subscribe subscribes the events with type (or abstract type like PROCESS_STATE)
+// in the events. All the events are subscribed if events is empty
+//
+// Args:
+//
+//	events - the event types
+//	bufferSize - how many events can be buffered before they are dropped
+func Subscribe(events []string, bufferSize int) *EventSubscription {
+	subscription := &EventSubscription{events: expandEventTypes(events), C: make(chan Event, bufferSize)}
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+	subscriptions.subscriptions[subscription] = true
+	log.WithFields(log.Fields{"events": strings.Join(events, ",")}).Debug("subscribe events")
+	return subscription
+}
+
+// Unsubscribe stops receiving events by the subscription
+func Unsubscribe(subscription *EventSubscription) {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+	delete(subscriptions.subscriptions, subscription)
+}
+
+// ParseEventBody parses the body of event to the header fields in the first
+// line, like "processname:cat groupname:cat", and the data after the first line
+func ParseEventBody(body string) (map[string]string, string) {
+	headers := make(map[string]string)
+	header, data, _ := strings.Cut(body, "\n")
+	for _, field := range strings.Fields(header) {
+		if key, value, ok := strings.Cut(field, ":"); ok {
+			headers[key] = value
+		}
+	}
+	return headers, data
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestSubscribeAbstractEventType(t *testing.T) {
+	subscription := Subscribe([]string{"PROCESS_STATE"}, 10)
+	defer Unsubscribe(subscription)
+
+	EmitEvent(NewTickEvent("TICK_5", 100))
+	EmitEvent(CreateProcessRunningEvent("proc-1", "group-1", "STARTING", 1234))
+
+	select {
+	case event := <-subscription.C:
+		if event.GetType() != "PROCESS_STATE_RUNNING" {
+			t.Errorf("unexpected event %s", event.GetType())
+		}
+		headers, _ := ParseEventBody(event.GetBody())
+		if headers["processname"] != "proc-1" || headers["groupname"] != "group-1" || headers["pid"] != "1234" {
+			t.Errorf("unexpected event headers %v", headers)
+		}
+	default:
+		t.Error("the subscribed event is not received")
+	}
+}
+
+func TestSubscriptionDropsEventsIfFull(t *testing.T) {
+	subscription := Subscribe([]string{"PROCESS_COMMUNICATION"}, 1)
+	defer Unsubscribe(subscription)
+
+	EmitEvent(NewProcCommEvent("PROCESS_COMMUNICATION_STDOUT", "proc-1", "group-1", 10, "data-1"))
+	EmitEvent(NewProcCommEvent("PROCESS_COMMUNICATION_STDOUT", "proc-1", "group-1", 10, "data-2"))
+
+	if len(subscription.C) != 1 || subscription.GetDropped() != 1 {
+		t.Errorf("expect 1 buffered and 1 dropped event, got %d and %d", len(subscription.C), subscription.GetDropped())
+	}
+}
+
+func TestParseEventBody(t *testing.T) {
+	headers, data := ParseEventBody("processname:cat groupname:cat pid:10\nhello world\n")
+	if len(headers) != 3 || headers["pid"] != "10" || data != "hello world\n" {
+		t.Errorf("fail to parse event body, headers:%v, data:%s", headers, data)
+	}
+}
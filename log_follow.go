@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochinchina/supervisord/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+// the interval to poll the new log data when following the log
+const logFollowInterval = 200 * time.Millisecond
+
+// logFollowParams the parameters of a log follow request
+type logFollowParams struct {
+	// follow the new log data until the client closes the connection
+	follow bool
+	// the offset to start from, -1 if not set
+	since int64
+	// the number of the last lines to start from, 0 if not set
+	lines int
+	// stream the log as server-sent events
+	sse bool
+}
+
+// isFollowLogRequest checks if the log request needs to be served by following
+// the log instead of reading the whole log
+func isFollowLogRequest(req *http.Request) bool {
+	query := req.URL.Query()
+	return query.Get("follow") != "" || query.Get("since") != "" || query.Get("lines") != ""
+}
+
+func parseLogFollowParams(req *http.Request) (*logFollowParams, error) {
+	query := req.URL.Query()
+	params := &logFollowParams{since: -1}
+	var err error
+	if s := query.Get("follow"); s != "" {
+		if params.follow, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid follow parameter: %s", s)
+		}
+	}
+	if s := req.Header.Get("Last-Event-ID"); s != "" {
+		// the SSE client reconnects, continue from the last received offset
+		query.Set("since", s)
+	}
+	if s := query.Get("since"); s != "" {
+		if params.since, err = strconv.ParseInt(s, 10, 64); err != nil || params.since < 0 {
+			return nil, fmt.Errorf("invalid since parameter: %s", s)
+		}
+	}
+	if s := query.Get("lines"); s != "" {
+		if params.lines, err = strconv.Atoi(s); err != nil || params.lines < 0 {
+			return nil, fmt.Errorf("invalid lines parameter: %s", s)
+		}
+	}
+	params.sse = query.Get("format") == "sse" || strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	return params, nil
+}
+
+// followLog streams the program log to the client. The log is sent as chunked
+// plain text or as server-sent events whose id is the log offset after the event
+func (sr *SupervisorRestful) followLog(w http.ResponseWriter, req *http.Request, node string, programName string, logType string) {
+	if node != "" && node != sr.supervisor.getNodeName() {
+		sr.followRemoteLog(w, req, node, programName, logType)
+		return
+	}
+	params, err := parseLogFollowParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	procMgr := sr.supervisor.GetManager()
+	proc := procMgr.Find(programName)
+	if proc == nil {
+		http.Error(w, fmt.Sprintf("no such program %s", programName), http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	getLogger := func() logger.Logger {
+		// the program may be removed by reload
+		proc := procMgr.Find(programName)
+		if proc == nil {
+			return nil
+		}
+		if logType == "stderr" {
+			return proc.StderrLog
+		}
+		return proc.StdoutLog
+	}
+	follower := logger.NewLogFollower(getLogger, params.since, params.lines)
+
+	if params.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Log-Offset", strconv.FormatInt(follower.Offset(), 10))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	done := req.Context().Done()
+	if !params.follow {
+		// read the log available now and return
+		closed := make(chan struct{})
+		close(closed)
+		done = closed
+	}
+	// the incomplete last line is kept until the line is completed in SSE format
+	pending := ""
+	err = follower.Follow(logFollowInterval, done, func(data string, offset int64) error {
+		var err error
+		if params.sse {
+			data = pending + data
+			pos := strings.LastIndex(data, "\n")
+			pending = data[pos+1:]
+			if pos < 0 {
+				return nil
+			}
+			_, err = fmt.Fprintf(w, "id: %d\n", offset-int64(len(pending)))
+			for _, line := range strings.Split(data[:pos], "\n") {
+				if err == nil {
+					_, err = fmt.Fprintf(w, "data: %s\n", line)
+				}
+			}
+			if err == nil {
+				_, err = io.WriteString(w, "\n")
+			}
+		} else {
+			_, err = io.WriteString(w, data)
+		}
+		flusher.Flush()
+		return err
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"program": programName, "log": logType}).Debug("stop following log: ", err)
+	}
+}
+
+// followRemoteLog proxies the log follow request to the remote supervisor
+func (sr *SupervisorRestful) followRemoteLog(w http.ResponseWriter, req *http.Request, node string, programName string, logType string) {
+	url, ok := sr.remoteSupervisors[node]
+	if !ok {
+		http.Error(w, "not a valid node", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	remoteReq, err := http.NewRequestWithContext(req.Context(), "GET", fmt.Sprintf("%s/program/log/%s/%s/%s?%s", url, node, programName, logType, req.URL.RawQuery), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, header := range []string{"Accept", "Last-Event-ID"} {
+		if value := req.Header.Get(header); value != "" {
+			remoteReq.Header.Set(header, value)
+		}
+	}
+	resp, err := http.DefaultClient.Do(remoteReq)
+	if err != nil {
+		log.WithFields(log.Fields{"node": node, "program": programName}).Warn("failed to follow remote log: ", err)
+		http.Error(w, fmt.Sprintf("failed to follow remote %s log: %v", logType, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Cache-Control", "X-Log-Offset"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher.Flush()
+	b := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(b)
+		if n > 0 {
+			if _, werr := w.Write(b[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
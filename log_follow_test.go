@@ -0,0 +1,94 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseLogFollowParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "/program/log/web/stdout?follow=true&since=10&lines=5&format=sse", nil)
+	if !isFollowLogRequest(req) {
+		t.Error("expected the follow log request")
+	}
+	params, err := parseLogFollowParams(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !params.follow || params.since != 10 || params.lines != 5 || !params.sse {
+		t.Errorf("unexpected parameters %+v", params)
+	}
+
+	// the SSE client reconnects from the last received offset
+	req = httptest.NewRequest("GET", "/program/log/web/stdout?follow=1&since=10", nil)
+	req.Header.Set("Last-Event-ID", "20")
+	req.Header.Set("Accept", "text/event-stream")
+	if params, err = parseLogFollowParams(req); err != nil || params.since != 20 || !params.sse {
+		t.Errorf("expected to continue from the last event id, got %+v %v", params, err)
+	}
+
+	req = httptest.NewRequest("GET", "/program/log/web/stdout", nil)
+	if isFollowLogRequest(req) {
+		t.Error("expected the request reading the whole log")
+	}
+	if params, err = parseLogFollowParams(req); err != nil || params.follow || params.since != -1 || params.lines != 0 || params.sse {
+		t.Errorf("unexpected default parameters %+v %v", params, err)
+	}
+
+	for _, query := range []string{"follow=yes", "since=-1", "since=abc", "lines=-2"} {
+		if _, err := parseLogFollowParams(httptest.NewRequest("GET", "/program/log/web/stdout?"+query, nil)); err == nil {
+			t.Errorf("expected %s is invalid", query)
+		}
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "web.log")
+	s := newTestSupervisor(t, "[program:web]\ncommand=/bin/sh -c \"echo hello; exec sleep 100\"\nstartsecs=0\nstdout_logfile="+logFile+"\n")
+	proc := s.procMgr.Find("web")
+	proc.Start(true)
+	defer proc.Stop(true)
+	for i := 0; i < 50; i++ {
+		if fi, err := os.Stat(logFile); err == nil && fi.Size() > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	handler := NewSupervisorRestful(s).CreateProgramHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/program/log/web/stdout?lines=10", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello\n" || w.Header().Get("X-Log-Offset") != "0" {
+		t.Errorf("expected the last lines of the log, got %d %q offset %s", w.Code, w.Body.String(), w.Header().Get("X-Log-Offset"))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/program/log/web/stdout?since=0&format=sse", nil))
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != "id: 6\ndata: hello\n\n" {
+		t.Errorf("expected the log as server-sent events, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/program/log/web/stdout?since=6", nil))
+	if w.Body.String() != "" || w.Header().Get("X-Log-Offset") != "6" {
+		t.Errorf("expected no log after the offset, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/program/log/api/stdout?lines=10", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no such program, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/program/log/web/stdout?lines=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected the invalid parameter, got %d", w.Code)
+	}
+}
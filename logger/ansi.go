@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"sync"
+)
+
+// the incomplete escape sequence longer than this is not an escape sequence
+const maxPendingEscapeBytes = 4096
+
+// StripAnsiLogger removes the ANSI escape sequences like the colors and the
+// cursor movements from the program output before it is written to the
+// underline logger, like strip_ansi of python supervisord. An escape
+// sequence split between two writes is kept until it is completed
+type StripAnsiLogger struct {
+	underlineLogger Logger
+	lock            sync.Mutex
+	pending         []byte
+}
+
+// NewStripAnsiLogger creates StripAnsiLogger object
+func NewStripAnsiLogger(underlineLogger Logger) *StripAnsiLogger {
+	return &StripAnsiLogger{underlineLogger: underlineLogger}
+}
+
+// SetPid sets pid of program
+func (l *StripAnsiLogger) SetPid(pid int) {
+	l.underlineLogger.SetPid(pid)
+}
+
+// Write writes the data without the escape sequences
+func (l *StripAnsiLogger) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	data := append(l.pending, p...)
+	stripped, rest := StripAnsi(data)
+	if len(rest) > maxPendingEscapeBytes {
+		stripped, rest = append(stripped, rest...), nil
+	}
+	l.pending = append([]byte(nil), rest...)
+	if len(stripped) > 0 {
+		if _, err := l.underlineLogger.Write(stripped); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close drops the incomplete escape sequence and closes the underline logger
+func (l *StripAnsiLogger) Close() error {
+	l.lock.Lock()
+	l.pending = nil
+	l.lock.Unlock()
+	return l.underlineLogger.Close()
+}
+
+// ReadLog reads log from the underline logger
+func (l *StripAnsiLogger) ReadLog(offset int64, length int64) (string, error) {
+	return l.underlineLogger.ReadLog(offset, length)
+}
+
+// ReadTailLog tails log from the underline logger
+func (l *StripAnsiLogger) ReadTailLog(offset int64, length int64) (string, int64, bool, error) {
+	return l.underlineLogger.ReadTailLog(offset, length)
+}
+
+// ClearCurLogFile clears current log file
+func (l *StripAnsiLogger) ClearCurLogFile() error {
+	return l.underlineLogger.ClearCurLogFile()
+}
+
+// ClearAllLogFile clears all log files
+func (l *StripAnsiLogger) ClearAllLogFile() error {
+	return l.underlineLogger.ClearAllLogFile()
+}
+
+// Rotate rotates the log files of the underline logger
+func (l *StripAnsiLogger) Rotate() error {
+	return RotateLog(l.underlineLogger)
+}
+
+func (l *StripAnsiLogger) logFileName() string {
+	return GetLogFileName(l.underlineLogger)
+}
+
+// StripAnsi removes the CSI sequences (ESC [ ... final byte), the OSC
+// sequences (ESC ] ... BEL or ESC \) and the two bytes escape sequences from
+// the data. The incomplete escape sequence at the end of the data is
+// returned as rest
+func StripAnsi(data []byte) (stripped []byte, rest []byte) {
+	stripped = make([]byte, 0, len(data))
+	i := 0
+	for i < len(data) {
+		if data[i] != 0x1b {
+			stripped = append(stripped, data[i])
+			i++
+			continue
+		}
+		end := escapeSequenceEnd(data, i)
+		if end < 0 {
+			return stripped, data[i:]
+		}
+		i = end
+	}
+	return stripped, nil
+}
+
+// escapeSequenceEnd gets the end of the escape sequence starting at start,
+// -1 if the sequence is incomplete
+func escapeSequenceEnd(data []byte, start int) int {
+	if start+1 >= len(data) {
+		return -1
+	}
+	switch data[start+1] {
+	case '[':
+		for i := start + 2; i < len(data); i++ {
+			// the parameter and intermediate bytes are in 0x20-0x3f
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i + 1
+			}
+			if data[i] < 0x20 || data[i] > 0x3f {
+				// not a valid sequence, drop the introducer only
+				return start + 2
+			}
+		}
+		return -1
+	case ']':
+		for i := start + 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+			if data[i] == 0x1b && i+1 == len(data) {
+				return -1
+			}
+		}
+		return -1
+	default:
+		return start + 2
+	}
+}
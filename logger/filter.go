@@ -0,0 +1,354 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the redaction pattern name which is replaced with the builtin patterns of
+// the common secrets
+const RedactSecrets = "secrets"
+
+// the builtin patterns of the common secrets, only the captured groups are replaced
+var secretPatterns = []string{
+	`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[:=]\s*["']?([^\s"',;&]+)`,
+	`(?i)authorization:\s*(?:bearer|basic)\s+([^\s"',;]+)`,
+	`(?i)bearer\s+([A-Za-z0-9\-._~+/]+=*)`,
+	`(?:AKIA|ASIA)[0-9A-Z]{16}`,
+	`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`,
+}
+
+// LogFilterConfig the processing rules of the program log lines
+type LogFilterConfig struct {
+	// the regular expressions of the secrets. If the expression has
+	// capturing groups only the groups are replaced, otherwise the whole
+	// match is replaced
+	Redact []string
+	// the text replaces the secrets, default is [REDACTED]
+	RedactReplacement string
+	// only the records matching one of these expressions are logged
+	Include []string
+	// the records matching one of these expressions are not logged
+	Exclude []string
+	// the start line of a multiline record, the lines not matching it are
+	// appended to the previous record
+	MultilineStart string
+	// the max number of lines of a multiline record, default is 500
+	MultilineMaxLines int
+	// the pending multiline record is logged if no more lines come in this
+	// duration, default is 1 second
+	MultilineTimeout time.Duration
+}
+
+// IsEmpty checks if no rule is configured
+func (c *LogFilterConfig) IsEmpty() bool {
+	return len(c.Redact) == 0 && len(c.Include) == 0 && len(c.Exclude) == 0 && c.MultilineStart == ""
+}
+
+// LogFilter applies the redaction, include/exclude filters and multiline
+// grouping to the program output before it is written to the underline
+// logger. Only the complete lines are processed, the incomplete last line is
+// kept until it is completed, the logger is closed or it is longer than
+// maxPendingLineBytes. With multiline
+// grouping each record is written to the underline logger separately, so one
+// PROCESS_LOG event is emitted and one entry is shipped per record
+type LogFilter struct {
+	underlineLogger Logger
+	redact          []*regexp.Regexp
+	replacement     string
+	include         []*regexp.Regexp
+	exclude         []*regexp.Regexp
+	multilineStart  *regexp.Regexp
+	maxLines        int
+	timeout         time.Duration
+	lock            sync.Mutex
+	pending         []byte
+	// the lines of the multiline record not written yet
+	record []string
+	timer  *time.Timer
+}
+
+func compilePatterns(patterns []string, expandSecrets bool) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0)
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		if expandSecrets && strings.TrimSpace(pattern) == RedactSecrets {
+			for _, p := range secretPatterns {
+				result = append(result, regexp.MustCompile(p))
+			}
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// NewLogFilter creates LogFilter object. The underline logger is returned
+// directly if no rule is configured
+func NewLogFilter(underlineLogger Logger, config LogFilterConfig) (Logger, error) {
+	if config.IsEmpty() {
+		return underlineLogger, nil
+	}
+	lf := &LogFilter{underlineLogger: underlineLogger,
+		replacement: config.RedactReplacement,
+		maxLines:    config.MultilineMaxLines,
+		timeout:     config.MultilineTimeout,
+		pending:     make([]byte, 0)}
+	var err error
+	if lf.redact, err = compilePatterns(config.Redact, true); err != nil {
+		return nil, err
+	}
+	if lf.include, err = compilePatterns(config.Include, false); err != nil {
+		return nil, err
+	}
+	if lf.exclude, err = compilePatterns(config.Exclude, false); err != nil {
+		return nil, err
+	}
+	if config.MultilineStart != "" {
+		if lf.multilineStart, err = regexp.Compile(config.MultilineStart); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", config.MultilineStart, err)
+		}
+	}
+	if lf.replacement == "" {
+		lf.replacement = "[REDACTED]"
+	}
+	if lf.maxLines <= 0 {
+		lf.maxLines = 500
+	}
+	if lf.timeout <= 0 {
+		lf.timeout = time.Second
+	}
+	return lf, nil
+}
+
+// SetPid sets pid of the program
+func (lf *LogFilter) SetPid(pid int) {
+	lf.underlineLogger.SetPid(pid)
+}
+
+// Write splits the data into lines and writes the processed complete lines
+func (lf *LogFilter) Write(p []byte) (int, error) {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	lf.pending = append(lf.pending, p...)
+	pos := bytes.LastIndexByte(lf.pending, '\n')
+	if pos < 0 && len(lf.pending) <= maxPendingLineBytes {
+		return len(p), nil
+	}
+	lines := make([]string, 0)
+	if pos >= 0 {
+		lines = strings.Split(string(lf.pending[:pos]), "\n")
+		lf.pending = append(lf.pending[:0], lf.pending[pos+1:]...)
+	}
+	// the incomplete line longer than the limit is processed as a line
+	if len(lf.pending) > maxPendingLineBytes {
+		lines = append(lines, string(lf.pending))
+		lf.pending = make([]byte, 0)
+	}
+	if lf.multilineStart == nil {
+		// the lines of one write are written together as before
+		buf := bytes.Buffer{}
+		for _, line := range lines {
+			if record, ok := lf.process(strings.TrimSuffix(line, "\r")); ok {
+				buf.WriteString(record)
+				buf.WriteByte('\n')
+			}
+		}
+		if buf.Len() > 0 {
+			if _, err := lf.underlineLogger.Write(buf.Bytes()); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if lf.multilineStart.MatchString(line) || len(lf.record) >= lf.maxLines {
+			lf.flushRecord()
+		}
+		lf.record = append(lf.record, line)
+	}
+	lf.resetTimer()
+	return len(p), nil
+}
+
+// resetTimer writes the pending record if no more lines come in time
+func (lf *LogFilter) resetTimer() {
+	if lf.timer != nil {
+		lf.timer.Stop()
+	}
+	if len(lf.record) == 0 {
+		return
+	}
+	lf.timer = time.AfterFunc(lf.timeout, func() {
+		lf.lock.Lock()
+		defer lf.lock.Unlock()
+		lf.flushRecord()
+	})
+}
+
+// flushRecord writes the pending multiline record as one record
+func (lf *LogFilter) flushRecord() {
+	if len(lf.record) == 0 {
+		return
+	}
+	record, ok := lf.process(strings.Join(lf.record, "\n"))
+	lf.record = lf.record[:0]
+	if ok {
+		writeLogRecord(lf.underlineLogger, record)
+	}
+}
+
+// process filters and redacts the record, returns false if the record
+// should not be logged
+func (lf *LogFilter) process(record string) (string, bool) {
+	if len(lf.include) > 0 && !matchAny(lf.include, record) {
+		return "", false
+	}
+	if matchAny(lf.exclude, record) {
+		return "", false
+	}
+	for _, re := range lf.redact {
+		record = redact(re, record, lf.replacement)
+	}
+	return record, true
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces the captured groups of the matches or the whole matches if
+// the pattern has no capturing group
+func redact(re *regexp.Regexp, s string, replacement string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, replacement)
+	}
+	buf := strings.Builder{}
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(match); i += 2 {
+			start, end := match[i], match[i+1]
+			if start < last || start < 0 {
+				continue
+			}
+			buf.WriteString(s[last:start])
+			buf.WriteString(replacement)
+			last = end
+		}
+	}
+	buf.WriteString(s[last:])
+	return buf.String()
+}
+
+// Close writes the pending record and the incomplete last line and closes
+// the underline logger
+func (lf *LogFilter) Close() error {
+	lf.lock.Lock()
+	if lf.timer != nil {
+		lf.timer.Stop()
+	}
+	if len(lf.pending) > 0 {
+		if lf.multilineStart != nil && !lf.multilineStart.MatchString(string(lf.pending)) {
+			lf.record = append(lf.record, string(lf.pending))
+		} else {
+			lf.flushRecord()
+			lf.record = append(lf.record, string(lf.pending))
+		}
+		lf.pending = lf.pending[:0]
+	}
+	lf.flushRecord()
+	lf.lock.Unlock()
+	return lf.underlineLogger.Close()
+}
+
+// ReadLog reads log from the underline logger
+func (lf *LogFilter) ReadLog(offset int64, length int64) (string, error) {
+	return lf.underlineLogger.ReadLog(offset, length)
+}
+
+// ReadTailLog tails log from the underline logger
+func (lf *LogFilter) ReadTailLog(offset int64, length int64) (string, int64, bool, error) {
+	return lf.underlineLogger.ReadTailLog(offset, length)
+}
+
+// ClearCurLogFile clears current log file
+func (lf *LogFilter) ClearCurLogFile() error {
+	return lf.underlineLogger.ClearCurLogFile()
+}
+
+// ClearAllLogFile clears all log files
+func (lf *LogFilter) ClearAllLogFile() error {
+	return lf.underlineLogger.ClearAllLogFile()
+}
+
+// Rotate rotates the log files of the underline logger
+func (lf *LogFilter) Rotate() error {
+	return RotateLog(lf.underlineLogger)
+}
+
+func (lf *LogFilter) logFileName() string {
+	return GetLogFileName(lf.underlineLogger)
+}
+
+// logRecordWriter is implemented by the loggers which keep a multiline
+// record as one entry
+type logRecordWriter interface {
+	writeRecord(record string) error
+}
+
+// writeLogRecord writes a record without the trailing newline to the logger
+func writeLogRecord(logger Logger, record string) error {
+	if rw, ok := logger.(logRecordWriter); ok {
+		return rw.writeRecord(record)
+	}
+	_, err := logger.Write([]byte(record + "\n"))
+	return err
+}
+
+func (cl *CompositeLogger) writeRecord(record string) error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	var err error
+	for i, logger := range cl.loggers {
+		if e := writeLogRecord(logger, record); i == 0 {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeRecord writes the record as one formatted line
+func (l *FormatLogger) writeRecord(record string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	buf := bytes.Buffer{}
+	l.formatLine(&buf, record)
+	_, err := l.underlineLogger.Write(buf.Bytes())
+	return err
+}
+
+// writeRecord ships the record as one entry
+func (sl *SinkLogger) writeRecord(record string) error {
+	sl.logEventEmitter.emitLogEvent(record + "\n")
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.enqueue(logEntry{time: time.Now(), line: record})
+	return nil
+}
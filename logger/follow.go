@@ -0,0 +1,261 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logFileNamer is implemented by the loggers which write to a log file
+type logFileNamer interface {
+	logFileName() string
+}
+
+func (l *FileLogger) logFileName() string {
+	return l.name
+}
+
+func (l *LogCaptureLogger) logFileName() string {
+	return GetLogFileName(l.underlineLogger)
+}
+
+func (cl *CompositeLogger) logFileName() string {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if len(cl.loggers) == 0 {
+		return ""
+	}
+	return GetLogFileName(cl.loggers[0])
+}
+
+// GetLogFileName returns the name of the file the logger writes to, or
+// empty string if the logger does not write to a file
+func GetLogFileName(logger Logger) string {
+	if namer, ok := logger.(logFileNamer); ok {
+		return namer.logFileName()
+	}
+	return ""
+}
+
+// LogFollower follows the log of a program like "tail -F". The new log
+// data is read with Logger.ReadTailLog from the last returned offset.
+//
+// If the logger writes to a file, the follower detects the log rotation
+// (the current file is renamed to <name>.1 or a date stamped backup) and
+// reads the rest of the rotated file before continuing from the beginning of the new file. The
+// truncation of the log file (clear log) is also detected.
+type LogFollower struct {
+	// the logger is re-created when the program is restarted
+	getLogger func() Logger
+	offset    int64
+	fileInfo  os.FileInfo
+	chunkSize int64
+}
+
+// NewLogFollower creates LogFollower object.
+//
+// If since is not less than 0, the log is followed from the offset since.
+// Otherwise if lines is greater than 0, the follow starts from last lines
+// of the log. Otherwise only the log written after now is followed.
+func NewLogFollower(getLogger func() Logger, since int64, lines int) *LogFollower {
+	lf := &LogFollower{getLogger: getLogger, offset: 0, chunkSize: 64 * 1024}
+	logger := getLogger()
+	if logger == nil {
+		if since > 0 {
+			lf.offset = since
+		}
+		return lf
+	}
+	fileName := GetLogFileName(logger)
+	if fileName != "" {
+		lf.fileInfo, _ = os.Stat(fileName)
+	}
+	if since >= 0 {
+		lf.offset = since
+	} else if lines > 0 && fileName != "" {
+		lf.offset = findTailLinesOffset(fileName, lines)
+	} else if lines <= 0 {
+		lf.offset = lf.endOffset(logger, fileName)
+	}
+	return lf
+}
+
+// Offset returns the offset of next log data
+func (lf *LogFollower) Offset() int64 {
+	return lf.offset
+}
+
+func (lf *LogFollower) endOffset(logger Logger, fileName string) int64 {
+	if fileName != "" {
+		if lf.fileInfo == nil {
+			return 0
+		}
+		return lf.fileInfo.Size()
+	}
+	_, offset, _, err := logger.ReadTailLog(0, math.MaxInt32)
+	if err != nil {
+		return 0
+	}
+	return offset
+}
+
+// ReadNew reads the log data written since last read. Empty string is
+// returned if no new log data is available
+func (lf *LogFollower) ReadNew() (string, error) {
+	logger := lf.getLogger()
+	if logger == nil {
+		return "", nil
+	}
+	fileName := GetLogFileName(logger)
+	if fileName == "" {
+		return lf.readNew(logger)
+	}
+
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		// the log file is not created yet or it is being rotated
+		return "", nil
+	}
+	if lf.fileInfo != nil && !os.SameFile(lf.fileInfo, fileInfo) {
+		// the log file is rotated, read the rest of the rotated file
+		rest := lf.readRotatedLog(fileName)
+		lf.fileInfo = fileInfo
+		lf.offset = 0
+		if rest != "" {
+			return rest, nil
+		}
+	}
+	lf.fileInfo = fileInfo
+	if fileInfo.Size() < lf.offset {
+		// the log file is truncated
+		lf.offset = 0
+	}
+	return lf.readNew(logger)
+}
+
+func (lf *LogFollower) readNew(logger Logger) (string, error) {
+	data, offset, _, err := logger.ReadTailLog(lf.offset, lf.chunkSize)
+	if err != nil {
+		return "", err
+	}
+	if offset < lf.offset {
+		// the log is cleared
+		lf.offset = offset
+		return "", nil
+	}
+	lf.offset = offset
+	return data, nil
+}
+
+// findRotatedLog finds the backup of the followed log file, it is
+// <name>.1 or a date stamped backup
+func (lf *LogFollower) findRotatedLog(fileName string) *os.File {
+	candidates := []string{fmt.Sprintf("%s.1", fileName)}
+	if matches, err := filepath.Glob(fileName + ".*"); err == nil {
+		candidates = append(candidates, matches...)
+	}
+	for _, candidate := range candidates {
+		if !backupSuffixPattern.MatchString(strings.TrimPrefix(candidate, fileName)) {
+			continue
+		}
+		f, err := os.Open(candidate)
+		if err != nil {
+			continue
+		}
+		if fileInfo, err := f.Stat(); err == nil && os.SameFile(lf.fileInfo, fileInfo) {
+			return f
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// readRotatedLog reads the data after the current offset from the rotated log file
+func (lf *LogFollower) readRotatedLog(fileName string) string {
+	f := lf.findRotatedLog(fileName)
+	if f == nil {
+		return ""
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil || fileInfo.Size() <= lf.offset {
+		return ""
+	}
+	if _, err = f.Seek(lf.offset, io.SeekStart); err != nil {
+		return ""
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Follow calls the callback with the new log data until the callback
+// returns error or the done channel is closed
+func (lf *LogFollower) Follow(interval time.Duration, done <-chan struct{}, callback func(data string, offset int64) error) error {
+	for {
+		data, err := lf.ReadNew()
+		if err != nil {
+			return err
+		}
+		if data != "" {
+			if err = callback(data, lf.offset); err != nil {
+				return err
+			}
+			// more data may be available
+			continue
+		}
+		select {
+		case <-done:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// findTailLinesOffset finds the offset of last lines in the file
+func findTailLinesOffset(fileName string, lines int) int64 {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	offset := fileInfo.Size()
+	b := make([]byte, 4096)
+	// the newline at the end of file does not start a new line
+	skipLast := true
+	for offset > 0 {
+		n := int64(len(b))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		if _, err = f.ReadAt(b[:n], offset); err != nil {
+			return 0
+		}
+		for i := n - 1; i >= 0; i-- {
+			if b[i] != '\n' {
+				skipLast = false
+				continue
+			}
+			if skipLast {
+				skipLast = false
+				continue
+			}
+			lines--
+			if lines == 0 {
+				return offset + i + 1
+			}
+		}
+	}
+	return 0
+}
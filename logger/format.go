@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the supported formats of FormatLogger
+const (
+	LogFormatRaw      = "raw"
+	LogFormatJSON     = "json"
+	LogFormatLogfmt   = "logfmt"
+	LogFormatPrefixed = "prefixed"
+)
+
+// the incomplete line longer than this is written as a line
+const maxPendingLineBytes = 64 * 1024
+
+// FormatLogger splits the program output into lines and writes each line
+// decorated with the time, program, group, pid and stream to the underline
+// logger in json, logfmt or prefixed format. The incomplete last line is
+// kept until it is completed, the logger is closed or it is longer than
+// maxPendingLineBytes
+type FormatLogger struct {
+	underlineLogger Logger
+	format          string
+	programName     string
+	groupName       string
+	// stdout or stderr
+	stream  string
+	lock    sync.Mutex
+	pid     int
+	pending []byte
+	// returns the current time, it can be replaced in test
+	now func() time.Time
+}
+
+// jsonLogLine the line written in json format
+type jsonLogLine struct {
+	Time    string `json:"time"`
+	Program string `json:"program"`
+	Group   string `json:"group"`
+	Pid     int    `json:"pid"`
+	Stream  string `json:"stream"`
+	Message string `json:"message"`
+}
+
+// IsValidLogFormat checks if the log format is supported
+func IsValidLogFormat(format string) bool {
+	switch format {
+	case LogFormatRaw, LogFormatJSON, LogFormatLogfmt, LogFormatPrefixed:
+		return true
+	}
+	return false
+}
+
+// NewFormatLogger creates FormatLogger object. The underline logger is
+// returned directly if the format is empty or raw
+func NewFormatLogger(underlineLogger Logger, format string, programName string, groupName string, stream string) Logger {
+	if format == "" || format == LogFormatRaw {
+		return underlineLogger
+	}
+	return &FormatLogger{underlineLogger: underlineLogger,
+		format:      format,
+		programName: programName,
+		groupName:   groupName,
+		stream:      stream,
+		pending:     make([]byte, 0),
+		now:         time.Now}
+}
+
+// SetPid sets pid of program
+func (l *FormatLogger) SetPid(pid int) {
+	l.lock.Lock()
+	l.pid = pid
+	l.lock.Unlock()
+	l.underlineLogger.SetPid(pid)
+}
+
+// Write splits the data into lines and writes the formatted complete lines
+func (l *FormatLogger) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.pending = append(l.pending, p...)
+	pos := bytes.LastIndexByte(l.pending, '\n')
+	if pos < 0 && len(l.pending) <= maxPendingLineBytes {
+		return len(p), nil
+	}
+	buf := bytes.Buffer{}
+	if pos >= 0 {
+		for _, line := range bytes.Split(l.pending[:pos], []byte{'\n'}) {
+			l.formatLine(&buf, string(bytes.TrimSuffix(line, []byte{'\r'})))
+		}
+		l.pending = append(l.pending[:0], l.pending[pos+1:]...)
+	}
+	if len(l.pending) > maxPendingLineBytes {
+		l.formatLine(&buf, string(l.pending))
+		l.pending = make([]byte, 0)
+	}
+	if _, err := l.underlineLogger.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the incomplete last line and closes the underline logger
+func (l *FormatLogger) Close() error {
+	l.lock.Lock()
+	if len(l.pending) > 0 {
+		buf := bytes.Buffer{}
+		l.formatLine(&buf, string(l.pending))
+		l.pending = l.pending[:0]
+		l.underlineLogger.Write(buf.Bytes())
+	}
+	l.lock.Unlock()
+	return l.underlineLogger.Close()
+}
+
+func (l *FormatLogger) formatLine(buf *bytes.Buffer, line string) {
+	now := l.now().Format(time.RFC3339Nano)
+	switch l.format {
+	case LogFormatJSON:
+		b, _ := json.Marshal(&jsonLogLine{Time: now,
+			Program: l.programName,
+			Group:   l.groupName,
+			Pid:     l.pid,
+			Stream:  l.stream,
+			Message: line})
+		buf.Write(b)
+	case LogFormatLogfmt:
+		fmt.Fprintf(buf, "time=%s program=%s group=%s pid=%d stream=%s msg=%s",
+			now,
+			logfmtValue(l.programName),
+			logfmtValue(l.groupName),
+			l.pid,
+			l.stream,
+			logfmtValue(line))
+	default:
+		fmt.Fprintf(buf, "%s %s:%s[%d] %s | %s", now, l.groupName, l.programName, l.pid, l.stream, line)
+	}
+	buf.WriteByte('\n')
+}
+
+// logfmtValue quotes the value if it is empty or contains space, quote or equal sign
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"=\\") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// ReadLog reads log from the underline logger
+func (l *FormatLogger) ReadLog(offset int64, length int64) (string, error) {
+	return l.underlineLogger.ReadLog(offset, length)
+}
+
+// ReadTailLog tails log from the underline logger
+func (l *FormatLogger) ReadTailLog(offset int64, length int64) (string, int64, bool, error) {
+	return l.underlineLogger.ReadTailLog(offset, length)
+}
+
+// ClearCurLogFile clears current log file
+func (l *FormatLogger) ClearCurLogFile() error {
+	return l.underlineLogger.ClearCurLogFile()
+}
+
+// ClearAllLogFile clears all log files
+func (l *FormatLogger) ClearAllLogFile() error {
+	return l.underlineLogger.ClearAllLogFile()
+}
+
+func (l *FormatLogger) logFileName() string {
+	return GetLogFileName(l.underlineLogger)
+}
@@ -4,10 +4,15 @@ go 1.26.0
 
 toolchain go1.26.5
 
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ochinchina/supervisord/events v0.0.0-20260817032106-ebc9ca97cf12
+	github.com/robfig/cron/v3 v3.0.1
+)
+
 require (
 	github.com/gorilla/rpc v1.2.1 // indirect
 	github.com/ochinchina/gorilla-xmlrpc v0.0.0-20171012055324-ecf2fe693a2c // indirect
-	github.com/ochinchina/supervisord/events v0.0.0-20260817032106-ebc9ca97cf12
 	github.com/rogpeppe/go-charset v0.0.0-20190617161244-0dc95cdf6f31 // indirect
 	github.com/sirupsen/logrus v1.9.4 // indirect
 	golang.org/x/sys v0.47.0 // indirect
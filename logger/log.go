@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ochinchina/supervisord/events"
 	"github.com/ochinchina/supervisord/faults"
@@ -38,6 +39,9 @@ type FileLogger struct {
 	file            *os.File
 	logEventEmitter LogEventEmitter
 	locker          sync.Locker
+	// protects the switch of log file by the rotation, the locker may be a NullLocker
+	rotateLock   sync.Mutex
+	rotatePolicy *rotatePolicy
 }
 
 // SysLogger log program stdout/stderr to syslog
@@ -187,6 +191,7 @@ func (l *FileLogger) openFile(trunc bool) error {
 	fileInfo, err := os.Stat(l.name)
 
 	if trunc || err != nil {
+		l.fileSize = 0
 		l.file, err = os.Create(l.name)
 	} else {
 		l.fileSize = fileInfo.Size()
@@ -198,18 +203,6 @@ func (l *FileLogger) openFile(trunc bool) error {
 	return err
 }
 
-func (l *FileLogger) backupFiles() {
-	for i := l.backups - 1; i > 0; i-- {
-		src := fmt.Sprintf("%s.%d", l.name, i)
-		dest := fmt.Sprintf("%s.%d", l.name, i+1)
-		if _, err := os.Stat(src); err == nil {
-			os.Rename(src, dest)
-		}
-	}
-	dest := fmt.Sprintf("%s.1", l.name)
-	os.Rename(l.name, dest)
-}
-
 // ClearCurLogFile clears contents (re-open with truncate) of current log file
 func (l *FileLogger) ClearCurLogFile() error {
 	l.locker.Lock()
@@ -223,14 +216,13 @@ func (l *FileLogger) ClearAllLogFile() error {
 	l.locker.Lock()
 	defer l.locker.Unlock()
 
-	for i := l.backups; i > 0; i-- {
-		logFile := fmt.Sprintf("%s.%d", l.name, i)
-		_, err := os.Stat(logFile)
-		if err == nil {
-			err = os.Remove(logFile)
-			if err != nil {
-				return faults.NewFault(faults.Failed, err.Error())
-			}
+	if l.rotatePolicy != nil {
+		l.rotatePolicy.compressing.Wait()
+	}
+	for _, logFile := range l.listBackups() {
+		err := os.Remove(logFile)
+		if err != nil {
+			return faults.NewFault(faults.Failed, err.Error())
 		}
 	}
 	err := l.openFile(true)
@@ -349,7 +341,12 @@ func (l *FileLogger) ReadTailLog(offset int64, length int64) (string, int64, boo
 func (l *FileLogger) Write(p []byte) (int, error) {
 	l.locker.Lock()
 	defer l.locker.Unlock()
+	l.rotateLock.Lock()
+	defer l.rotateLock.Unlock()
 
+	if l.rotatePolicy != nil && l.fileSize > 0 && l.rotatePolicy.isRotateTime(time.Now()) {
+		l.rotate()
+	}
 	n, err := l.file.Write(p)
 
 	if err != nil {
@@ -357,6 +354,10 @@ func (l *FileLogger) Write(p []byte) (int, error) {
 	}
 	l.logEventEmitter.emitLogEvent(string(p))
 	l.fileSize += int64(n)
+	if l.maxSize <= 0 {
+		// the log file is not rotated by size
+		return n, err
+	}
 	if l.fileSize >= l.maxSize {
 		fileInfo, errStat := os.Stat(l.name)
 		if errStat == nil {
@@ -366,15 +367,23 @@ func (l *FileLogger) Write(p []byte) (int, error) {
 		}
 	}
 	if l.fileSize >= l.maxSize {
-		l.Close()
-		l.backupFiles()
-		l.openFile(true)
+		l.rotate()
 	}
 	return n, err
 }
 
 // Close file logger
 func (l *FileLogger) Close() error {
+	l.rotateLock.Lock()
+	defer l.rotateLock.Unlock()
+	if l.rotatePolicy != nil {
+		l.rotatePolicy.stopRotateTimer()
+	}
+	return l.closeFile()
+}
+
+// closeFile closes the current log file, it is opened again after rotation
+func (l *FileLogger) closeFile() error {
 	if l.file != nil {
 		err := l.file.Close()
 		l.file = nil
@@ -804,8 +813,19 @@ func createLogger(programName string, logFile string, locker sync.Locker, maxByt
 				return NewRemoteSysLogger(programName, fields[1], props, logEventEmitter)
 			}
 		}
+		if IsLogSink(logFile) {
+			sinkLogger, err := NewSinkLogger(programName, logFile, props, logEventEmitter)
+			if err == nil {
+				return sinkLogger
+			}
+			fmt.Printf("Fail to create log sink %s with error %v\n", logFile, err)
+			return NewNullLogger(logEventEmitter)
+		}
 		if len(logFile) > 0 {
-			return NewFileLogger(logFile, maxBytes, backups, logEventEmitter, locker)
+			fileLogger := NewFileLogger(logFile, maxBytes, backups, logEventEmitter, locker)
+			fileLogger.rotatePolicy = newRotatePolicy(props)
+			fileLogger.startRotateTimer()
+			return fileLogger
 		}
 		return NewNullLogger(logEventEmitter)
 
@@ -0,0 +1,410 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/robfig/cron/v3"
+)
+
+// the props of NewLogger to configure the rotation of the log file
+const (
+	// hourly, daily, weekly, monthly or a cron expression
+	PropRotateInterval = "logfile_rotate_interval"
+	// index (name.1, name.2, ...) or date (name.20060102-150405)
+	PropBackupName = "logfile_backup_name"
+	// none, gzip or zstd
+	PropCompress = "logfile_compress"
+	// the max total size of the backups
+	PropMaxTotalBytes = "logfile_max_total_bytes"
+)
+
+// the suffixes of the compressed backups
+var compressSuffixes = map[string]string{"gzip": ".gz", "zstd": ".zst"}
+
+// the backup is like name.1, name.1.gz, name.20060102-150405 or name.20060102-150405-1.zst
+var backupSuffixPattern = regexp.MustCompile(`^\.(\d+|\d{8}-\d{6}(-\d+)?)(\.gz|\.zst)?$`)
+
+// rotatePolicy the time based rotation, the naming, compression and size
+// limit of the backups of a FileLogger
+type rotatePolicy struct {
+	schedule       cron.Schedule
+	nextRotateTime time.Time
+	dateBackupName bool
+	compress       string
+	maxTotalBytes  int64
+	// the background compression of the backups
+	compressing sync.WaitGroup
+	// closed when the last backup is compressed, the backups are compressed
+	// one by one in the order of the rotations
+	lastCompress chan struct{}
+	// protects the removal of the backups
+	pruneLock sync.Mutex
+	// rotates the log file on schedule even if nothing is written
+	rotateTimer *time.Timer
+	closed      bool
+}
+
+// ParseRotateInterval parses the logfile_rotate_interval, it can be hourly,
+// daily, weekly, monthly or a cron expression like "0 */6 * * *"
+func ParseRotateInterval(interval string) (cron.Schedule, error) {
+	interval = strings.TrimSpace(interval)
+	switch interval {
+	case "hourly", "daily", "weekly", "monthly":
+		interval = "@" + interval
+	}
+	return cron.ParseStandard(interval)
+}
+
+// ValidateRotateProps checks the log rotation props
+func ValidateRotateProps(props map[string]string) error {
+	if s := props[PropRotateInterval]; s != "" {
+		if _, err := ParseRotateInterval(s); err != nil {
+			return fmt.Errorf("invalid %s %s: %v", PropRotateInterval, s, err)
+		}
+	}
+	switch s := props[PropBackupName]; s {
+	case "", "index", "date":
+	default:
+		return fmt.Errorf("invalid %s %s", PropBackupName, s)
+	}
+	switch s := props[PropCompress]; s {
+	case "", "none", "gzip", "zstd":
+	default:
+		return fmt.Errorf("invalid %s %s", PropCompress, s)
+	}
+	if s := props[PropMaxTotalBytes]; s != "" {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s %s", PropMaxTotalBytes, s)
+		}
+	}
+	return nil
+}
+
+// newRotatePolicy creates rotatePolicy from the props, returns nil if no
+// rotation props are set. The backups are named with date by default if
+// the rotation interval is set
+func newRotatePolicy(props map[string]string) *rotatePolicy {
+	if props[PropRotateInterval] == "" && props[PropBackupName] == "" && props[PropCompress] == "" && props[PropMaxTotalBytes] == "" {
+		return nil
+	}
+	policy := &rotatePolicy{compress: props[PropCompress]}
+	if s := props[PropRotateInterval]; s != "" {
+		schedule, err := ParseRotateInterval(s)
+		if err == nil {
+			policy.schedule = schedule
+			policy.nextRotateTime = schedule.Next(time.Now())
+		} else {
+			fmt.Printf("Invalid %s %s: %v\n", PropRotateInterval, s, err)
+		}
+	}
+	switch props[PropBackupName] {
+	case "date":
+		policy.dateBackupName = true
+	case "":
+		policy.dateBackupName = policy.schedule != nil
+	}
+	if s := props[PropMaxTotalBytes]; s != "" {
+		policy.maxTotalBytes, _ = strconv.ParseInt(s, 10, 64)
+	}
+	return policy
+}
+
+// isRotateTime checks if it is time to rotate the log file
+func (rp *rotatePolicy) isRotateTime(now time.Time) bool {
+	return rp.schedule != nil && !now.Before(rp.nextRotateTime)
+}
+
+// startRotateTimer starts the timer rotating the log file on schedule
+func (l *FileLogger) startRotateTimer() {
+	l.rotateLock.Lock()
+	defer l.rotateLock.Unlock()
+	policy := l.rotatePolicy
+	if policy == nil || policy.schedule == nil {
+		return
+	}
+	policy.rotateTimer = time.AfterFunc(time.Until(policy.nextRotateTime), l.rotateOnSchedule)
+}
+
+// rotateOnSchedule rotates the log file if it is time to rotate, the empty
+// log file is not rotated
+func (l *FileLogger) rotateOnSchedule() {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	l.rotateLock.Lock()
+	defer l.rotateLock.Unlock()
+	policy := l.rotatePolicy
+	if policy.closed {
+		return
+	}
+	now := time.Now()
+	if policy.isRotateTime(now) {
+		if l.fileSize > 0 {
+			l.rotate()
+		} else {
+			policy.nextRotateTime = policy.schedule.Next(now)
+		}
+	}
+	policy.rotateTimer.Reset(time.Until(policy.nextRotateTime))
+}
+
+// stopRotateTimer stops the timer when the log file is closed
+func (rp *rotatePolicy) stopRotateTimer() {
+	rp.closed = true
+	if rp.rotateTimer != nil {
+		rp.rotateTimer.Stop()
+	}
+}
+
+// Rotate rotates the log file now
+func (l *FileLogger) Rotate() error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	l.rotateLock.Lock()
+	defer l.rotateLock.Unlock()
+
+	return l.rotate()
+}
+
+// rotate backups the current log file and opens a new one
+func (l *FileLogger) rotate() error {
+	l.closeFile()
+	l.backupFiles()
+	return l.openFile(true)
+}
+
+func (l *FileLogger) backupFiles() {
+	policy := l.rotatePolicy
+	if policy == nil {
+		l.backupIndexFiles()
+		return
+	}
+	now := time.Now()
+	if policy.schedule != nil {
+		policy.nextRotateTime = policy.schedule.Next(now)
+	}
+	suffix, ok := compressSuffixes[policy.compress]
+	if !ok {
+		if policy.dateBackupName {
+			os.Rename(l.name, l.nextDateBackupName(now))
+		} else {
+			l.backupIndexFiles()
+		}
+		l.pruneBackups()
+		return
+	}
+	// the log file is renamed to a name which is never renamed again, so
+	// the writer doesn't wait for the compression of the previous backups.
+	// The index backups are shifted after the previous backups are compressed
+	var staged string
+	if policy.dateBackupName {
+		staged = l.nextDateBackupName(now)
+	} else {
+		staged = fmt.Sprintf("%s.rotating-%d", l.name, now.UnixNano())
+	}
+	os.Rename(l.name, staged)
+	prev := policy.lastCompress
+	done := make(chan struct{})
+	policy.lastCompress = done
+	policy.compressing.Add(1)
+	go func() {
+		defer policy.compressing.Done()
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		backup := staged
+		if !policy.dateBackupName {
+			l.shiftIndexFiles()
+			backup = fmt.Sprintf("%s.1", l.name)
+			os.Rename(staged, backup)
+		}
+		if err := compressFile(backup, backup+suffix, policy.compress); err != nil {
+			fmt.Printf("Fail to compress log file --%s-- with error %v\n", backup, err)
+		}
+		l.pruneBackups()
+	}()
+}
+
+// backupIndexFiles renames name.N-1 to name.N, ..., name to name.1
+func (l *FileLogger) backupIndexFiles() {
+	l.shiftIndexFiles()
+	dest := fmt.Sprintf("%s.1", l.name)
+	os.Rename(l.name, dest)
+}
+
+// shiftIndexFiles renames name.N-1 to name.N, ..., name.1 to name.2, the
+// oldest backup is removed
+func (l *FileLogger) shiftIndexFiles() {
+	suffixes := []string{"", ".gz", ".zst"}
+	if l.backups > 0 {
+		// the oldest backup is replaced
+		for _, suffix := range suffixes {
+			os.Remove(fmt.Sprintf("%s.%d%s", l.name, l.backups, suffix))
+		}
+	}
+	for i := l.backups - 1; i > 0; i-- {
+		for _, suffix := range suffixes {
+			src := fmt.Sprintf("%s.%d%s", l.name, i, suffix)
+			dest := fmt.Sprintf("%s.%d%s", l.name, i+1, suffix)
+			if _, err := os.Stat(src); err == nil {
+				os.Rename(src, dest)
+			}
+		}
+	}
+}
+
+// nextDateBackupName returns the backup name stamped with the time, a
+// sequence number is appended if the log file is rotated more than once in
+// a second
+func (l *FileLogger) nextDateBackupName(now time.Time) string {
+	base := fmt.Sprintf("%s.%s", l.name, now.Format("20060102-150405"))
+	name := base
+	for i := 1; ; i++ {
+		if matches, _ := filepath.Glob(name + "*"); len(matches) == 0 {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// listBackups returns the backups of the log file, the latest one is the first
+func (l *FileLogger) listBackups() []string {
+	return listLogBackups(l.name)
+}
+
+// listLogBackups returns the backups of the log file, the latest one is the first
+func listLogBackups(name string) []string {
+	matches, err := filepath.Glob(name + ".*")
+	if err != nil {
+		return nil
+	}
+	backups := make([]string, 0)
+	modTimes := make(map[string]time.Time)
+	for _, match := range matches {
+		if !backupSuffixPattern.MatchString(strings.TrimPrefix(match, name)) {
+			continue
+		}
+		fileInfo, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, match)
+		modTimes[match] = fileInfo.ModTime()
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return modTimes[backups[i]].After(modTimes[backups[j]])
+	})
+	return backups
+}
+
+// pruneBackups removes the oldest backups if there are more than backups
+// date stamped backups or the total size exceeds logfile_max_total_bytes
+func (l *FileLogger) pruneBackups() {
+	policy := l.rotatePolicy
+	policy.pruneLock.Lock()
+	defer policy.pruneLock.Unlock()
+
+	var total int64
+	for i, backup := range l.listBackups() {
+		if policy.dateBackupName && l.backups > 0 && i >= l.backups {
+			os.Remove(backup)
+			continue
+		}
+		fileInfo, err := os.Stat(backup)
+		if err != nil {
+			continue
+		}
+		total += fileInfo.Size()
+		if policy.maxTotalBytes > 0 && total > policy.maxTotalBytes {
+			os.Remove(backup)
+		}
+	}
+}
+
+// compressFile compresses the file with gzip or zstd and removes the
+// original file. The compressed file keeps the modification time of the
+// original one
+func compressFile(src string, dest string, method string) error {
+	fileInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser
+	if method == "zstd" {
+		w, err = zstd.NewWriter(out)
+	} else {
+		w = gzip.NewWriter(out)
+	}
+	if err == nil {
+		_, err = io.Copy(w, in)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	os.Chtimes(dest, fileInfo.ModTime(), fileInfo.ModTime())
+	return os.Remove(src)
+}
+
+// logRotator is implemented by the loggers whose log files can be rotated
+type logRotator interface {
+	Rotate() error
+}
+
+// RotateLog rotates the log files of the logger now. Nothing is done if the
+// logger does not write to a file
+func RotateLog(logger Logger) error {
+	if rotator, ok := logger.(logRotator); ok {
+		return rotator.Rotate()
+	}
+	return nil
+}
+
+// Rotate rotates the log files of all the loggers
+func (cl *CompositeLogger) Rotate() error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	var err error
+	for _, logger := range cl.loggers {
+		if e := RotateLog(logger); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Rotate rotates the log files of the underline logger
+func (l *LogCaptureLogger) Rotate() error {
+	return RotateLog(l.underlineLogger)
+}
+
+// Rotate rotates the log files of the underline logger
+func (l *FormatLogger) Rotate() error {
+	return RotateLog(l.underlineLogger)
+}
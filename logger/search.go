@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// LogMatch a line of the log file matching the search
+type LogMatch struct {
+	File string
+	// the offset of the line in the file, or in the decompressed content of
+	// the compressed backup
+	Offset int64
+	// the line number starting from 1
+	Line int
+	// the timestamp of the line, zero if the line has no known timestamp
+	Time time.Time
+	Text string
+}
+
+// the timestamp layouts at the beginning of the line without time zone
+var lineTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006/01/02 15:04:05"}
+
+// parseLineTime gets the timestamp of the line written by the log formats or
+// starting with a common timestamp
+func parseLineTime(line string) (time.Time, bool) {
+	if strings.HasPrefix(line, "{") {
+		jsonLine := struct {
+			Time string `json:"time"`
+		}{}
+		if json.Unmarshal([]byte(line), &jsonLine) != nil {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, jsonLine.Time)
+		return t, err == nil
+	}
+	// the logfmt line starts with time=
+	line = strings.TrimPrefix(line, "time=")
+	token := strings.SplitN(line, " ", 2)[0]
+	if t, err := time.Parse(time.RFC3339Nano, token); err == nil {
+		return t, true
+	}
+	for _, layout := range lineTimeLayouts {
+		if len(line) >= len(layout) {
+			if t, err := time.ParseInLocation(layout, line[0:len(layout)], time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// openLogFile opens the log file, the compressed backup is decompressed
+func openLogFile(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(name, compressSuffixes["gzip"]):
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &gzipFileReader{Reader: r, file: f}, nil
+	case strings.HasSuffix(name, compressSuffixes["zstd"]):
+		r, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &zstdFileReader{Decoder: r, file: f}, nil
+	}
+	return f, nil
+}
+
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+type zstdFileReader struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (r *zstdFileReader) Close() error {
+	r.Decoder.Close()
+	return r.file.Close()
+}
+
+// SearchLogFile searches the lines matching the pattern in the log file. The
+// lines with timestamp before since are skipped, the lines without timestamp
+// are skipped if the file is not modified after since. fn is called for each
+// matched line and the search stops if it returns false. Returns false if the
+// search is stopped by fn
+func SearchLogFile(name string, pattern *regexp.Regexp, since time.Time, fn func(*LogMatch) bool) (bool, error) {
+	fileInfo, err := os.Stat(name)
+	if err != nil {
+		return true, err
+	}
+	if !since.IsZero() && fileInfo.ModTime().Before(since) {
+		return true, nil
+	}
+	r, err := openLogFile(name)
+	if err != nil {
+		return true, err
+	}
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if pattern.MatchString(text) {
+				t, _ := parseLineTime(text)
+				if since.IsZero() || t.IsZero() || !t.Before(since) {
+					if !fn(&LogMatch{File: name, Offset: offset, Line: lineNo, Time: t, Text: text}) {
+						return false, nil
+					}
+				}
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// SearchLog searches the lines matching the pattern in the log file of the
+// logger and its rotated backups, the oldest backup is searched first.
+// Nothing is searched if the logger does not write to a file
+func SearchLog(logger Logger, pattern *regexp.Regexp, since time.Time, fn func(*LogMatch) bool) error {
+	name := GetLogFileName(logger)
+	if name == "" {
+		return nil
+	}
+	backups := listLogBackups(name)
+	files := make([]string, 0, len(backups)+1)
+	for i := len(backups) - 1; i >= 0; i-- {
+		files = append(files, backups[i])
+	}
+	files = append(files, name)
+	for _, file := range files {
+		next, err := SearchLogFile(file, pattern, since, fn)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !next {
+			return nil
+		}
+	}
+	return nil
+}
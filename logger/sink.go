@@ -0,0 +1,517 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the props of NewLogger to configure the log sinks
+const (
+	// the max number of lines sent in one request, default is 500
+	PropSinkBatchSize = "logsink_batch_size"
+	// the max seconds a line waits in the batch, default is 1
+	PropSinkFlushInterval = "logsink_flush_interval"
+	// the max number of lines buffered, the new lines are dropped if the buffer is full, default is 10000
+	PropSinkBufferSize = "logsink_buffer_size"
+	// the max number of retries of a failed batch before it is dropped, default is 5
+	PropSinkMaxRetries = "logsink_max_retries"
+	// the timeout of a request in seconds, default is 10
+	PropSinkTimeout = "logsink_timeout"
+	// the extra labels of loki stream or attributes of otlp log records, like "env=prod,region=eu"
+	PropSinkLabels = "logsink_labels"
+	// the extra http headers, like "Authorization=Bearer xxx,X-Scope-OrgID=tenant1"
+	PropSinkHeaders = "logsink_headers"
+	// the group of the program, it is set by the process
+	PropLogGroup = "log_group"
+	// stdout or stderr, it is set by the process
+	PropLogStream = "log_stream"
+)
+
+// the delay of first retry, it is doubled after each retry until logSinkMaxRetryDelay
+var logSinkRetryDelay = 500 * time.Millisecond
+
+const logSinkMaxRetryDelay = 30 * time.Second
+
+// how long Close waits the buffered lines to be sent
+const logSinkCloseTimeout = 5 * time.Second
+
+// LogSinkStats the statistics of a log sink
+type LogSinkStats struct {
+	Program string
+	// the sink url without the user information and the query
+	Sink string
+	// the number of lines sent
+	Sent uint64
+	// the number of lines dropped because the buffer is full or the retries are exhausted
+	Dropped uint64
+	// the number of failed requests
+	Failed uint64
+	// the number of lines waiting to be sent
+	Buffered int64
+}
+
+type logSinkCounters struct {
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+	buffered atomic.Int64
+}
+
+// the counters are kept when the program is restarted and its loggers are re-created
+var logSinkRegistry = struct {
+	sync.Mutex
+	counters map[[2]string]*logSinkCounters
+}{counters: make(map[[2]string]*logSinkCounters)}
+
+func getLogSinkCounters(programName string, sink string) *logSinkCounters {
+	logSinkRegistry.Lock()
+	defer logSinkRegistry.Unlock()
+	key := [2]string{programName, sink}
+	counters, ok := logSinkRegistry.counters[key]
+	if !ok {
+		counters = &logSinkCounters{}
+		logSinkRegistry.counters[key] = counters
+	}
+	return counters
+}
+
+// GetLogSinkStats returns the statistics of the log sinks of the program
+func GetLogSinkStats(programName string) []LogSinkStats {
+	logSinkRegistry.Lock()
+	defer logSinkRegistry.Unlock()
+	stats := make([]LogSinkStats, 0)
+	for key, counters := range logSinkRegistry.counters {
+		if key[0] != programName {
+			continue
+		}
+		stats = append(stats, LogSinkStats{Program: key[0],
+			Sink:     key[1],
+			Sent:     counters.sent.Load(),
+			Dropped:  counters.dropped.Load(),
+			Failed:   counters.failed.Load(),
+			Buffered: counters.buffered.Load()})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Sink < stats[j].Sink })
+	return stats
+}
+
+// IsLogSink checks if the log file is a log sink url
+func IsLogSink(logFile string) bool {
+	for _, scheme := range []string{"http://", "https://", "loki://", "lokis://", "otlp://", "otlps://"} {
+		if strings.HasPrefix(logFile, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// logEntry a line of the program log
+type logEntry struct {
+	time time.Time
+	line string
+}
+
+// SinkLogger ships the program log lines to a log collector in batches:
+//
+//   - http(s)://host/path posts a json array of lines
+//   - loki(s)://host[:port][/path] pushes to the Loki push API, the default
+//     port is 3100 and the default path is /loki/api/v1/push
+//   - otlp(s)://host[:port][/path] exports with OTLP/HTTP json, the default
+//     port is 4318 and the default path is /v1/logs
+//
+// The lines are buffered in a bounded buffer and sent in background. The
+// failed batch is retried with exponential backoff, the lines are dropped if
+// the buffer is full or the retries are exhausted
+type SinkLogger struct {
+	NullLogger
+	logEventEmitter LogEventEmitter
+	programName     string
+	groupName       string
+	stream          string
+	kind            string
+	endpoint        string
+	labels          map[string]string
+	headers         map[string]string
+	client          *http.Client
+	batchSize       int
+	flushInterval   time.Duration
+	maxRetries      int
+	counters        *logSinkCounters
+	entries         chan logEntry
+	// closed when all the lines are sent after the logger is closed
+	done      chan struct{}
+	closeOnce sync.Once
+	lock      sync.Mutex
+	pid       int
+	pending   []byte
+}
+
+// ValidateSinkProps checks the log sink props
+func ValidateSinkProps(props map[string]string) error {
+	for _, name := range []string{PropSinkBatchSize, PropSinkBufferSize, PropSinkMaxRetries} {
+		if s := props[name]; s != "" {
+			if v, err := strconv.Atoi(s); err != nil || v < 0 {
+				return fmt.Errorf("invalid %s %s", name, s)
+			}
+		}
+	}
+	for _, name := range []string{PropSinkFlushInterval, PropSinkTimeout} {
+		if s := props[name]; s != "" {
+			if v, err := strconv.ParseFloat(s, 64); err != nil || v <= 0 {
+				return fmt.Errorf("invalid %s %s", name, s)
+			}
+		}
+	}
+	for _, name := range []string{PropSinkLabels, PropSinkHeaders} {
+		if _, err := parseKeyValues(props[name]); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// parseKeyValues parses comma separated key=value pairs
+func parseKeyValues(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%s is not in key=value format", pair)
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
+func getIntProp(props map[string]string, name string, defValue int) int {
+	if v, err := strconv.Atoi(props[name]); err == nil && v >= 0 {
+		return v
+	}
+	return defValue
+}
+
+func getSecondsProp(props map[string]string, name string, defValue float64) time.Duration {
+	v, err := strconv.ParseFloat(props[name], 64)
+	if err != nil || v <= 0 {
+		v = defValue
+	}
+	return time.Duration(v * float64(time.Second))
+}
+
+// parseLogSinkURL returns the kind of the sink, the http endpoint and the
+// sink name used in the metrics
+func parseLogSinkURL(logFile string) (string, string, string, error) {
+	u, err := url.Parse(logFile)
+	if err != nil {
+		return "", "", "", err
+	}
+	kind := "http"
+	defPort, defPath := "", ""
+	switch u.Scheme {
+	case "http", "https":
+	case "loki", "lokis":
+		kind, defPort, defPath = "loki", "3100", "/loki/api/v1/push"
+	case "otlp", "otlps":
+		kind, defPort, defPath = "otlp", "4318", "/v1/logs"
+	default:
+		return "", "", "", fmt.Errorf("unsupported log sink %s", logFile)
+	}
+	if kind != "http" {
+		if strings.HasSuffix(u.Scheme, "s") {
+			u.Scheme = "https"
+		} else {
+			u.Scheme = "http"
+		}
+		if u.Port() == "" {
+			u.Host = u.Host + ":" + defPort
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = defPath
+		}
+	}
+	name := fmt.Sprintf("%s://%s%s", strings.SplitN(logFile, "://", 2)[0], u.Host, u.Path)
+	return kind, u.String(), name, nil
+}
+
+// NewSinkLogger creates SinkLogger object, the log file is the url of the sink
+func NewSinkLogger(programName string, logFile string, props map[string]string, logEventEmitter LogEventEmitter) (*SinkLogger, error) {
+	kind, endpoint, name, err := parseLogSinkURL(logFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateSinkProps(props); err != nil {
+		return nil, err
+	}
+	labels, _ := parseKeyValues(props[PropSinkLabels])
+	headers, _ := parseKeyValues(props[PropSinkHeaders])
+	groupName := props[PropLogGroup]
+	if groupName == "" {
+		groupName = programName
+	}
+	stream := props[PropLogStream]
+	if stream == "" {
+		stream = "stdout"
+	}
+	sl := &SinkLogger{logEventEmitter: logEventEmitter,
+		programName:   programName,
+		groupName:     groupName,
+		stream:        stream,
+		kind:          kind,
+		endpoint:      endpoint,
+		labels:        labels,
+		headers:       headers,
+		client:        &http.Client{Timeout: getSecondsProp(props, PropSinkTimeout, 10)},
+		batchSize:     getIntProp(props, PropSinkBatchSize, 500),
+		flushInterval: getSecondsProp(props, PropSinkFlushInterval, 1),
+		maxRetries:    getIntProp(props, PropSinkMaxRetries, 5),
+		counters:      getLogSinkCounters(programName, name),
+		entries:       make(chan logEntry, getIntProp(props, PropSinkBufferSize, 10000)),
+		done:          make(chan struct{}),
+		pending:       make([]byte, 0)}
+	if sl.batchSize <= 0 {
+		sl.batchSize = 1
+	}
+	go sl.run()
+	return sl, nil
+}
+
+// SetPid sets pid of the program
+func (sl *SinkLogger) SetPid(pid int) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.pid = pid
+}
+
+// Write splits the data into lines and buffers the complete lines
+func (sl *SinkLogger) Write(p []byte) (int, error) {
+	sl.logEventEmitter.emitLogEvent(string(p))
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.pending = append(sl.pending, p...)
+	pos := bytes.LastIndexByte(sl.pending, '\n')
+	if pos < 0 {
+		return len(p), nil
+	}
+	now := time.Now()
+	for _, line := range bytes.Split(sl.pending[:pos], []byte{'\n'}) {
+		sl.enqueue(logEntry{time: now, line: string(bytes.TrimSuffix(line, []byte{'\r'}))})
+	}
+	sl.pending = append(sl.pending[:0], sl.pending[pos+1:]...)
+	return len(p), nil
+}
+
+// enqueue buffers the entry or drops it if the buffer is full
+func (sl *SinkLogger) enqueue(entry logEntry) {
+	select {
+	case sl.entries <- entry:
+		sl.counters.buffered.Add(1)
+	default:
+		sl.counters.dropped.Add(1)
+	}
+}
+
+// Close sends the incomplete last line and waits the buffered lines to be
+// sent for a while
+func (sl *SinkLogger) Close() error {
+	sl.closeOnce.Do(func() {
+		sl.lock.Lock()
+		if len(sl.pending) > 0 {
+			sl.enqueue(logEntry{time: time.Now(), line: string(sl.pending)})
+			sl.pending = sl.pending[:0]
+		}
+		close(sl.entries)
+		sl.lock.Unlock()
+	})
+	select {
+	case <-sl.done:
+	case <-time.After(logSinkCloseTimeout):
+	}
+	return nil
+}
+
+// run sends the buffered lines in batches until the logger is closed
+func (sl *SinkLogger) run() {
+	defer close(sl.done)
+	ticker := time.NewTicker(sl.flushInterval)
+	defer ticker.Stop()
+	batch := make([]logEntry, 0, sl.batchSize)
+	for {
+		select {
+		case entry, ok := <-sl.entries:
+			if !ok {
+				sl.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= sl.batchSize {
+				sl.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			sl.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush sends the batch, the failed batch is retried with exponential backoff
+func (sl *SinkLogger) flush(batch []logEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	n := uint64(len(batch))
+	defer sl.counters.buffered.Add(-int64(n))
+	body, contentType, err := sl.encode(batch)
+	if err != nil {
+		sl.counters.dropped.Add(n)
+		return
+	}
+	delay := logSinkRetryDelay
+	for retry := 0; ; retry++ {
+		retryable := true
+		if retryable, err = sl.post(body, contentType); err == nil {
+			sl.counters.sent.Add(n)
+			return
+		}
+		sl.counters.failed.Add(1)
+		if !retryable || retry >= sl.maxRetries {
+			fmt.Printf("Fail to send %d log lines of %s to %s: %v\n", n, sl.programName, sl.endpoint, err)
+			sl.counters.dropped.Add(n)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > logSinkMaxRetryDelay {
+			delay = logSinkMaxRetryDelay
+		}
+	}
+}
+
+// post sends the request body, returns if the failed request can be retried
+func (sl *SinkLogger) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest("POST", sl.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range sl.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := sl.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	// the client errors other than rate limiting are not retried
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("status code %d", resp.StatusCode)
+}
+
+// encode encodes the batch in the format of the sink
+func (sl *SinkLogger) encode(batch []logEntry) ([]byte, string, error) {
+	sl.lock.Lock()
+	pid := sl.pid
+	sl.lock.Unlock()
+	switch sl.kind {
+	case "loki":
+		labels := map[string]string{"program": sl.programName, "group": sl.groupName, "stream": sl.stream}
+		for k, v := range sl.labels {
+			labels[k] = v
+		}
+		values := make([][2]string, 0, len(batch))
+		for _, entry := range batch {
+			values = append(values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+		}
+		b, err := json.Marshal(map[string]interface{}{
+			"streams": []interface{}{map[string]interface{}{"stream": labels, "values": values}}})
+		return b, "application/json", err
+	case "otlp":
+		return sl.encodeOTLP(batch, pid)
+	default:
+		lines := make([]jsonLogLine, 0, len(batch))
+		for _, entry := range batch {
+			lines = append(lines, jsonLogLine{Time: entry.time.Format(time.RFC3339Nano),
+				Program: sl.programName,
+				Group:   sl.groupName,
+				Pid:     pid,
+				Stream:  sl.stream,
+				Message: entry.line})
+		}
+		b, err := json.Marshal(lines)
+		return b, "application/json", err
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, otlpAttribute{Key: k, Value: otlpValue{StringValue: attrs[k]}})
+	}
+	return result
+}
+
+// encodeOTLP encodes the batch as OTLP/HTTP json ExportLogsServiceRequest
+func (sl *SinkLogger) encodeOTLP(batch []logEntry, pid int) ([]byte, string, error) {
+	severity := "INFO"
+	severityNumber := 9
+	if sl.stream == "stderr" {
+		severity = "ERROR"
+		severityNumber = 17
+	}
+	attributes := otlpAttributes(map[string]string{"log.iostream": sl.stream})
+	records := make([]map[string]interface{}, 0, len(batch))
+	for _, entry := range batch {
+		ts := strconv.FormatInt(entry.time.UnixNano(), 10)
+		records = append(records, map[string]interface{}{
+			"timeUnixNano":         ts,
+			"observedTimeUnixNano": ts,
+			"severityNumber":       severityNumber,
+			"severityText":         severity,
+			"body":                 otlpValue{StringValue: entry.line},
+			"attributes":           attributes})
+	}
+	resource := map[string]string{"service.name": sl.programName,
+		"service.namespace": sl.groupName,
+		"process.pid":       strconv.Itoa(pid)}
+	for k, v := range sl.labels {
+		resource[k] = v
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(resource)},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]string{"name": "supervisord"},
+				"logRecords": records}}}}})
+	return b, "application/json", err
+}
@@ -7,14 +7,19 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"unicode"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/ochinchina/go-ini"
 	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/events"
 	"github.com/ochinchina/supervisord/logger"
+	"github.com/ochinchina/supervisord/process"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -51,6 +56,12 @@ func initSignals(s *Supervisor) {
 		sig := <-sigs
 		fmt.Println("receive a signal to stop all process & exit:", sig)
 		log.WithFields(log.Fields{"signal": sig}).Info("receive a signal to stop all process & exit")
+		if sig == syscall.SIGINT {
+			s.auditSignal("SIGINT", "shutdown", nil)
+		} else {
+			s.auditSignal("SIGTERM", "shutdown", nil)
+		}
+		events.EmitEvent(events.CreateSupervisorStateChangeStopping())
 		s.procMgr.StopAllProcesses()
 		os.Exit(-1)
 	}()
@@ -131,12 +142,25 @@ func findSupervisordConf() (string, error) {
 	return "", fmt.Errorf("fail to find supervisord.conf")
 }
 
+// the running supervisor, it is re-created by restart
+var currentSupervisor atomic.Pointer[Supervisor]
+var usr2SignalOnce sync.Once
+
 func initServer() (*Supervisor, error) {
 	loadEnvFile()
+	loadUpgradeState()
 	if len(options.Configuration) <= 0 {
 		options.Configuration, _ = findSupervisordConf()
 	}
 	s := NewSupervisor(options.Configuration)
+	currentSupervisor.Store(s)
+	usr2SignalOnce.Do(func() {
+		initUSR2Signal(func() {
+			if s := currentSupervisor.Load(); s != nil {
+				s.handleUSR2Signal()
+			}
+		})
+	})
 	if _, _, _, sErr := s.Reload(true); sErr != nil {
 		panic(sErr)
 	}
@@ -169,7 +193,33 @@ func getSupervisordLogFile(configFile string) string {
 	}
 }
 
+// isDaemonMode checks if supervisord runs as daemon. It runs as daemon with
+// the -d option, otherwise only if nodaemon of the supervisord section is
+// set to false
+func isDaemonMode(configFile string) bool {
+	if options.Daemon {
+		return true
+	}
+	myini := ini.NewIni()
+	myini.LoadFile(configFile)
+	noDaemon, err := strconv.ParseBool(myini.GetValueWithDefault("supervisord", "nodaemon", ""))
+	return err == nil && !noDaemon
+}
+
+// Get the directory which supervisord changes to when it runs as daemon
+func getSupervisordDirectory(configFile string) string {
+	env := config.NewStringExpression("here", filepath.Dir(configFile))
+	myini := ini.NewIni()
+	myini.LoadFile(configFile)
+	directory, err := env.Eval(myini.GetValueWithDefault("supervisord", "directory", ""))
+	if err != nil {
+		return ""
+	}
+	return directory
+}
+
 func main() {
+	process.RunRlimitHelper()
 	if BuildVersion != "" {
 		version = BuildVersion
 	}
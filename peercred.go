@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/ochinchina/supervisord/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type peerCredentialsKey struct{}
+
+// peerCredentials the uid and gid of the process connected to the unix socket
+// http server
+type peerCredentials struct {
+	uid uint32
+	gid uint32
+}
+
+// withPeerCredentials attaches the credentials of the peer process of the unix
+// socket connection to the context of the connection
+func withPeerCredentials(ctx context.Context, conn net.Conn) context.Context {
+	uid, gid, err := getPeerCredentials(conn)
+	if err != nil {
+		log.Debug("fail to get the credentials of the peer process: ", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, &peerCredentials{uid: uid, gid: gid})
+}
+
+// getRequestPeerCredentials gets the credentials of the peer process of the
+// request, nil if the request is not from the unix socket http server
+func getRequestPeerCredentials(r *http.Request) *peerCredentials {
+	cred, _ := r.Context().Value(peerCredentialsKey{}).(*peerCredentials)
+	return cred
+}
+
+// addPeerRole gives the role to the users in peer_users and the groups in
+// peer_groups of the role section, they are names or numeric ids
+func (ac *accessControl) addPeerRole(role *accessRole, entry *config.Entry) error {
+	for _, name := range entry.GetStringArray("peer_users", ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		uid, err := lookupID(name, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		ac.peerUsers[uid] = append(ac.peerUsers[uid], role)
+	}
+	for _, name := range entry.GetStringArray("peer_groups", ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		gid, err := lookupID(name, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		ac.peerGroups[gid] = append(ac.peerGroups[gid], role)
+	}
+	return nil
+}
+
+// lookupID gets the numeric id or looks up the id of the user or group name
+func lookupID(name string, lookup func(string) (string, error)) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
+
+// authenticatePeer gets the user of the peer process with the roles of its
+// uid, its gid and the supplementary groups of its user. Returns false if no
+// role is given to them
+func (ac *accessControl) authenticatePeer(cred *peerCredentials) (*requestUser, bool) {
+	roles := append([]*accessRole(nil), ac.peerUsers[cred.uid]...)
+	gids := map[uint32]bool{cred.gid: true}
+	name := strconv.FormatUint(uint64(cred.uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+		if groupIds, err := u.GroupIds(); err == nil {
+			for _, groupID := range groupIds {
+				if gid, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+					gids[uint32(gid)] = true
+				}
+			}
+		}
+	}
+	for gid := range gids {
+		roles = append(roles, ac.peerGroups[gid]...)
+	}
+	if len(roles) == 0 {
+		return nil, false
+	}
+	return &requestUser{name: "unix:" + name, roles: roles}, true
+}
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// getPeerCredentials gets the uid and gid of the peer process of the unix
+// socket connection by SO_PEERCRED
+func getPeerCredentials(conn net.Conn) (uint32, uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, fmt.Errorf("not a unix socket connection")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var cred *unix.Ucred
+	if ctrlErr := rawConn.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); ctrlErr != nil {
+		return 0, 0, ctrlErr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return cred.Uid, cred.Gid, nil
+}
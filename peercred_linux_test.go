@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPeerCredentials(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "supervisord.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	uid, gid, err := getPeerCredentials(conn)
+	if err != nil || uid != uint32(os.Getuid()) || gid != uint32(os.Getgid()) {
+		t.Errorf("expected the uid %d and the gid %d of the peer, got %d %d %v", os.Getuid(), os.Getgid(), uid, gid, err)
+	}
+
+	r := httptest.NewRequest("GET", "/program/list", nil)
+	r.RemoteAddr = "@"
+	r = r.WithContext(withPeerCredentials(context.Background(), conn))
+	if cred := getRequestPeerCredentials(r); cred == nil || cred.uid != uid {
+		t.Errorf("expected the credentials of the peer are attached to the request, got %+v", cred)
+	}
+	if source := getRequestSource(r); source != fmt.Sprintf("unix:uid=%d", uid) {
+		t.Errorf("unexpected source %s", source)
+	}
+}
+
+func TestGetPeerCredentialsOfTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := getPeerCredentials(conn); err == nil {
+		t.Error("expected no peer credentials of the tcp connection")
+	}
+	ctx := withPeerCredentials(context.Background(), conn)
+	if getRequestPeerCredentials(httptest.NewRequest("GET", "/", nil).WithContext(ctx)) != nil {
+		t.Error("expected no peer credentials are attached")
+	}
+}
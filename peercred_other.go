@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// getPeerCredentials is not supported on this platform
+func getPeerCredentials(conn net.Conn) (uint32, uint32, error) {
+	return 0, 0, fmt.Errorf("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestLookupID(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}
+	if id, err := lookupID("1234", lookup); err != nil || id != 1234 {
+		t.Errorf("expected the numeric id 1234, got %d %v", id, err)
+	}
+	if id, err := lookupID("root", lookup); err != nil || id != 0 {
+		t.Errorf("expected the uid 0 of root, got %d %v", id, err)
+	}
+	if _, err := lookupID("no-such-user-of-supervisord", lookup); err == nil {
+		t.Error("expected an error for the unknown user")
+	}
+}
+
+func TestAuthenticatePeer(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	s := newTestSupervisor(t, "[program:db]\ncommand=/bin/cat\n\n[program:api]\ncommand=/bin/cat\n\n[role:local-viewer]\npermissions=view\npeer_users="+strconv.Itoa(uid)+"\n\n[role:local-operator]\npermissions=operator\nprograms=db\npeer_groups="+strconv.Itoa(gid)+"\n")
+	ac, err := newAccessControl(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, ok := ac.authenticatePeer(&peerCredentials{uid: uint32(uid), gid: uint32(gid)})
+	if !ok {
+		t.Fatal("expected the peer process is authenticated")
+	}
+	expectedName := "unix:" + strconv.Itoa(uid)
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		expectedName = "unix:" + u.Username
+	}
+	if peer.name != expectedName || len(peer.roles) != 2 {
+		t.Errorf("expected the user %s with 2 roles, got %s %v", expectedName, peer.name, getRoleNames(peer.roles))
+	}
+	if !ac.isAllowed(peer, &accessRequest{permission: permissionControl, names: []string{"db"}}, s.procMgr) {
+		t.Error("expected the peer process is allowed to control db by its group")
+	}
+	if ac.isAllowed(peer, &accessRequest{permission: permissionControl, names: []string{"api"}}, s.procMgr) {
+		t.Error("expected the peer process is not allowed to control api")
+	}
+
+	if _, ok := ac.authenticatePeer(&peerCredentials{uid: 54321, gid: 54321}); ok {
+		t.Error("expected the peer process without role is not authenticated")
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setUmask sets the umask of supervisord if it is set in the supervisord
+// section, the programs inherit it
+func (s *Supervisor) setUmask() error {
+	supervisordConf, ok := s.config.GetSupervisord()
+	if !ok || !supervisordConf.HasParameter("umask") {
+		return nil
+	}
+	value := supervisordConf.GetString("umask", "022")
+	umask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || umask > 0777 {
+		return fmt.Errorf("invalid umask %s, it should be an octal number like 022", value)
+	}
+	syscall.Umask(int(umask))
+	return nil
+}
+
+// dropPrivileges switches supervisord to the user of the supervisord section
+// with its groups. Nothing is done if supervisord is already run by the user,
+// it fails if supervisord is not run by root
+func (s *Supervisor) dropPrivileges() error {
+	supervisordConf, ok := s.config.GetSupervisord()
+	if !ok {
+		return nil
+	}
+	userName := supervisordConf.GetString("user", "")
+	if userName == "" {
+		return nil
+	}
+	u, err := lookupUser(userName)
+	if err != nil {
+		return fmt.Errorf("can't find user %s: %v", userName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s of user %s", u.Uid, userName)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s of user %s", u.Gid, userName)
+	}
+	if os.Getuid() == uid {
+		return nil
+	}
+	if os.Getuid() != 0 {
+		return fmt.Errorf("can't drop privilege as nonroot user")
+	}
+	groups := []int{gid}
+	if groupIds, err := u.GroupIds(); err == nil {
+		for _, groupID := range groupIds {
+			if id, err := strconv.Atoi(groupID); err == nil && id != gid {
+				groups = append(groups, id)
+			}
+		}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("fail to set the groups of user %s: %v", userName, err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("fail to set the gid to %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("fail to set the uid to %d: %v", uid, err)
+	}
+	return nil
+}
+
+// lookupUser finds the user by name or by uid
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		return user.LookupId(name)
+	}
+	return nil, err
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"syscall"
+	"testing"
+)
+
+func TestSetUmask(t *testing.T) {
+	old := syscall.Umask(022)
+	defer syscall.Umask(old)
+
+	s := newTestSupervisor(t, "[supervisord]\numask=027\n")
+	if err := s.setUmask(); err != nil {
+		t.Fatal(err)
+	}
+	if umask := syscall.Umask(022); umask != 027 {
+		t.Errorf("expected umask 027, got %03o", umask)
+	}
+
+	s = newTestSupervisor(t, "[supervisord]\n")
+	if err := s.setUmask(); err != nil {
+		t.Fatal(err)
+	}
+	if umask := syscall.Umask(022); umask != 022 {
+		t.Errorf("expected the umask is not changed without umask, got %03o", umask)
+	}
+
+	for _, value := range []string{"abc", "089", "1777"} {
+		s = newTestSupervisor(t, "[supervisord]\numask="+value+"\n")
+		if err := s.setUmask(); err == nil {
+			t.Errorf("expected the umask %s is invalid", value)
+		}
+	}
+}
+
+func TestDropPrivileges(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("the current user is unknown")
+	}
+	for _, name := range []string{current.Username, current.Uid} {
+		s := newTestSupervisor(t, "[supervisord]\nuser="+name+"\n")
+		if err := s.dropPrivileges(); err != nil {
+			t.Errorf("expected nothing is done when run by the user %s: %v", name, err)
+		}
+	}
+	if os.Getuid() != 0 {
+		s := newTestSupervisor(t, "[supervisord]\nuser=0\n")
+		if err := s.dropPrivileges(); err == nil {
+			t.Error("expected the privileges can't be changed by nonroot user")
+		}
+	}
+
+	s := newTestSupervisor(t, "[supervisord]\nuser=no-such-user-of-supervisord\n")
+	if err := s.dropPrivileges(); err == nil {
+		t.Error("expected an error for the unknown user")
+	}
+
+	s = newTestSupervisor(t, "[supervisord]\n")
+	if err := s.dropPrivileges(); err != nil {
+		t.Errorf("expected nothing is done without user: %v", err)
+	}
+}
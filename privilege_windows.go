@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+func (s *Supervisor) setUmask() error {
+	return nil
+}
+
+func (s *Supervisor) dropPrivileges() error {
+	return nil
+}
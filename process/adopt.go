@@ -0,0 +1,255 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ochinchina/supervisord/signals"
+	psprocess "github.com/shirou/gopsutil/v3/process"
+	log "github.com/sirupsen/logrus"
+)
+
+// newProcessRecord gets the identity of the spawned process to adopt it later
+func newProcessRecord(pid int) (ProcessRecord, error) {
+	proc, err := psprocess.NewProcess(int32(pid))
+	if err != nil {
+		return ProcessRecord{}, err
+	}
+	createTime, err := proc.CreateTime()
+	if err != nil {
+		return ProcessRecord{}, err
+	}
+	cmdline, err := proc.CmdlineSlice()
+	if err != nil {
+		return ProcessRecord{}, err
+	}
+	return ProcessRecord{Pid: pid, CreateTime: createTime, Cmdline: cmdline}, nil
+}
+
+// verify checks if the recorded process is still running. The process is
+// identified by its create time and command line, so a new process reusing
+// the pid is never adopted
+func (r *ProcessRecord) verify() error {
+	if r.Pid <= 0 {
+		return fmt.Errorf("invalid pid %d", r.Pid)
+	}
+	current, err := newProcessRecord(r.Pid)
+	if err != nil {
+		return fmt.Errorf("process %d is not running", r.Pid)
+	}
+	if current.CreateTime != r.CreateTime {
+		return fmt.Errorf("process %d is not the recorded process, it is created at %s", r.Pid, time.UnixMilli(current.CreateTime).Format(time.RFC3339))
+	}
+	if !slices.Equal(current.Cmdline, r.Cmdline) {
+		return fmt.Errorf("the command line of process %d is changed to %s", r.Pid, strings.Join(current.Cmdline, " "))
+	}
+	return nil
+}
+
+// isAlive checks if the recorded process is still running and is not a zombie
+func (r *ProcessRecord) isAlive() bool {
+	proc, err := psprocess.NewProcess(int32(r.Pid))
+	if err != nil {
+		return false
+	}
+	if createTime, err := proc.CreateTime(); err != nil || createTime != r.CreateTime {
+		return false
+	}
+	status, err := proc.Status()
+	return err != nil || !slices.Contains(status, psprocess.Zombie)
+}
+
+// pollProcessExit waits until the process is not alive
+func pollProcessExit(alive func() bool) {
+	for alive() {
+		time.Sleep(time.Second)
+	}
+}
+
+// Adopt adopts the process of the program recorded by the previous
+// supervisord if it is still running, instead of spawning the program again.
+// The adopted process is tracked until it exits and then the program is
+// restarted according to its autorestart. The process is restarted instead
+// if its output can't be captured anymore
+func (p *Process) Adopt(record ProcessRecord) error {
+	if err := record.verify(); err != nil {
+		return err
+	}
+	if !p.canCaptureOutput(&record) {
+		// the program would get SIGPIPE or EPIPE when it writes its output
+		log.WithFields(log.Fields{"program": p.GetName(), "pid": record.Pid}).Warn("the output of the process can't be captured, restart it")
+		if err := p.terminateRecorded(&record); err != nil {
+			return err
+		}
+		p.Start(false)
+		return nil
+	}
+	p.lock.Lock()
+	if p.inStart {
+		p.lock.Unlock()
+		return fmt.Errorf("program is already started")
+	}
+	p.adoptRecord = &record
+	p.lock.Unlock()
+	log.WithFields(log.Fields{"program": p.GetName(), "pid": record.Pid}).Info("adopt the running process")
+	p.Start(false)
+	return nil
+}
+
+// createAdoptedCommand creates the Command object of the adopted process. Its
+// output is copied to the program logs from the pipes inherited on upgrade,
+// or from the named pipes reopened after the previous supervisord crashed.
+// The stdin of the process can't be reopened after a crash
+func (p *Process) createAdoptedCommand(record *ProcessRecord) error {
+	if len(record.Cmdline) == 0 {
+		return fmt.Errorf("no command line of process %d", record.Pid)
+	}
+	proc, err := os.FindProcess(record.Pid)
+	if err != nil {
+		return err
+	}
+	p.cmd = &exec.Cmd{Path: record.Cmdline[0], Args: record.Cmdline, Process: proc}
+	// the adopted process is already in its cgroup
+	if p.cgroup, err = NewCgroup(p.GetName(), p.GetGroup(), p.config); err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error(err)
+	}
+	p.setDir()
+	p.setLog()
+	p.stdin = nil
+	stdoutFifo, stderrFifo := p.getOutputFifo("stdout"), p.getOutputFifo("stderr")
+	p.pipes, err = inheritProcessPipes(record, p.cmd, stdoutFifo, stderrFifo)
+	if err == nil && p.pipes == nil {
+		p.pipes, err = reopenProcessPipes(p.cmd, stdoutFifo, stderrFifo)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Warn("fail to capture the output of adopted process: ", err)
+	} else if p.pipes.stdin != nil {
+		p.stdin = p.pipes.stdin
+	}
+	return nil
+}
+
+// getOutputFifo gets the named pipe the program writes its stdout or stderr
+// to if the processes are adopted. The named pipes are in the directory next
+// to the state file
+func (p *Process) getOutputFifo(stream string) string {
+	if p.processStore == nil || !outputFifoSupported {
+		return ""
+	}
+	return filepath.Join(p.processStore.GetFile()+".pipes", p.GetName()+"."+stream)
+}
+
+// canCaptureOutput checks if the output of the recorded process can be
+// captured: its pipes are inherited on upgrade or it writes its output to
+// the named pipes
+func (p *Process) canCaptureOutput(record *ProcessRecord) bool {
+	if record.Stdout > 0 && record.Stderr > 0 {
+		return true
+	}
+	return isFifo(p.getOutputFifo("stdout")) && isFifo(p.getOutputFifo("stderr"))
+}
+
+// terminateRecorded stops the recorded process which is not a child of
+// supervisord with the stopsignal, and kills it if it is still running after
+// stopwaitsecs
+func (p *Process) terminateRecorded(record *ProcessRecord) error {
+	proc, err := os.FindProcess(record.Pid)
+	if err != nil {
+		return err
+	}
+	sigs := append(strings.Fields(p.config.GetString("stopsignal", "TERM")), "KILL")
+	waitsecs := time.Duration(p.config.GetInt("stopwaitsecs", 10)) * time.Second
+	for _, name := range sigs {
+		sig, err := signals.ToSignal(name)
+		if err != nil {
+			continue
+		}
+		_ = proc.Signal(sig)
+		for deadline := time.Now().Add(waitsecs); record.isAlive() && time.Now().Before(deadline); {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !record.isAlive() {
+			return nil
+		}
+	}
+	return fmt.Errorf("process %d can't be stopped", record.Pid)
+}
+
+// recordProcess records the spawned process so it can be adopted by the next
+// supervisord
+func (p *Process) recordProcess() {
+	if p.processStore == nil {
+		return
+	}
+	record, err := newProcessRecord(p.cmd.Process.Pid)
+	// wait for the program to be executed by the shell setting LISTEN_PID
+	// or by the helper setting the resource limits
+	for i := 0; err == nil && i < 20 && (isListenPidWrapper(record.Cmdline) || isRlimitHelper(record.Cmdline)); i++ {
+		time.Sleep(50 * time.Millisecond)
+		record, err = newProcessRecord(p.cmd.Process.Pid)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Warn("fail to record the process for adoption: ", err)
+		return
+	}
+	p.processStore.SetProcess(p.GetName(), record)
+}
+
+// waitForAdoptedExit waits for the adopted process exit. The process adopted
+// on upgrade is still a child of supervisord and its exit status is got,
+// otherwise its exit status is unknown
+func (p *Process) waitForAdoptedExit(record *ProcessRecord) {
+	if state, err := p.cmd.Process.Wait(); err == nil {
+		p.cmd.ProcessState = state
+	} else {
+		waitProcessExit(record.Pid, record.isAlive)
+	}
+	log.WithFields(log.Fields{"program": p.GetName(), "pid": record.Pid}).Info("adopted process exited")
+}
+
+// prepareUpgrade records the running process with its pipes, so it is
+// adopted by the new supervisord after upgrade
+func (p *Process) prepareUpgrade() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.processStore == nil || !p.IsRunning() || p.cmd == nil || p.cmd.Process == nil {
+		return nil
+	}
+	var record ProcessRecord
+	if p.adopted != nil {
+		record = ProcessRecord{Pid: p.adopted.Pid, CreateTime: p.adopted.CreateTime, Cmdline: p.adopted.Cmdline}
+	} else {
+		var err error
+		if record, err = newProcessRecord(p.cmd.Process.Pid); err != nil {
+			return err
+		}
+	}
+	if err := p.pipes.inherit(&record); err != nil {
+		return err
+	}
+	p.processStore.SetProcess(p.GetName(), record)
+	return nil
+}
+
+// cancelUpgrade undoes prepareUpgrade after the upgrade is failed: the pipes
+// are not inherited by the spawned programs and their file descriptors are
+// removed from the recorded process
+func (p *Process) cancelUpgrade() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.processStore == nil {
+		return
+	}
+	p.pipes.uninherit()
+	record, ok := p.processStore.GetProcesses()[p.GetName()]
+	if !ok || (record.Stdin == 0 && record.Stdout == 0 && record.Stderr == 0) {
+		return
+	}
+	record.Stdin, record.Stdout, record.Stderr = 0, 0, 0
+	p.processStore.SetProcess(p.GetName(), record)
+}
@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// waitProcessExit waits for the exit of the process which is not a child of
+// supervisord through its pidfd, or by polling if pidfd is not supported
+func waitProcessExit(pid int, alive func() bool) {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		pollProcessExit(alive)
+		return
+	}
+	defer unix.Close(fd)
+	// the pid may be reused before the pidfd is opened
+	if !alive() {
+		return
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			pollProcessExit(alive)
+			return
+		}
+		if n > 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+// waitProcessExit waits for the exit of the process which is not a child of
+// supervisord by polling
+func waitProcessExit(pid int, alive func() bool) {
+	pollProcessExit(alive)
+}
@@ -0,0 +1,115 @@
+package process
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestProcessRecordVerify(t *testing.T) {
+	record, err := newProcessRecord(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = record.verify(); err != nil {
+		t.Errorf("expected the current process is verified: %v", err)
+	}
+	if !record.isAlive() {
+		t.Error("expected the current process is alive")
+	}
+
+	reused := record
+	reused.CreateTime--
+	if reused.verify() == nil || reused.isAlive() {
+		t.Error("expected the process with another create time is not verified")
+	}
+
+	changed := record
+	changed.Cmdline = append([]string{"other"}, record.Cmdline[1:]...)
+	if changed.verify() == nil {
+		t.Error("expected the process with another command line is not verified")
+	}
+}
+
+func TestProcessRecordOfExitedProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep is not available")
+	}
+	record, err := newProcessRecord(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	if record.verify() == nil || record.isAlive() {
+		t.Error("expected the exited process is not verified")
+	}
+	waitProcessExit(record.Pid, record.isAlive)
+}
+
+func TestCancelUpgradeClearsPipeFds(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("web"))
+	proc.processStore = NewStateStore(t.TempDir() + "/state.json")
+	proc.processStore.SetProcess("web", ProcessRecord{Pid: 100, Stdin: 10, Stdout: 11, Stderr: 12})
+	proc.cancelUpgrade()
+	record := proc.processStore.GetProcesses()["web"]
+	if record.Pid != 100 || record.Stdin != 0 || record.Stdout != 0 || record.Stderr != 0 {
+		t.Errorf("expected the pipe fds are removed from the recorded process, got %+v", record)
+	}
+}
+
+func TestAdoptRestartsProcessWithoutOutput(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/sleep 100\nstartsecs=0\nstdout_logfile=/dev/null\nstderr_logfile=/dev/null\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// the process left by the crashed supervisord without the named pipes
+	cmd := exec.Command("/bin/sleep", "100")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep is not available")
+	}
+	defer func() { _ = cmd.Process.Kill() }()
+	record, err := newProcessRecord(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("web"))
+	proc.processStore = NewStateStore(filepath.Join(dir, "state.json"))
+	if proc.canCaptureOutput(&record) {
+		t.Fatal("expected the output of the process can't be captured")
+	}
+	if err = proc.Adopt(record); err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Stop(true)
+	if err = cmd.Wait(); err == nil {
+		t.Error("expected the process is stopped instead of being adopted")
+	}
+	for i := 0; i < 50 && proc.GetState() != Running; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if proc.GetPid() == record.Pid || proc.GetState() != Running {
+		t.Errorf("expected the program is spawned again, got pid %d in state %v", proc.GetPid(), proc.GetState())
+	}
+	if !isFifo(proc.getOutputFifo("stdout")) || !isFifo(proc.getOutputFifo("stderr")) {
+		t.Error("expected the spawned program writes its output to the named pipes")
+	}
+}
@@ -0,0 +1,80 @@
+package process
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+// RestartBackoff calculates how long to wait before restarting a failed program
+//
+// The delay before the n-th retry is backoff_initial * backoff_multiplier^(n-1),
+// limited by backoff_max and randomized by +/- backoff_jitter of the delay.
+// Without any backoff_* setting the delay is the fixed restartpause.
+type RestartBackoff struct {
+	initial    float64
+	max        float64
+	multiplier float64
+	jitter     float64
+	// the retry counter is cleared after the program stays running this long
+	resetSecs int
+	// retry a program in Fatal state after this time, 0 means never
+	fatalRetry time.Duration
+}
+
+// NewRestartBackoff creates RestartBackoff from program configuration
+func NewRestartBackoff(config *config.Entry) *RestartBackoff {
+	initial := config.GetFloat("backoff_initial", float64(config.GetInt("restartpause", 0)))
+	jitter := config.GetFloat("backoff_jitter", 0)
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+	multiplier := config.GetFloat("backoff_multiplier", 1)
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	return &RestartBackoff{
+		initial:    math.Max(initial, 0),
+		max:        config.GetFloat("backoff_max", 0),
+		multiplier: multiplier,
+		jitter:     jitter,
+		resetSecs:  config.GetInt("backoff_reset_secs", 0),
+		fatalRetry: time.Duration(config.GetFloat("fatal_retry_minutes", 0) * float64(time.Minute)),
+	}
+}
+
+// GetDelay returns the delay before the retry after retries failed attempts
+func (b *RestartBackoff) GetDelay(retries int32) time.Duration {
+	if retries <= 0 || b.initial <= 0 {
+		return 0
+	}
+	delay := b.initial * math.Pow(b.multiplier, float64(retries-1))
+	if b.max > 0 && delay > b.max {
+		delay = b.max
+	}
+	if b.jitter > 0 {
+		delay = delay * (1 + b.jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(delay * float64(time.Second))
+}
+
+// ShouldReset returns true if the retry counter should be cleared before
+// starting the program again. Without backoff_reset_secs the counter is
+// always cleared, otherwise it is cleared only if the program stayed
+// in running state at least backoff_reset_secs seconds
+func (b *RestartBackoff) ShouldReset(runningTime time.Time, stopTime time.Time) bool {
+	if b.resetSecs <= 0 {
+		return true
+	}
+	return !runningTime.IsZero() && stopTime.Sub(runningTime) >= time.Duration(b.resetSecs)*time.Second
+}
+
+// GetFatalRetry returns how long to wait before retrying a program in
+// Fatal state, 0 means the program stays in Fatal state
+func (b *RestartBackoff) GetFatalRetry() time.Duration {
+	return b.fatalRetry
+}
@@ -0,0 +1,132 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestBackoffDefaultRestartPause(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\nrestartpause=3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	backoff := NewRestartBackoff(cfg.GetProgram("test"))
+	for i := int32(1); i < 5; i++ {
+		if backoff.GetDelay(i) != 3*time.Second {
+			t.Errorf("expect fixed delay 3s, got %v", backoff.GetDelay(i))
+		}
+	}
+	if !backoff.ShouldReset(time.Time{}, time.Now()) {
+		t.Error("retry counter should be always reset without backoff_reset_secs")
+	}
+}
+
+func TestBackoffExponential(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\nbackoff_initial=1\nbackoff_multiplier=2\nbackoff_max=10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	backoff := NewRestartBackoff(cfg.GetProgram("test"))
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, delay := range expected {
+		if backoff.GetDelay(int32(i)) != delay {
+			t.Errorf("expect delay %v for retry %d, got %v", delay, i, backoff.GetDelay(int32(i)))
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\nbackoff_initial=10\nbackoff_jitter=0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	backoff := NewRestartBackoff(cfg.GetProgram("test"))
+	for i := 0; i < 100; i++ {
+		delay := backoff.GetDelay(1)
+		if delay < 5*time.Second || delay > 15*time.Second {
+			t.Errorf("delay %v is out of jitter range", delay)
+		}
+	}
+}
+
+func TestBackoffResetWindow(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\nbackoff_reset_secs=60\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	backoff := NewRestartBackoff(cfg.GetProgram("test"))
+	now := time.Now()
+	if backoff.ShouldReset(now.Add(-10*time.Second), now) {
+		t.Error("retry counter should not be reset if the program is not stable")
+	}
+	if !backoff.ShouldReset(now.Add(-120*time.Second), now) {
+		t.Error("retry counter should be reset if the program is stable")
+	}
+}
+
+func TestStartResetsRetryTimes(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/sleep 10\nstartsecs=0\nbackoff_reset_secs=60\nstdout_logfile=/dev/null\nstderr_logfile=/dev/null\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("test"))
+	// the program exited before it was stable in the previous run
+	proc.runningTime = time.Now().Add(-time.Second)
+	proc.stopTime = time.Now()
+	proc.retryTimes.Store(5)
+	proc.Start(true)
+	defer proc.Stop(true)
+	if retryTimes := proc.retryTimes.Load(); retryTimes != 1 {
+		t.Errorf("expected the retry counter is reset by the start of the user, got %d", retryTimes)
+	}
+}
+
+func TestStopInBackoff(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/nonexistent/program\nbackoff_initial=30\nstartretries=3\nstdout_logfile=/dev/null\nstderr_logfile=/dev/null\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("test"))
+	proc.Start(false)
+	for i := 0; i < 50 && proc.GetState() != Backoff; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if proc.GetState() != Backoff {
+		t.Fatalf("expected the program is in backoff, got %v", proc.GetState())
+	}
+	proc.Stop(true)
+	for i := 0; i < 50 && proc.GetState() != Stopped; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if proc.GetState() != Stopped {
+		t.Errorf("expected the program stopped in backoff is stopped, got %v", proc.GetState())
+	}
+}
@@ -0,0 +1,256 @@
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ochinchina/supervisord/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// the mount point of the cgroup v2 unified hierarchy
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Cgroup the cgroup v2 of a program. The program is spawned into the cgroup
+// <cgroup_parent>/[<group>/]<program>. If the program is in a group, the
+// limits can be applied to the cgroup of the whole group with
+// cgroup_limit_scope=group
+type Cgroup struct {
+	programName string
+	// the cgroup of the program
+	path string
+	// the cgroup the limits are written to, the program cgroup or its parent group cgroup
+	limitPath string
+	// the cgroup files and their values
+	limits     map[string]string
+	killOnStop bool
+}
+
+// CgroupStats the accounting of a cgroup
+type CgroupStats struct {
+	MemoryCurrent uint64
+	CPUSeconds    float64
+	PidsCurrent   uint64
+	IOReadBytes   uint64
+	IOWriteBytes  uint64
+}
+
+// NewCgroup creates Cgroup from program configuration, returns nil if
+// cgroup_parent is not configured
+func NewCgroup(programName string, groupName string, config *config.Entry) (*Cgroup, error) {
+	parent := strings.TrimSpace(config.GetString("cgroup_parent", ""))
+	if parent == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(parent) {
+		parent = filepath.Join(cgroupRoot, parent)
+	}
+	path := filepath.Join(parent, programName)
+	limitPath := path
+	if groupName != "" && groupName != programName {
+		path = filepath.Join(parent, groupName, programName)
+		limitPath = path
+		switch scope := config.GetString("cgroup_limit_scope", "program"); scope {
+		case "program":
+		case "group":
+			limitPath = filepath.Join(parent, groupName)
+		default:
+			return nil, fmt.Errorf("invalid cgroup_limit_scope %s", scope)
+		}
+	}
+
+	limits := make(map[string]string)
+	if v := strings.TrimSpace(config.GetString("cgroup_memory_max", "")); v != "" {
+		value, err := parseCgroupBytes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cgroup_memory_max %s", v)
+		}
+		limits["memory.max"] = value
+	}
+	if v := strings.TrimSpace(config.GetString("cgroup_cpu_max", "")); v != "" {
+		value, err := parseCgroupCPUMax(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cgroup_cpu_max %s", v)
+		}
+		limits["cpu.max"] = value
+	}
+	if v := strings.TrimSpace(config.GetString("cgroup_pids_max", "")); v != "" {
+		if _, err := strconv.ParseUint(v, 10, 64); err != nil && v != "max" {
+			return nil, fmt.Errorf("invalid cgroup_pids_max %s", v)
+		}
+		limits["pids.max"] = v
+	}
+	if v := strings.TrimSpace(config.GetString("cgroup_io_weight", "")); v != "" {
+		weight, err := strconv.Atoi(v)
+		if err != nil || weight < 1 || weight > 10000 {
+			return nil, fmt.Errorf("invalid cgroup_io_weight %s", v)
+		}
+		limits["io.weight"] = fmt.Sprintf("default %d", weight)
+	}
+
+	return &Cgroup{programName: programName,
+		path:       path,
+		limitPath:  limitPath,
+		limits:     limits,
+		killOnStop: config.GetBool("cgroup_kill_on_stop", true)}, nil
+}
+
+// GetPath returns the cgroup path of the program
+func (cg *Cgroup) GetPath() string {
+	return cg.path
+}
+
+// IsKillOnStop returns true if all the processes in the cgroup should be killed when the program is stopped
+func (cg *Cgroup) IsKillOnStop() bool {
+	return cg.killOnStop
+}
+
+// parseCgroupBytes parses the memory size with optional KB, MB or GB suffix
+func parseCgroupBytes(s string) (string, error) {
+	if s == "max" {
+		return s, nil
+	}
+	v, err := parseRlimitValue(s)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(v, 10), nil
+}
+
+// parseCgroupCPUMax parses the cpu limit in percent of one CPU (like 150%) or
+// in the cpu.max format "$MAX $PERIOD"
+func parseCgroupCPUMax(s string) (string, error) {
+	if s == "max" {
+		return s, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 {
+			return "", fmt.Errorf("invalid cpu percent %s", s)
+		}
+		// the quota in microseconds of the 100ms period
+		return fmt.Sprintf("%d 100000", int64(percent*1000)), nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", fmt.Errorf("invalid cpu.max %s", s)
+	}
+	for i, field := range fields {
+		if i == 0 && field == "max" {
+			continue
+		}
+		if _, err := strconv.ParseUint(field, 10, 64); err != nil {
+			return "", err
+		}
+	}
+	return s, nil
+}
+
+// Create creates the cgroup of the program, enables the controllers for the
+// limits in the parent cgroups and writes the limits
+func (cg *Cgroup) Create() error {
+	if err := os.MkdirAll(cg.path, 0755); err != nil {
+		return fmt.Errorf("fail to create cgroup %s: %v", cg.path, err)
+	}
+	controllers := make([]string, 0)
+	for file := range cg.limits {
+		controllers = append(controllers, "+"+strings.SplitN(file, ".", 2)[0])
+	}
+	// enable the controllers from the top to the parent of the limit cgroup
+	if len(controllers) > 0 {
+		dirs := make([]string, 0)
+		for dir := filepath.Dir(cg.limitPath); strings.HasPrefix(dir, cgroupRoot+"/") || dir == cgroupRoot; dir = filepath.Dir(dir) {
+			dirs = append([]string{dir}, dirs...)
+			if dir == cgroupRoot {
+				break
+			}
+		}
+		for _, dir := range dirs {
+			for _, controller := range controllers {
+				if err := writeCgroupFile(dir, "cgroup.subtree_control", controller); err != nil {
+					log.WithFields(log.Fields{"program": cg.programName, "cgroup": dir}).Warn("fail to enable cgroup controller ", controller, ": ", err)
+				}
+			}
+		}
+	}
+	for file, value := range cg.limits {
+		if err := writeCgroupFile(cg.limitPath, file, value); err != nil {
+			return fmt.Errorf("fail to set %s of cgroup %s: %v", file, cg.limitPath, err)
+		}
+	}
+	return nil
+}
+
+// GetPids returns the pids of the processes in the cgroup
+func (cg *Cgroup) GetPids() ([]int, error) {
+	f, err := os.Open(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pids := make([]int, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, scanner.Err()
+}
+
+// ReadStats reads the accounting of the program cgroup
+func (cg *Cgroup) ReadStats() (*CgroupStats, error) {
+	stats := &CgroupStats{}
+	s, err := readCgroupFile(cg.path, "memory.current")
+	if err != nil {
+		return nil, err
+	}
+	stats.MemoryCurrent, _ = strconv.ParseUint(s, 10, 64)
+	if s, err = readCgroupFile(cg.path, "pids.current"); err == nil {
+		stats.PidsCurrent, _ = strconv.ParseUint(s, 10, 64)
+	}
+	if s, err = readCgroupFile(cg.path, "cpu.stat"); err == nil {
+		for _, line := range strings.Split(s, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, _ := strconv.ParseUint(fields[1], 10, 64)
+				stats.CPUSeconds = float64(usec) / 1e6
+			}
+		}
+	}
+	if s, err = readCgroupFile(cg.path, "io.stat"); err == nil {
+		// each line is like: 8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0
+		for _, line := range strings.Split(s, "\n") {
+			for _, field := range strings.Fields(line) {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				v, _ := strconv.ParseUint(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					stats.IOReadBytes += v
+				case "wbytes":
+					stats.IOWriteBytes += v
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+func readCgroupFile(dir string, file string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeCgroupFile(dir string, file string, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// attach makes the program to be spawned into the cgroup before it is
+// executed. The returned file should be closed after the program is started
+func (cg *Cgroup) attach(sysProcAttr *syscall.SysProcAttr) (*os.File, error) {
+	if err := cg.Create(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(cg.path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open cgroup %s: %v", cg.path, err)
+	}
+	sysProcAttr.UseCgroupFD = true
+	sysProcAttr.CgroupFD = int(f.Fd())
+	return f, nil
+}
+
+// Kill kills all the processes in the cgroup
+func (cg *Cgroup) Kill() error {
+	// cgroup.kill is supported since linux 5.14
+	if err := writeCgroupFile(cg.path, "cgroup.kill", "1"); err == nil {
+		return nil
+	}
+	pids, err := cg.GetPids()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+	}
+	return nil
+}
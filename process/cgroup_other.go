@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// attach returns error because cgroup is only supported on linux
+func (cg *Cgroup) attach(sysProcAttr *syscall.SysProcAttr) (*os.File, error) {
+	return nil, fmt.Errorf("cgroup is only supported on linux")
+}
+
+// Kill returns error because cgroup is only supported on linux
+func (cg *Cgroup) Kill() error {
+	return fmt.Errorf("cgroup is only supported on linux")
+}
@@ -0,0 +1,116 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestNewCgroupNotConfigured(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cgroup, err := NewCgroup("test", "test", cfg.GetProgram("test"))
+	if err != nil || cgroup != nil {
+		t.Errorf("cgroup should not be created without cgroup_parent, got %v, %v", cgroup, err)
+	}
+}
+
+func TestNewCgroupPath(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:relative]\ncommand=/bin/ls\ncgroup_parent=supervisord\n"+
+		"[program:group_scope]\ncommand=/bin/ls\ncgroup_parent=/sys/fs/cgroup/supervisord\ncgroup_limit_scope=group\n"+
+		"[program:invalid]\ncommand=/bin/ls\ncgroup_parent=supervisord\ncgroup_io_weight=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cgroup, err := NewCgroup("test", "test", cfg.GetProgram("relative"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cgroup.GetPath() != "/sys/fs/cgroup/supervisord/test" {
+		t.Errorf("unexpected cgroup path %s", cgroup.GetPath())
+	}
+	cgroup, err = NewCgroup("test", "web", cfg.GetProgram("group_scope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cgroup.GetPath() != "/sys/fs/cgroup/supervisord/web/test" || cgroup.limitPath != "/sys/fs/cgroup/supervisord/web" {
+		t.Errorf("unexpected cgroup path %s and limit path %s", cgroup.GetPath(), cgroup.limitPath)
+	}
+	if _, err = NewCgroup("test", "test", cfg.GetProgram("invalid")); err == nil {
+		t.Error("invalid cgroup_io_weight should fail")
+	}
+}
+
+func TestParseCgroupCPUMax(t *testing.T) {
+	for value, expected := range map[string]string{"150%": "150000 100000", "max": "max", "50000 100000": "50000 100000", "max 100000": "max 100000"} {
+		s, err := parseCgroupCPUMax(value)
+		if err != nil || s != expected {
+			t.Errorf("expect %s for %s, got %s, %v", expected, value, s, err)
+		}
+	}
+	if _, err := parseCgroupCPUMax("fast"); err == nil {
+		t.Error("invalid cpu max should fail")
+	}
+}
+
+func TestCgroupCreateAndReadStats(t *testing.T) {
+	parent := t.TempDir()
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\ncgroup_parent="+parent+"\ncgroup_memory_max=64MB\ncgroup_pids_max=10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cgroup, err := NewCgroup("test", "test", cfg.GetProgram("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cgroup.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := readCgroupFile(cgroup.GetPath(), "memory.max"); s != "67108864" {
+		t.Errorf("expect memory.max 67108864, got %s", s)
+	}
+	if s, _ := readCgroupFile(cgroup.GetPath(), "pids.max"); s != "10" {
+		t.Errorf("expect pids.max 10, got %s", s)
+	}
+
+	files := map[string]string{
+		"memory.current": "1024\n",
+		"pids.current":   "3\n",
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2\n8:16 rbytes=1 wbytes=2 rios=1 wios=1\n",
+		"cgroup.procs":   "10\n11\n",
+	}
+	for file, content := range files {
+		if err = os.WriteFile(filepath.Join(cgroup.GetPath(), file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := cgroup.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CgroupStats{MemoryCurrent: 1024, CPUSeconds: 2.5, PidsCurrent: 3, IOReadBytes: 101, IOWriteBytes: 202}
+	if *stats != expected {
+		t.Errorf("expect %+v, got %+v", expected, *stats)
+	}
+	pids, err := cgroup.GetPids()
+	if err != nil || len(pids) != 2 || pids[0] != 10 || pids[1] != 11 {
+		t.Errorf("unexpected pids %v, %v", pids, err)
+	}
+}
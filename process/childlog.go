@@ -0,0 +1,90 @@
+package process
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the characters of the random part of the AUTO log file name
+const autoLogFileChars = "abcdefghijklmnopqrstuvwxyz0123456789_"
+
+// isAutoLogFile checks if the stdout_logfile or stderr_logfile is AUTO
+func isAutoLogFile(logFile string) bool {
+	return strings.EqualFold(strings.TrimSpace(logFile), "AUTO")
+}
+
+// newAutoLogFileName creates the name of the AUTO log file in the childlogdir
+// like python supervisord: <program>-<stream>---<identifier>-<random>.log
+func newAutoLogFileName(dir string, programName string, stream string, identifier string) string {
+	suffix := make([]byte, 8)
+	for i := range suffix {
+		suffix[i] = autoLogFileChars[rand.Intn(len(autoLogFileChars))]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%s---%s-%s.log", programName, stream, identifier, suffix))
+}
+
+// setChildLogSettings sets the directory of the AUTO log files and if the
+// ANSI escape sequences are removed from the output. The AUTO log files are
+// named again if the directory is changed
+func (p *Process) setChildLogSettings(childLogDir string, stripAnsi bool) {
+	p.childLogLock.Lock()
+	defer p.childLogLock.Unlock()
+	if p.childLogDir != childLogDir {
+		p.autoLogFiles = nil
+	}
+	p.childLogDir = childLogDir
+	p.stripAnsi = stripAnsi
+}
+
+// getAutoLogFile gets the AUTO log file of the stream, the name is created
+// once so the log file is kept when the program is restarted
+func (p *Process) getAutoLogFile(stream string) string {
+	p.childLogLock.Lock()
+	defer p.childLogLock.Unlock()
+	if file, ok := p.autoLogFiles[stream]; ok {
+		return file
+	}
+	dir := p.childLogDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if p.autoLogFiles == nil {
+		p.autoLogFiles = make(map[string]string)
+	}
+	file := newAutoLogFileName(dir, p.GetName(), stream, p.supervisorID)
+	p.autoLogFiles[stream] = file
+	return file
+}
+
+// isStripAnsi checks if the ANSI escape sequences are removed from the output
+func (p *Process) isStripAnsi() bool {
+	p.childLogLock.Lock()
+	defer p.childLogLock.Unlock()
+	return p.stripAnsi
+}
+
+// CleanAutoLogFiles removes the AUTO log files and their backups left in the
+// childlogdir by the previous supervisord with the identifier
+func CleanAutoLogFiles(dir string, identifier string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	pattern := regexp.MustCompile(`^.+?---` + regexp.QuoteMeta(identifier) + `-\S+\.log`)
+	for _, entry := range entries {
+		if entry.IsDir() || !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		if err := os.Remove(file); err != nil {
+			log.WithFields(log.Fields{"file": file}).Warn("fail to remove the AUTO log file: ", err)
+		}
+	}
+	return nil
+}
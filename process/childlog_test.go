@@ -0,0 +1,63 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestAutoLogFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/ls\nstderr_logfile=auto\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("web"))
+	proc.setChildLogSettings(dir, false)
+	stdout := proc.GetStdoutLogfile()
+	if filepath.Dir(stdout) != dir || !strings.HasPrefix(filepath.Base(stdout), "web-stdout---supervisord-") || !strings.HasSuffix(stdout, ".log") {
+		t.Errorf("unexpected AUTO stdout log file %s", stdout)
+	}
+	if proc.GetStdoutLogfile() != stdout {
+		t.Error("expected the same AUTO log file for the same stream")
+	}
+	if stderr := proc.GetStderrLogfile(); !strings.HasPrefix(filepath.Base(stderr), "web-stderr---supervisord-") {
+		t.Errorf("unexpected AUTO stderr log file %s", stderr)
+	}
+	otherDir := t.TempDir()
+	proc.setChildLogSettings(otherDir, false)
+	if filepath.Dir(proc.GetStdoutLogfile()) != otherDir {
+		t.Error("expected a new AUTO log file after the childlogdir is changed")
+	}
+}
+
+func TestCleanAutoLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	removed := []string{"web-stdout---supervisord-abcd1234.log", "web-stdout---supervisord-abcd1234.log.1", "web-stderr---supervisord-x_y.log.2.gz"}
+	kept := []string{"web-stdout---other-abcd1234.log", "other.log", "web-stdout---supervisord.log"}
+	for _, name := range append(append([]string{}, removed...), kept...) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CleanAutoLogFiles(dir, "supervisord"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range removed {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+}
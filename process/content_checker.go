@@ -1,6 +1,7 @@
-package main
+package process
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -70,17 +71,21 @@ func (bc *BaseChecker) Check() bool {
 
 // ScriptChecker implements ContentChecker by calling external script
 type ScriptChecker struct {
-	args []string
+	args        []string
+	timeoutTime time.Time
 }
 
-// NewScriptChecker creates ScriptChecker object
-func NewScriptChecker(args []string) *ScriptChecker {
-	return &ScriptChecker{args: args}
+// NewScriptChecker creates ScriptChecker object, the script is killed if it
+// does not exit in timeout seconds
+func NewScriptChecker(args []string, timeout int) *ScriptChecker {
+	return &ScriptChecker{args: args, timeoutTime: time.Now().Add(time.Duration(timeout) * time.Second)}
 }
 
 // Check return code of the script. If return code is 0, check is successful
 func (sc *ScriptChecker) Check() bool {
-	cmd := exec.Command(sc.args[0])
+	ctx, cancel := context.WithDeadline(context.Background(), sc.timeoutTime)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, sc.args[0])
 	if len(sc.args) > 1 {
 		cmd.Args = sc.args
 	}
@@ -120,6 +125,11 @@ func (tc *TCPChecker) start() {
 		}
 
 		if err == nil {
+			// nothing to match, the check succeeds once the port accepts connection
+			if len(tc.baseChecker.includes) == 0 {
+				_, _ = tc.baseChecker.Write(nil)
+				return
+			}
 			for {
 				n, err := tc.conn.Read(b)
 				if err != nil {
@@ -159,8 +169,10 @@ func NewHTTPChecker(url string, timeout int) *HTTPChecker {
 // is non-2xx.
 func (hc *HTTPChecker) Check() bool {
 	for {
-		if time.Now().Before(hc.timeoutTime) {
-			resp, err := http.Get(hc.url)
+		if remaining := time.Until(hc.timeoutTime); remaining > 0 {
+			// the request is aborted when the timeout expires
+			client := &http.Client{Timeout: remaining}
+			resp, err := client.Get(hc.url)
 			if err == nil {
 				resp.Body.Close()
 				return resp.StatusCode >= 200 && resp.StatusCode < 300
@@ -1,4 +1,4 @@
-package main
+package process
 
 import (
 	"net"
@@ -150,3 +150,49 @@ func TestHttpCheckRetriesOnConnectionRefused(t *testing.T) {
 		t.Error("expected true after server became available")
 	}
 }
+
+// TestTcpCheckConnectOnly verifies that a TCPChecker without includes succeeds
+// as soon as the port accepts connection
+func TestTcpCheckConnectOnly(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(3 * time.Second)
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	checker := NewTCPChecker("127.0.0.1", port, nil, 2)
+	if !checker.Check() {
+		t.Error("expected true when the port accepts connection")
+	}
+}
+
+// TestHttpCheckHungServerTimesOut verifies that Check() returns false when
+// the server accepts the request but never responds
+func TestHttpCheckHungServerTimesOut(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer listener.Close()
+
+	start := time.Now()
+	checker := NewHTTPChecker("http://"+listener.Addr().String(), 1)
+	if checker.Check() {
+		t.Error("expected false when the server does not respond")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Check() took %v, should return within ~1s of timeout", elapsed)
+	}
+}
@@ -0,0 +1,194 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/logger"
+	"github.com/ochinchina/supervisord/signals"
+	log "github.com/sirupsen/logrus"
+)
+
+// the signals handled as crash if crash_signals is not configured
+var defaultCrashSignals = "SEGV,ABRT,BUS,FPE,ILL,SYS,TRAP,QUIT"
+
+// CrashCollector collects the crash bundle of a program when it is terminated
+// by one of the crash_signals. The bundle is a directory
+// <crash_dir>/<program>-<time>-<pid> with the crash information in
+// crash.json, the tail of stdout/stderr log and the core file if it is
+// dumped in the working directory of the program
+type CrashCollector struct {
+	programName string
+	dir         string
+	logBytes    int64
+	signals     map[syscall.Signal]bool
+	hook        string
+	keep        int
+}
+
+// CrashInfo the information of a crash written to crash.json of the bundle
+type CrashInfo struct {
+	Program    string    `json:"program"`
+	Group      string    `json:"group"`
+	Pid        int       `json:"pid"`
+	Signal     string    `json:"signal"`
+	CoreDumped bool      `json:"core_dumped"`
+	CoreFile   string    `json:"core_file,omitempty"`
+	Command    string    `json:"command"`
+	Directory  string    `json:"directory"`
+	Start      time.Time `json:"start"`
+	Stop       time.Time `json:"stop"`
+	// the /proc/sys/kernel/core_pattern when the program crashed
+	CorePattern string `json:"core_pattern,omitempty"`
+	// the tail of stdout/stderr log, they are written to the bundle as files
+	stdout string
+	stderr string
+}
+
+// NewCrashCollector creates CrashCollector from program configuration,
+// returns nil if crash_collect is not enabled
+func NewCrashCollector(programName string, config *config.Entry) (*CrashCollector, error) {
+	if !config.GetBool("crash_collect", false) {
+		return nil, nil
+	}
+	crashSignals := make(map[syscall.Signal]bool)
+	for _, name := range strings.Split(config.GetString("crash_signals", defaultCrashSignals), ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		sig, err := signals.ToSignal(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid crash_signals %s", name)
+		}
+		crashSignals[sig.(syscall.Signal)] = true
+	}
+	return &CrashCollector{programName: programName,
+		dir:      config.GetString("crash_dir", filepath.Join(os.TempDir(), "supervisord-crash")),
+		logBytes: int64(config.GetBytes("crash_log_bytes", 64*1024)),
+		signals:  crashSignals,
+		hook:     strings.TrimSpace(config.GetString("crash_hook", "")),
+		keep:     config.GetInt("crash_keep", 10)}, nil
+}
+
+// IsCrash checks if the program is crashed by the exit status
+func (cc *CrashCollector) IsCrash(status syscall.WaitStatus) bool {
+	return status.Signaled() && (cc.signals[status.Signal()] || status.CoreDump())
+}
+
+// readLogTail reads the last crash_log_bytes of the log
+func (cc *CrashCollector) readLogTail(log logger.Logger) string {
+	if log == nil || cc.logBytes <= 0 {
+		return ""
+	}
+	data, err := log.ReadLog(-cc.logBytes, 0)
+	if err != nil {
+		return ""
+	}
+	return data
+}
+
+// Collect writes the crash bundle and executes the crash_hook with the
+// bundle directory as argument. The path of the bundle is returned
+func (cc *CrashCollector) Collect(info *CrashInfo) (string, error) {
+	if b, err := os.ReadFile("/proc/sys/kernel/core_pattern"); err == nil {
+		info.CorePattern = strings.TrimSpace(string(b))
+	}
+	bundle := filepath.Join(cc.dir, fmt.Sprintf("%s-%s-%d", cc.programName, info.Stop.Format("20060102-150405"), info.Pid))
+	if err := os.MkdirAll(bundle, 0750); err != nil {
+		return "", fmt.Errorf("fail to create crash bundle %s: %v", bundle, err)
+	}
+	if coreFile := findCoreFile(info.Directory, info.Pid); coreFile != "" {
+		target := filepath.Join(bundle, filepath.Base(coreFile))
+		if err := moveFile(coreFile, target); err != nil {
+			log.WithFields(log.Fields{"program": cc.programName, "core": coreFile}).Warn("fail to move core file to crash bundle: ", err)
+		} else {
+			info.CoreFile = target
+		}
+	}
+	files := map[string]string{"stdout.log": info.stdout, "stderr.log": info.stderr}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return bundle, err
+	}
+	files["crash.json"] = string(b) + "\n"
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(bundle, name), []byte(content), 0640); err != nil {
+			return bundle, fmt.Errorf("fail to write %s of crash bundle: %v", name, err)
+		}
+	}
+	cc.removeOldBundles()
+
+	if cc.hook != "" {
+		err := NewScriptExecutor(fmt.Sprintf("%s \"%s\"", cc.hook, bundle)).Execute()
+		log.WithFields(log.Fields{"program": cc.programName, "crashHook": cc.hook}).Info("execute crash hook, result:", err)
+	}
+	return bundle, nil
+}
+
+// removeOldBundles keeps the latest crash_keep bundles of the program
+func (cc *CrashCollector) removeOldBundles() {
+	if cc.keep <= 0 {
+		return
+	}
+	bundles, err := filepath.Glob(filepath.Join(cc.dir, cc.programName+"-*"))
+	if err != nil || len(bundles) <= cc.keep {
+		return
+	}
+	// the bundle name contains the crash time, so the oldest one is the first
+	sort.Strings(bundles)
+	for _, bundle := range bundles[:len(bundles)-cc.keep] {
+		if err := os.RemoveAll(bundle); err != nil {
+			log.WithFields(log.Fields{"program": cc.programName, "bundle": bundle}).Warn("fail to remove old crash bundle: ", err)
+		}
+	}
+}
+
+// findCoreFile finds the core file dumped by the kernel with a relative
+// core_pattern like "core" or "core.%p" in the working directory
+func findCoreFile(dir string, pid int) string {
+	if dir == "" {
+		if wd, err := os.Getwd(); err == nil {
+			dir = wd
+		}
+	}
+	for _, name := range []string{fmt.Sprintf("core.%d", pid), "core"} {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// moveFile renames the file, or copies it if it is on another file system
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,116 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestCrashCollectIsDisabledByDefault(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewCrashCollector("test", cfg.GetProgram("test"))
+	if err != nil || cc != nil {
+		t.Errorf("expected no crash collector, got %v, %v", cc, err)
+	}
+}
+
+func TestIsCrash(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\ncrash_collect=true\ncrash_signals=segv, abrt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewCrashCollector("test", cfg.GetProgram("test"))
+	if err != nil || cc == nil {
+		t.Fatalf("fail to create crash collector: %v", err)
+	}
+	// the wait status of a process terminated by signal is the signal number
+	// with 0x80 bit for the core dump
+	if !cc.IsCrash(syscall.WaitStatus(syscall.SIGSEGV)) {
+		t.Error("SIGSEGV should be a crash")
+	}
+	if cc.IsCrash(syscall.WaitStatus(syscall.SIGTERM)) {
+		t.Error("SIGTERM should not be a crash")
+	}
+	if !cc.IsCrash(syscall.WaitStatus(uint32(syscall.SIGQUIT) | 0x80)) {
+		t.Error("the core dumped exit should be a crash")
+	}
+	if cc.IsCrash(syscall.WaitStatus(1 << 8)) {
+		t.Error("exit code 1 should not be a crash")
+	}
+}
+
+func TestCollectCrashBundle(t *testing.T) {
+	dir := t.TempDir()
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "core"), []byte("core"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\ncrash_collect=true\ncrash_dir="+dir+"\ncrash_keep=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewCrashCollector("test", cfg.GetProgram("test"))
+	if err != nil || cc == nil {
+		t.Fatalf("fail to create crash collector: %v", err)
+	}
+
+	stop := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	bundle, err := cc.Collect(&CrashInfo{Program: "test", Pid: 100, Signal: "segmentation fault", Directory: workDir, Stop: stop, stderr: "panic\n"})
+	if err != nil {
+		t.Fatalf("fail to collect crash bundle: %v", err)
+	}
+	if filepath.Base(bundle) != "test-20240102-030405-100" {
+		t.Errorf("unexpected bundle name %s", bundle)
+	}
+	if b, err := os.ReadFile(filepath.Join(bundle, "stderr.log")); err != nil || string(b) != "panic\n" {
+		t.Errorf("unexpected stderr.log %q, %v", string(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(bundle, "core")); err != nil {
+		t.Errorf("the core file is not moved to the bundle: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(bundle, "crash.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := CrashInfo{}
+	if err = json.Unmarshal(b, &info); err != nil || info.Pid != 100 || info.CoreFile == "" {
+		t.Errorf("unexpected crash.json %s, %v", string(b), err)
+	}
+
+	// only the latest crash_keep bundles are kept
+	for i := 1; i <= 2; i++ {
+		if _, err = cc.Collect(&CrashInfo{Program: "test", Pid: 100 + i, Stop: stop.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bundles, _ := filepath.Glob(filepath.Join(dir, "test-*"))
+	if len(bundles) != 2 {
+		t.Errorf("expected 2 bundles, got %v", bundles)
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Error("the oldest bundle should be removed")
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func createDependsOnTestManager(t *testing.T) (*Manager, *Process, *Process) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:db]\ncommand=sleep 100\nstartsecs=0\nautostart=false\n"+
+		"[program:web]\ncommand=sleep 100\nstartsecs=0\nautostart=false\ndepends_on=db\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	pm := NewManager()
+	for _, entry := range cfg.GetPrograms() {
+		pm.Add(entry.GetProgramName(), NewProcess("supervisord", entry))
+	}
+	t.Cleanup(pm.StopAllProcesses)
+	return pm, pm.Find("db"), pm.Find("web")
+}
+
+func TestStartProcessCanceledByStop(t *testing.T) {
+	pm, db, web := createDependsOnTestManager(t)
+	pm.StartProcess(web, false)
+	time.Sleep(100 * time.Millisecond)
+	if web.IsRunning() {
+		t.Fatal("expected web waits for db")
+	}
+	web.Stop(true)
+	db.Start(true)
+	time.Sleep(1500 * time.Millisecond)
+	if web.IsRunning() {
+		t.Error("expected web is not started after it is stopped in waiting")
+	}
+}
+
+func TestStartProcessesWithDependencies(t *testing.T) {
+	pm, db, web := createDependsOnTestManager(t)
+	pm.StartProcesses([]*Process{web, db}, true)
+	if !db.IsRunning() || !web.IsRunning() {
+		t.Fatal("expected both db and web are started")
+	}
+	// web keeps running if only db is stopped
+	done := make(chan struct{})
+	go func() {
+		pm.StopProcesses([]*Process{db}, true)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stopping db waits for web which is not stopped")
+	}
+	if db.IsRunning() || !web.IsRunning() {
+		t.Error("expected only db is stopped")
+	}
+}
+
+func TestStopProcessesInReverseDependencyOrder(t *testing.T) {
+	pm, db, web := createDependsOnTestManager(t)
+	pm.StartProcesses([]*Process{web, db}, true)
+	pm.StopProcesses([]*Process{db, web}, true)
+	if db.IsRunning() || web.IsRunning() {
+		t.Fatal("expected both db and web are stopped")
+	}
+	if db.GetStopTime().Before(web.GetStopTime()) {
+		t.Error("expected db is stopped after web")
+	}
+}
@@ -8,7 +8,9 @@ require (
 	github.com/mitchellh/go-ps v1.0.0
 	github.com/ochinchina/filechangemonitor v0.3.1
 	github.com/robfig/cron/v3 v3.0.1
+	github.com/shirou/gopsutil/v3 v3.24.5
 	github.com/sirupsen/logrus v1.10.0
+	golang.org/x/sys v0.47.0
 )
 
 require (
@@ -21,5 +23,4 @@ require (
 	github.com/ochinchina/gorilla-xmlrpc v0.0.0-20171012055324-ecf2fe693a2c // indirect
 	github.com/prometheus/procfs v0.21.1 // indirect
 	github.com/rogpeppe/go-charset v0.0.0-20190617161244-0dc95cdf6f31 // indirect
-	golang.org/x/sys v0.47.0 // indirect
 )
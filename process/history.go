@@ -0,0 +1,94 @@
+package process
+
+import (
+	"sync"
+
+	"github.com/ochinchina/supervisord/logger"
+	"github.com/ochinchina/supervisord/types"
+)
+
+// RunHistory keeps the last runs of a program
+type RunHistory struct {
+	lock sync.Mutex
+	runs []types.ProcessRun
+	size int
+}
+
+// NewRunHistory creates RunHistory object keeping at most size runs
+func NewRunHistory(size int) *RunHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &RunHistory{runs: make([]types.ProcessRun, 0), size: size}
+}
+
+// Add adds a run to the history, the oldest run is dropped if the history is full
+func (rh *RunHistory) Add(run types.ProcessRun) {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	if rh.size == 0 {
+		return
+	}
+	if len(rh.runs) >= rh.size {
+		rh.runs = append(rh.runs[:0], rh.runs[len(rh.runs)-rh.size+1:]...)
+	}
+	rh.runs = append(rh.runs, run)
+}
+
+// GetRuns returns the runs in the history, the latest run is the last one
+func (rh *RunHistory) GetRuns() []types.ProcessRun {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	runs := make([]types.ProcessRun, len(rh.runs))
+	copy(runs, rh.runs)
+	return runs
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	lock sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{buf: make([]byte, 0, size), size: size}
+}
+
+// Write keeps the last bytes of p, it never fails
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	n := len(p)
+	if n >= tb.size {
+		tb.buf = append(tb.buf[:0], p[n-tb.size:]...)
+		return n, nil
+	}
+	if len(tb.buf)+n > tb.size {
+		tb.buf = append(tb.buf[:0], tb.buf[len(tb.buf)+n-tb.size:]...)
+	}
+	tb.buf = append(tb.buf, p...)
+	return n, nil
+}
+
+// String returns the kept bytes
+func (tb *tailBuffer) String() string {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return string(tb.buf)
+}
+
+// tailLogger writes the program output to the tail buffer
+type tailLogger struct {
+	*logger.NullLogger
+	tail *tailBuffer
+}
+
+func newTailLogger(tail *tailBuffer) *tailLogger {
+	return &tailLogger{NullLogger: logger.NewNullLogger(logger.NewNullLogEventEmitter()), tail: tail}
+}
+
+// Write keeps the tail of the output
+func (tl *tailLogger) Write(p []byte) (int, error) {
+	return tl.tail.Write(p)
+}
@@ -0,0 +1,79 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/types"
+)
+
+func TestRunHistoryIsBounded(t *testing.T) {
+	history := NewRunHistory(3)
+	for pid := 1; pid <= 5; pid++ {
+		history.Add(types.ProcessRun{Pid: pid})
+	}
+	runs := history.GetRuns()
+	if len(runs) != 3 {
+		t.Fatalf("expected 3 runs, got %d", len(runs))
+	}
+	for i, run := range runs {
+		if run.Pid != i+3 {
+			t.Errorf("expected pid %d at %d, got %d", i+3, i, run.Pid)
+		}
+	}
+}
+
+func TestRunHistoryDisabled(t *testing.T) {
+	history := NewRunHistory(0)
+	history.Add(types.ProcessRun{Pid: 1})
+	if len(history.GetRuns()) != 0 {
+		t.Error("expected no runs when the history size is 0")
+	}
+}
+
+func TestTailBufferKeepsLastBytes(t *testing.T) {
+	tb := newTailBuffer(8)
+	_, _ = tb.Write([]byte("hello "))
+	_, _ = tb.Write([]byte("world"))
+	if s := tb.String(); s != "lo world" {
+		t.Errorf("expected %q, got %q", "lo world", s)
+	}
+	_, _ = tb.Write([]byte("0123456789"))
+	if s := tb.String(); s != "23456789" {
+		t.Errorf("expected %q, got %q", "23456789", s)
+	}
+}
+
+func TestStderrTailIsRedacted(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	content := "[program:test]\ncommand=/bin/sh -c \"echo password=hunter2 >&2; printf 'token=s3cret' >&2\"\n" +
+		"startsecs=0\nautorestart=false\nlog_redact=secrets\nstdout_logfile=/dev/null\nstderr_logfile=/dev/null\n"
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("test"))
+	proc.Start(true)
+	var runs []types.ProcessRun
+	for i := 0; i < 50 && len(runs) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		runs = proc.GetRunHistory()
+	}
+	if len(runs) != 1 {
+		t.Fatalf("expected one run in the history, got %v", runs)
+	}
+	tail := runs[0].Stderrtail
+	if strings.Contains(tail, "hunter2") || strings.Contains(tail, "s3cret") {
+		t.Errorf("expected the secrets are redacted in the stderr tail, got %q", tail)
+	}
+	if tail != "password=[REDACTED]\ntoken=[REDACTED]\n" {
+		t.Errorf("unexpected stderr tail %q", tail)
+	}
+}
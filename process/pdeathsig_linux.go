@@ -1,3 +1,4 @@
+//go:build linux
 // +build linux
 
 package process
@@ -6,7 +7,11 @@ import (
 	"syscall"
 )
 
-func setDeathsig(sysProcAttr *syscall.SysProcAttr) {
+// setDeathsig makes the program be killed when supervisord exits, unless the
+// program can be adopted by the next supervisord
+func setDeathsig(sysProcAttr *syscall.SysProcAttr, adoptable bool) {
 	sysProcAttr.Setpgid = true
-	sysProcAttr.Pdeathsig = syscall.SIGKILL
+	if !adoptable {
+		sysProcAttr.Pdeathsig = syscall.SIGKILL
+	}
 }
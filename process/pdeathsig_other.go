@@ -1,5 +1,5 @@
-// +build !linux
-// +build !windows
+//go:build !linux && !windows
+// +build !linux,!windows
 
 package process
 
@@ -7,6 +7,6 @@ import (
 	"syscall"
 )
 
-func setDeathsig(sysProcAttr *syscall.SysProcAttr) {
+func setDeathsig(sysProcAttr *syscall.SysProcAttr, _ bool) {
 	sysProcAttr.Setpgid = true
 }
@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 package process
@@ -6,5 +7,5 @@ import (
 	"syscall"
 )
 
-func setDeathsig(_ *syscall.SysProcAttr) {
+func setDeathsig(_ *syscall.SysProcAttr, _ bool) {
 }
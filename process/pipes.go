@@ -0,0 +1,222 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// processPipes the stdin, stdout and stderr pipes of the adoptable program.
+// They are created by supervisord instead of os/exec, so the ends of the
+// pipes kept by supervisord can be passed to the new supervisord on upgrade.
+// The stdout and stderr are the named pipes if possible, so their read ends
+// can be opened again by the next supervisord after a crash
+type processPipes struct {
+	// the write end of stdin, the read ends of stdout and stderr. The stdin
+	// is nil if the process is adopted after a crash
+	stdin  *os.File
+	stdout *os.File
+	stderr *os.File
+	// the ends of the pipes used by the program, closed after it is spawned
+	childFiles []*os.File
+	// the named pipes removed after the program exits
+	fifos []string
+	// done when the output of the program is copied to the logs
+	copying sync.WaitGroup
+}
+
+// newProcessPipes creates the pipes of the program to be spawned by the
+// command. The output of the program is copied to the stdout and stderr
+// writers of the command. The stdout and stderr are written to the named
+// pipes stdoutFifo and stderrFifo if they are not empty
+func newProcessPipes(cmd *exec.Cmd, stdoutFifo string, stderrFifo string) (*processPipes, error) {
+	pipes := &processPipes{}
+	stdin, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdout, err := pipes.createOutputPipe(stdoutFifo)
+	if err != nil {
+		stdin.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	stderrR, stderr, err := pipes.createOutputPipe(stderrFifo)
+	if err != nil {
+		for _, f := range []*os.File{stdin, stdinW, stdoutR, stdout} {
+			f.Close()
+		}
+		pipes.removeFifos()
+		return nil, err
+	}
+	pipes.stdin, pipes.stdout, pipes.stderr = stdinW, stdoutR, stderrR
+	pipes.childFiles = []*os.File{stdin, stdout, stderr}
+	pipes.copyOutput(pipes.stdout, cmd.Stdout)
+	pipes.copyOutput(pipes.stderr, cmd.Stderr)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return pipes, nil
+}
+
+// createOutputPipe creates the named pipe if fifo is not empty, or the pipe
+// if the named pipe is not supported
+func (pp *processPipes) createOutputPipe(fifo string) (*os.File, *os.File, error) {
+	if fifo != "" {
+		r, w, err := createOutputFifo(fifo)
+		if err == nil {
+			pp.fifos = append(pp.fifos, fifo)
+			return r, w, nil
+		}
+		log.WithFields(log.Fields{"fifo": fifo}).Warn("fail to create the named pipe, the output can't be captured after supervisord crashes: ", err)
+	}
+	return os.Pipe()
+}
+
+// inheritProcessPipes gets the pipes of the adopted program from the file
+// descriptors inherited from the previous supervisord. Returns nil if the
+// pipes are not inherited. The named pipes written by the program are still
+// removed after it exits
+func inheritProcessPipes(record *ProcessRecord, cmd *exec.Cmd, stdoutFifo string, stderrFifo string) (*processPipes, error) {
+	if record.Stdout <= 0 || record.Stderr <= 0 {
+		return nil, nil
+	}
+	pipes := &processPipes{}
+	var err error
+	// the stdin is not inherited if the process is adopted after a crash
+	if record.Stdin > 0 {
+		if pipes.stdin, err = inheritFile(record.Stdin, "stdin"); err != nil {
+			return nil, err
+		}
+	}
+	if pipes.stdout, err = inheritFile(record.Stdout, "stdout"); err != nil {
+		return nil, err
+	}
+	if pipes.stderr, err = inheritFile(record.Stderr, "stderr"); err != nil {
+		return nil, err
+	}
+	for _, fifo := range []string{stdoutFifo, stderrFifo} {
+		if isFifo(fifo) {
+			pipes.fifos = append(pipes.fifos, fifo)
+		}
+	}
+	pipes.copyOutput(pipes.stdout, cmd.Stdout)
+	pipes.copyOutput(pipes.stderr, cmd.Stderr)
+	return pipes, nil
+}
+
+// reopenProcessPipes opens the read ends of the named pipes the adopted
+// program writes its output to after the previous supervisord crashed. The
+// output written by the program meanwhile is kept in the named pipes
+func reopenProcessPipes(cmd *exec.Cmd, stdoutFifo string, stderrFifo string) (*processPipes, error) {
+	pipes := &processPipes{}
+	var err error
+	if pipes.stdout, err = openOutputFifo(stdoutFifo); err != nil {
+		return nil, err
+	}
+	if pipes.stderr, err = openOutputFifo(stderrFifo); err != nil {
+		pipes.stdout.Close()
+		return nil, err
+	}
+	pipes.fifos = []string{stdoutFifo, stderrFifo}
+	pipes.copyOutput(pipes.stdout, cmd.Stdout)
+	pipes.copyOutput(pipes.stderr, cmd.Stderr)
+	return pipes, nil
+}
+
+// isFifo checks if the file is a named pipe
+func isFifo(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// inheritFile gets the inherited file descriptor and makes it not to be
+// inherited by the spawned programs
+func inheritFile(fd int, name string) (*os.File, error) {
+	if err := SetCloseOnExec(fd, true); err != nil {
+		return nil, fmt.Errorf("invalid inherited %s %d: %v", name, fd, err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+func (pp *processPipes) copyOutput(r *os.File, w io.Writer) {
+	pp.copying.Add(1)
+	go func() {
+		defer pp.copying.Done()
+		if w == nil {
+			w = io.Discard
+		}
+		_, _ = io.Copy(w, r)
+		r.Close()
+	}()
+}
+
+// closeChildFiles closes the ends of the pipes used by the program after the
+// program is spawned
+func (pp *processPipes) closeChildFiles() {
+	if pp == nil {
+		return
+	}
+	for _, f := range pp.childFiles {
+		f.Close()
+	}
+	pp.childFiles = nil
+}
+
+// wait waits until all the output of the program is copied and closes stdin
+func (pp *processPipes) wait() {
+	if pp == nil {
+		return
+	}
+	pp.closeChildFiles()
+	pp.copying.Wait()
+	if pp.stdin != nil {
+		pp.stdin.Close()
+	}
+	pp.removeFifos()
+}
+
+// removeFifos removes the named pipes which are not used anymore
+func (pp *processPipes) removeFifos() {
+	for _, fifo := range pp.fifos {
+		_ = os.Remove(fifo)
+	}
+	pp.fifos = nil
+}
+
+// inherit makes the pipes to be inherited by the new supervisord and records
+// their file descriptors
+func (pp *processPipes) inherit(record *ProcessRecord) error {
+	if pp == nil {
+		return nil
+	}
+	fds := make([]int, 0, 3)
+	for _, f := range []*os.File{pp.stdin, pp.stdout, pp.stderr} {
+		if f == nil {
+			fds = append(fds, 0)
+			continue
+		}
+		fd := int(f.Fd())
+		if err := SetCloseOnExec(fd, false); err != nil {
+			return err
+		}
+		fds = append(fds, fd)
+	}
+	record.Stdin, record.Stdout, record.Stderr = fds[0], fds[1], fds[2]
+	return nil
+}
+
+// uninherit makes the pipes not to be inherited by the spawned programs
+// again after the upgrade is failed
+func (pp *processPipes) uninherit() {
+	if pp == nil {
+		return
+	}
+	for _, f := range []*os.File{pp.stdin, pp.stdout, pp.stderr} {
+		if f != nil {
+			_ = SetCloseOnExec(int(f.Fd()), true)
+		}
+	}
+}
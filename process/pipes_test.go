@@ -0,0 +1,117 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestProcessPipesCopyOutput(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", "cat; echo err >&2")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	pipes, err := newProcessPipes(cmd, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		pipes.wait()
+		t.Skip("sh is not available")
+	}
+	pipes.closeChildFiles()
+	_, _ = pipes.stdin.Write([]byte("out\n"))
+	pipes.stdin.Close()
+	_ = cmd.Wait()
+	pipes.wait()
+	if stdout.String() != "out\n" {
+		t.Errorf("expected stdout %q, got %q", "out\n", stdout.String())
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("expected stderr %q, got %q", "err\n", stderr.String())
+	}
+}
+
+func TestProcessPipesInherit(t *testing.T) {
+	cmd := exec.Command("true")
+	pipes, err := newProcessPipes(cmd, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipes.wait()
+	record := ProcessRecord{}
+	if err = pipes.inherit(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Stdin != int(pipes.stdin.Fd()) || record.Stdout != int(pipes.stdout.Fd()) || record.Stderr != int(pipes.stderr.Fd()) {
+		t.Errorf("expected the pipe fds are recorded, got %+v", record)
+	}
+	if isCloseOnExec(t, record.Stdout) {
+		t.Error("expected the inherited pipe is not close-on-exec")
+	}
+	pipes.uninherit()
+	for _, fd := range []int{record.Stdin, record.Stdout, record.Stderr} {
+		if !isCloseOnExec(t, fd) {
+			t.Errorf("expected the pipe %d is close-on-exec after the upgrade is failed", fd)
+		}
+	}
+}
+
+func TestProcessPipesFifoOutlivesReader(t *testing.T) {
+	dir := t.TempDir()
+	stdoutFifo, stderrFifo := filepath.Join(dir, "test.stdout"), filepath.Join(dir, "test.stderr")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", "echo before; read x; echo after; echo err >&2")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	pipes, err := newProcessPipes(cmd, stdoutFifo, stderrFifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isFifo(stdoutFifo) || !isFifo(stderrFifo) {
+		t.Fatal("expected the output is written to the named pipes")
+	}
+	if err = cmd.Start(); err != nil {
+		pipes.wait()
+		t.Skip("sh is not available")
+	}
+	pipes.closeChildFiles()
+	// supervisord crashes, the read ends of the output are closed
+	pipes.stdout.Close()
+	pipes.stderr.Close()
+	pipes.copying.Wait()
+	_, _ = pipes.stdin.Write([]byte("go\n"))
+	pipes.stdin.Close()
+
+	// the next supervisord reads the output written meanwhile
+	reopenedStdout, reopenedStderr := &bytes.Buffer{}, &bytes.Buffer{}
+	reopened, err := reopenProcessPipes(&exec.Cmd{Stdout: reopenedStdout, Stderr: reopenedStderr}, stdoutFifo, stderrFifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("expected the program is not broken by the closed reader: %v", err)
+	}
+	reopened.wait()
+	if output := stdout.String() + reopenedStdout.String(); output != "before\nafter\n" {
+		t.Errorf("expected the whole stdout is captured, got %q", output)
+	}
+	if reopenedStderr.String() != "err\n" {
+		t.Errorf("expected stderr %q, got %q", "err\n", reopenedStderr.String())
+	}
+	if isFifo(stdoutFifo) || isFifo(stderrFifo) {
+		t.Error("expected the named pipes are removed after the program exits")
+	}
+}
+
+func isCloseOnExec(t *testing.T, fd int) bool {
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flags&unix.FD_CLOEXEC != 0
+}
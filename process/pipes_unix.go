@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// the output of the program can be written to the named pipes
+const outputFifoSupported = true
+
+// SetCloseOnExec sets or clears the close-on-exec flag of the file descriptor
+func SetCloseOnExec(fd int, closeOnExec bool) error {
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	if err != nil {
+		return err
+	}
+	if closeOnExec {
+		flags |= unix.FD_CLOEXEC
+	} else {
+		flags &^= unix.FD_CLOEXEC
+	}
+	_, err = unix.FcntlInt(uintptr(fd), unix.F_SETFD, flags)
+	return err
+}
+
+// createOutputFifo creates the named pipe the program writes its output to
+// and opens both ends of it. The program opens the named pipe for reading and
+// writing, so it never gets SIGPIPE or EPIPE when supervisord crashes: its
+// output is kept in the named pipe until the next supervisord reads it, and
+// the program is blocked if the named pipe is full
+func createOutputFifo(name string) (*os.File, *os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return nil, nil, err
+	}
+	_ = os.Remove(name)
+	if err := unix.Mkfifo(name, 0600); err != nil {
+		return nil, nil, err
+	}
+	r, err := openOutputFifo(name)
+	if err != nil {
+		_ = os.Remove(name)
+		return nil, nil, err
+	}
+	w, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		r.Close()
+		_ = os.Remove(name)
+		return nil, nil, err
+	}
+	return r, w, nil
+}
+
+// openOutputFifo opens the read end of the named pipe without waiting for
+// the writer
+func openOutputFifo(name string) (*os.File, error) {
+	if !isFifo(name) {
+		return nil, fmt.Errorf("%s is not a named pipe", name)
+	}
+	return os.OpenFile(name, os.O_RDONLY|unix.O_NONBLOCK, 0)
+}
@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+)
+
+// the named pipes of the program output are not supported on windows
+const outputFifoSupported = false
+
+// SetCloseOnExec returns error because the file descriptors can't be
+// inherited by exec on windows
+func SetCloseOnExec(fd int, closeOnExec bool) error {
+	return fmt.Errorf("inheriting file descriptor is not supported on windows")
+}
+
+// createOutputFifo returns error because the named pipes are not supported
+func createOutputFifo(name string) (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("named pipe is not supported on windows")
+}
+
+// openOutputFifo returns error because the named pipes are not supported
+func openOutputFifo(name string) (*os.File, error) {
+	return nil, fmt.Errorf("named pipe is not supported on windows")
+}
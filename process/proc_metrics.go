@@ -1,7 +1,10 @@
 package process
 
 import (
+	"github.com/ochinchina/supervisord/logger"
 	"github.com/prometheus/client_golang/prometheus"
+	psprocess "github.com/shirou/gopsutil/v3/process"
+	log "github.com/sirupsen/logrus"
 )
 
 const namespace = "node"
@@ -11,7 +14,38 @@ type procCollector struct {
 	stateDesc      *prometheus.Desc
 	exitStatusDesc *prometheus.Desc
 	startTimeDesc  *prometheus.Desc
-	procMgr        *Manager
+	cpuDesc        *prometheus.Desc
+	rssDesc        *prometheus.Desc
+	vmsDesc        *prometheus.Desc
+	fdsDesc        *prometheus.Desc
+	threadsDesc    *prometheus.Desc
+	readBytesDesc  *prometheus.Desc
+	writeBytesDesc *prometheus.Desc
+	restartsDesc   *prometheus.Desc
+	stateTimeDesc  *prometheus.Desc
+	// the accounting of the program cgroup
+	cgroupMemoryDesc  *prometheus.Desc
+	cgroupCPUDesc     *prometheus.Desc
+	cgroupPidsDesc    *prometheus.Desc
+	cgroupIOReadDesc  *prometheus.Desc
+	cgroupIOWriteDesc *prometheus.Desc
+	// the log shipping of the program
+	logSinkSentDesc     *prometheus.Desc
+	logSinkDroppedDesc  *prometheus.Desc
+	logSinkFailedDesc   *prometheus.Desc
+	logSinkBufferedDesc *prometheus.Desc
+	procMgr             *Manager
+}
+
+// resourceUsage the resource usage of a process (or a process tree)
+type resourceUsage struct {
+	cpuSeconds float64
+	rss        uint64
+	vms        uint64
+	fds        int32
+	threads    int32
+	readBytes  uint64
+	writeBytes uint64
 }
 
 // NewProcCollector returns new Collector exposing supervisord statistics.
@@ -46,6 +80,114 @@ func NewProcCollector(mgr *Manager) *procCollector {
 			labelNames,
 			nil,
 		),
+		cpuDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_cpu_seconds_total"),
+			"Total user and system CPU time spent by the process in seconds",
+			labelNames,
+			nil,
+		),
+		rssDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_resident_memory_bytes"),
+			"Resident memory size of the process in bytes",
+			labelNames,
+			nil,
+		),
+		vmsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_virtual_memory_bytes"),
+			"Virtual memory size of the process in bytes",
+			labelNames,
+			nil,
+		),
+		fdsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_open_fds"),
+			"Number of open file descriptors of the process",
+			labelNames,
+			nil,
+		),
+		threadsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_threads"),
+			"Number of threads of the process",
+			labelNames,
+			nil,
+		),
+		readBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_read_bytes_total"),
+			"Number of bytes read by the process",
+			labelNames,
+			nil,
+		),
+		writeBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "process_write_bytes_total"),
+			"Number of bytes written by the process",
+			labelNames,
+			nil,
+		),
+		restartsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "restarts_total"),
+			"Number of times the process is started again after its first start",
+			labelNames,
+			nil,
+		),
+		stateTimeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "state_seconds_total"),
+			"Total time the process spent in each state in seconds",
+			append(labelNames, "state"),
+			nil,
+		),
+		cgroupMemoryDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cgroup_memory_bytes"),
+			"Memory usage of the program cgroup in bytes",
+			labelNames,
+			nil,
+		),
+		cgroupCPUDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cgroup_cpu_seconds_total"),
+			"Total CPU time spent by the program cgroup in seconds",
+			labelNames,
+			nil,
+		),
+		cgroupPidsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cgroup_pids"),
+			"Number of processes in the program cgroup",
+			labelNames,
+			nil,
+		),
+		cgroupIOReadDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cgroup_io_read_bytes_total"),
+			"Number of bytes read by the program cgroup",
+			labelNames,
+			nil,
+		),
+		cgroupIOWriteDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cgroup_io_write_bytes_total"),
+			"Number of bytes written by the program cgroup",
+			labelNames,
+			nil,
+		),
+		logSinkSentDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "log_sink_sent_lines_total"),
+			"Number of log lines sent to the log sink",
+			append(labelNames, "sink"),
+			nil,
+		),
+		logSinkDroppedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "log_sink_dropped_lines_total"),
+			"Number of log lines dropped because the buffer is full or the retries are exhausted",
+			append(labelNames, "sink"),
+			nil,
+		),
+		logSinkFailedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "log_sink_failed_requests_total"),
+			"Number of failed requests to the log sink",
+			append(labelNames, "sink"),
+			nil,
+		),
+		logSinkBufferedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "log_sink_buffered_lines"),
+			"Number of log lines waiting to be sent to the log sink",
+			append(labelNames, "sink"),
+			nil,
+		),
 		procMgr: mgr,
 	}
 }
@@ -56,6 +198,24 @@ func (c *procCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.stateDesc
 	ch <- c.exitStatusDesc
 	ch <- c.startTimeDesc
+	ch <- c.cpuDesc
+	ch <- c.rssDesc
+	ch <- c.vmsDesc
+	ch <- c.fdsDesc
+	ch <- c.threadsDesc
+	ch <- c.readBytesDesc
+	ch <- c.writeBytesDesc
+	ch <- c.restartsDesc
+	ch <- c.stateTimeDesc
+	ch <- c.cgroupMemoryDesc
+	ch <- c.cgroupCPUDesc
+	ch <- c.cgroupPidsDesc
+	ch <- c.cgroupIOReadDesc
+	ch <- c.cgroupIOWriteDesc
+	ch <- c.logSinkSentDesc
+	ch <- c.logSinkDroppedDesc
+	ch <- c.logSinkFailedDesc
+	ch <- c.logSinkBufferedDesc
 }
 
 // Collect gathers prometheus metrics for all supervised processes
@@ -70,12 +230,105 @@ func (c *procCollector) collectProcessMetrics(proc *Process, ch chan<- prometheu
 
 	ch <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, float64(proc.GetState()), labels...)
 	ch <- prometheus.MustNewConstMetric(c.exitStatusDesc, prometheus.GaugeValue, float64(proc.GetExitstatus()), labels...)
+	ch <- prometheus.MustNewConstMetric(c.restartsDesc, prometheus.CounterValue, float64(proc.GetRestartCount()), labels...)
+	for state, d := range proc.GetStateDurations() {
+		ch <- prometheus.MustNewConstMetric(c.stateTimeDesc, prometheus.CounterValue, d.Seconds(), append(labels, state.String())...)
+	}
 
 	if proc.IsRunning() {
 		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 1, labels...)
 		ch <- prometheus.MustNewConstMetric(c.startTimeDesc, prometheus.CounterValue, float64(proc.GetStartTime().Unix()), labels...)
+		c.collectResourceMetrics(proc, labels, ch)
 	} else {
 		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0, labels...)
 	}
+	c.collectCgroupMetrics(proc, labels, ch)
+	c.collectLogSinkMetrics(proc, labels, ch)
+}
+
+func (c *procCollector) collectLogSinkMetrics(proc *Process, labels []string, ch chan<- prometheus.Metric) {
+	for _, stats := range logger.GetLogSinkStats(proc.GetName()) {
+		sinkLabels := append(labels[:len(labels):len(labels)], stats.Sink)
+		ch <- prometheus.MustNewConstMetric(c.logSinkSentDesc, prometheus.CounterValue, float64(stats.Sent), sinkLabels...)
+		ch <- prometheus.MustNewConstMetric(c.logSinkDroppedDesc, prometheus.CounterValue, float64(stats.Dropped), sinkLabels...)
+		ch <- prometheus.MustNewConstMetric(c.logSinkFailedDesc, prometheus.CounterValue, float64(stats.Failed), sinkLabels...)
+		ch <- prometheus.MustNewConstMetric(c.logSinkBufferedDesc, prometheus.GaugeValue, float64(stats.Buffered), sinkLabels...)
+	}
+}
+
+func (c *procCollector) collectCgroupMetrics(proc *Process, labels []string, ch chan<- prometheus.Metric) {
+	cgroup := proc.GetCgroup()
+	if cgroup == nil {
+		return
+	}
+	stats, err := cgroup.ReadStats()
+	if err != nil {
+		log.WithFields(log.Fields{"program": proc.GetName(), "cgroup": cgroup.GetPath()}).Debug("failed to read cgroup accounting: ", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.cgroupMemoryDesc, prometheus.GaugeValue, float64(stats.MemoryCurrent), labels...)
+	ch <- prometheus.MustNewConstMetric(c.cgroupCPUDesc, prometheus.CounterValue, stats.CPUSeconds, labels...)
+	ch <- prometheus.MustNewConstMetric(c.cgroupPidsDesc, prometheus.GaugeValue, float64(stats.PidsCurrent), labels...)
+	ch <- prometheus.MustNewConstMetric(c.cgroupIOReadDesc, prometheus.CounterValue, float64(stats.IOReadBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(c.cgroupIOWriteDesc, prometheus.CounterValue, float64(stats.IOWriteBytes), labels...)
+}
+
+func (c *procCollector) collectResourceMetrics(proc *Process, labels []string, ch chan<- prometheus.Metric) {
+	pid := proc.GetPid()
+	if pid <= 0 {
+		return
+	}
+	usage := &resourceUsage{}
+	if err := addResourceUsage(int32(pid), proc.GetConfig().GetBool("metrics_process_tree", false), usage); err != nil {
+		log.WithFields(log.Fields{"program": proc.GetName()}).Debug("failed to get resource usage of program: ", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.cpuDesc, prometheus.CounterValue, usage.cpuSeconds, labels...)
+	ch <- prometheus.MustNewConstMetric(c.rssDesc, prometheus.GaugeValue, float64(usage.rss), labels...)
+	ch <- prometheus.MustNewConstMetric(c.vmsDesc, prometheus.GaugeValue, float64(usage.vms), labels...)
+	ch <- prometheus.MustNewConstMetric(c.fdsDesc, prometheus.GaugeValue, float64(usage.fds), labels...)
+	ch <- prometheus.MustNewConstMetric(c.threadsDesc, prometheus.GaugeValue, float64(usage.threads), labels...)
+	ch <- prometheus.MustNewConstMetric(c.readBytesDesc, prometheus.CounterValue, float64(usage.readBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(c.writeBytesDesc, prometheus.CounterValue, float64(usage.writeBytes), labels...)
+}
 
+// addResourceUsage adds the resource usage of process pid (and all its
+// descendants if tree is true) to usage
+func addResourceUsage(pid int32, tree bool, usage *resourceUsage) error {
+	p, err := psprocess.NewProcess(pid)
+	if err != nil {
+		return err
+	}
+	if times, err := p.Times(); err == nil {
+		usage.cpuSeconds += times.User + times.System
+	}
+	if mem, err := p.MemoryInfo(); err == nil {
+		usage.rss += mem.RSS
+		usage.vms += mem.VMS
+	}
+	// the following information may be not available on some platforms
+	// or without privilege
+	if fds, err := p.NumFDs(); err == nil {
+		usage.fds += fds
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		usage.threads += threads
+	}
+	if io, err := p.IOCounters(); err == nil {
+		usage.readBytes += io.ReadBytes
+		usage.writeBytes += io.WriteBytes
+	}
+	if !tree {
+		return nil
+	}
+	children, err := p.Children()
+	if err != nil {
+		// no children
+		return nil
+	}
+	for _, child := range children {
+		// the child may have exited
+		_ = addResourceUsage(child.Pid, true, usage)
+	}
+	return nil
 }
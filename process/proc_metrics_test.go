@@ -0,0 +1,34 @@
+package process
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAtomicStateDurations(t *testing.T) {
+	state := NewAtomicState(Stopped)
+	time.Sleep(20 * time.Millisecond)
+	state.Store(Running)
+	time.Sleep(20 * time.Millisecond)
+	if !state.CompareAndSwap(Running, Exited) {
+		t.Fatal("fail to change state from Running to Exited")
+	}
+	durations := state.GetDurations()
+	if durations[Stopped] < 20*time.Millisecond || durations[Running] < 20*time.Millisecond {
+		t.Errorf("unexpected state durations: %v", durations)
+	}
+	if _, ok := durations[Exited]; !ok {
+		t.Error("the time in current state is not included")
+	}
+}
+
+func TestAddResourceUsage(t *testing.T) {
+	usage := &resourceUsage{}
+	if err := addResourceUsage(int32(os.Getpid()), true, usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.rss == 0 || usage.threads == 0 {
+		t.Errorf("unexpected resource usage: %+v", usage)
+	}
+}
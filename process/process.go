@@ -20,6 +20,7 @@ import (
 	"github.com/ochinchina/supervisord/events"
 	"github.com/ochinchina/supervisord/logger"
 	"github.com/ochinchina/supervisord/signals"
+	"github.com/ochinchina/supervisord/types"
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 )
@@ -55,6 +56,10 @@ const (
 
 type AtomicState struct {
 	v atomic.Int32
+	// guards the state change time and the time spent in each state
+	lock       sync.Mutex
+	changeTime time.Time
+	durations  map[State]time.Duration
 }
 
 func (s *AtomicState) Load() State {
@@ -62,16 +67,44 @@ func (s *AtomicState) Load() State {
 }
 
 func (s *AtomicState) Store(state State) {
-	s.v.Store(int32(state))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(State(s.v.Swap(int32(state))))
 }
 
 func (s *AtomicState) CompareAndSwap(old, new State) bool {
-	return s.v.CompareAndSwap(int32(old), int32(new))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.v.CompareAndSwap(int32(old), int32(new)) {
+		return false
+	}
+	s.accumulate(old)
+	return true
+}
+
+// accumulate adds the time since last state change to the old state
+func (s *AtomicState) accumulate(old State) {
+	now := time.Now()
+	s.durations[old] += now.Sub(s.changeTime)
+	s.changeTime = now
+}
+
+// GetDurations returns the total time spent in each state, including the
+// time spent in current state until now
+func (s *AtomicState) GetDurations() map[State]time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	durations := make(map[State]time.Duration, len(s.durations)+1)
+	for state, d := range s.durations {
+		durations[state] = d
+	}
+	durations[s.Load()] += time.Since(s.changeTime)
+	return durations
 }
 
 func NewAtomicState(state State) *AtomicState {
-	atomicState := AtomicState{v: atomic.Int32{}}
-	atomicState.Store(state)
+	atomicState := AtomicState{v: atomic.Int32{}, changeTime: time.Now(), durations: make(map[State]time.Duration)}
+	atomicState.v.Store(int32(state))
 	return &atomicState
 }
 
@@ -231,17 +264,71 @@ type Process struct {
 	cmd          *exec.Cmd
 	startTime    time.Time
 	stopTime     time.Time
-	state        *AtomicState
+	// the time the process changed to Running state
+	runningTime time.Time
+	state       *AtomicState
 	// true if process is starting
 	inStart bool
 	// true if the process is stopped by user
-	stopByUser      *atomic.Bool
-	retryTimes      *atomic.Int32
-	lock            sync.RWMutex
-	stdin           io.WriteCloser
-	StdoutLog       logger.Logger
-	StderrLog       logger.Logger
-	livenessChecker *LivenessChecker
+	stopByUser *atomic.Bool
+	retryTimes *atomic.Int32
+	// true if the process is stopped because its dependency is in Fatal state
+	stoppedByDependency atomic.Bool
+	// true if the process in Fatal state should be retried right now
+	retryFatalNow atomic.Bool
+	// the number of times the program is started
+	startCount       atomic.Int64
+	backoff          *RestartBackoff
+	lock             sync.RWMutex
+	stdin            io.WriteCloser
+	StdoutLog        logger.Logger
+	StderrLog        logger.Logger
+	livenessChecker  *LivenessChecker
+	readinessChecker *ReadinessChecker
+	watchdog         *ResourceWatchdog
+	// true if the action of exceeded resource limit is in progress
+	inLimitAction atomic.Bool
+	rlimits       []rlimitSetting
+	rlimitHelper  *rlimitHelper
+	cgroup        *Cgroup
+	// the opened cgroup directory, it is closed after the program is started
+	cgroupFile *os.File
+	history    *RunHistory
+	// the tail of the stderr of current run
+	stderrTail *tailBuffer
+	// the redacting logger writes the stderr to stderrTail
+	stderrTailLog logger.Logger
+	// who stops current run of the program
+	stopReason string
+	// the error of last failed spawn
+	spawnErr string
+	// the time current run of the program is spawned
+	spawnTime      time.Time
+	crashCollector *CrashCollector
+	// the store to record the spawned process for adoption, nil if the
+	// adoption is disabled
+	processStore *StateStore
+	// the process of the previous supervisord to be adopted in next run
+	adoptRecord *ProcessRecord
+	// the adopted process of current run, nil if current run is spawned
+	adopted *ProcessRecord
+	// the pipes of the adoptable program, nil if the pipes are created by os/exec
+	pipes *processPipes
+	// the listening socket passed to the program, nil if socket is not set
+	socket *programSocket
+	// changed to stop watching the socket for the program started on connection
+	lazyStartGen atomic.Int64
+	// changed to cancel the start waiting for the dependencies when the
+	// program is stopped
+	dependsOnGen atomic.Int64
+	// protects childLogDir, autoLogFiles and stripAnsi
+	childLogLock sync.Mutex
+	// the directory of the AUTO log files, the temporary directory if empty
+	childLogDir string
+	// the AUTO log files by stream
+	autoLogFiles map[string]string
+	// true if the ANSI escape sequences are removed from the output
+	stripAnsi bool
 }
 
 // NewProcess creates new Process object
@@ -261,6 +348,15 @@ func NewProcess(supervisorID string, config *config.Entry) *Process {
 	proc.config = config
 	proc.cmd = nil
 	proc.livenessChecker = NewLivenessChecker(proc.GetName(), config)
+	proc.readinessChecker = NewReadinessChecker(proc.GetName(), config)
+	proc.watchdog = NewResourceWatchdog(proc.GetName(), config)
+	proc.history = NewRunHistory(config.GetInt("history_size", 10))
+	crashCollector, err := NewCrashCollector(proc.GetName(), config)
+	if err != nil {
+		log.WithFields(log.Fields{"program": proc.GetName()}).Error("fail to enable crash_collect: ", err)
+	}
+	proc.crashCollector = crashCollector
+	proc.backoff = NewRestartBackoff(config)
 	proc.addToCron()
 	return proc
 }
@@ -296,10 +392,10 @@ func (p *Process) DoLivenessCheck() {
 			log.WithFields(log.Fields{"program": p.GetName()}).Info("liveness check failed, action:", failureAction)
 			switch failureAction {
 			case "restart":
-				p.Stop(true)
+				p.stopBy("liveness_check", true)
 				p.Start(true)
 			case "stop":
-				p.Stop(true)
+				p.stopBy("liveness_check", true)
 			default:
 				err := NewScriptExecutor(failureAction).Execute()
 				log.WithFields(log.Fields{"program": p.GetName(), "failureAction": failureAction}).Info("execute liveness check failure action script, result:", err)
@@ -308,6 +404,46 @@ func (p *Process) DoLivenessCheck() {
 	}
 }
 
+// DoResourceCheck checks the memory and cpu usage of the running program and
+// takes the limit_action if the memory_limit or cpu_limit is exceeded
+func (p *Process) DoResourceCheck() {
+	if p.watchdog == nil || p.GetState() != Running || p.inLimitAction.Load() {
+		return
+	}
+	pid := p.GetPid()
+	if pid <= 0 {
+		return
+	}
+	exceeded := p.watchdog.Check(pid)
+	if exceeded == nil || !p.inLimitAction.CompareAndSwap(false, true) {
+		return
+	}
+	action := p.watchdog.GetAction()
+	log.WithFields(log.Fields{"program": p.GetName(), "limit": exceeded.Limit, "value": exceeded.Value, "threshold": exceeded.Threshold}).Warn("resource limit exceeded, action:", action)
+	if p.config.IsProgram() {
+		events.EmitEvent(events.CreateProcessLimitExceededEvent(p.GetName(), p.GetGroup(), pid, exceeded.Limit, exceeded.Value, exceeded.Threshold, action))
+	}
+	go func() {
+		defer p.inLimitAction.Store(false)
+		defer p.watchdog.Reset()
+		switch action {
+		case "restart":
+			p.stopBy("resource_limit", true)
+			p.Start(true)
+		case "stop":
+			p.stopBy("resource_limit", true)
+		case "signal":
+			err := p.Signal(p.watchdog.GetSignal(), false)
+			if err != nil {
+				log.WithFields(log.Fields{"program": p.GetName(), "signal": p.watchdog.GetSignal()}).Error("fail to send signal for exceeded resource limit: ", err)
+			}
+		default:
+			err := NewScriptExecutor(action).Execute()
+			log.WithFields(log.Fields{"program": p.GetName(), "limitAction": action}).Info("execute resource limit action script, result:", err)
+		}
+	}()
+}
+
 // Start process
 // Args:
 //
@@ -316,13 +452,21 @@ func (p *Process) Start(wait bool) {
 	log.WithFields(log.Fields{"program": p.GetName()}).Info("try to start program")
 	p.lock.Lock()
 	if p.inStart {
-		log.WithFields(log.Fields{"program": p.GetName()}).Info("Don't start program again, program is already started")
+		if p.state.Load() == Fatal && p.backoff.GetFatalRetry() > 0 {
+			log.WithFields(log.Fields{"program": p.GetName()}).Info("retry the program in fatal state now")
+			p.retryFatalNow.Store(true)
+		} else {
+			log.WithFields(log.Fields{"program": p.GetName()}).Info("Don't start program again, program is already started")
+		}
 		p.lock.Unlock()
 		return
 	}
 
 	p.inStart = true
 	p.stopByUser.Store(false)
+	// the program started by the user or at startup is retried from the
+	// beginning, backoff_reset_secs is only for the automatic restarts
+	p.retryTimes.Store(0)
 	p.lock.Unlock()
 
 	var runCond *sync.Cond
@@ -349,6 +493,13 @@ func (p *Process) Start(wait bool) {
 				log.WithFields(log.Fields{"program": p.GetName()}).Info("program stopped by user, don't start it again")
 				break
 			}
+			if p.state.Load() == Fatal {
+				if !p.waitForFatalRetry() {
+					break
+				}
+				p.retryTimes.Store(0)
+				continue
+			}
 			if !p.isAutoRestart() {
 				log.WithFields(log.Fields{"program": p.GetName()}).Info("Don't start the stopped program because its autorestart flag is false")
 				break
@@ -365,6 +516,45 @@ func (p *Process) Start(wait bool) {
 	}
 }
 
+// wait to retry the program in Fatal state if fatal_retry_minutes is set.
+// Returns false if the program should stay in Fatal state
+func (p *Process) waitForFatalRetry() bool {
+	fatalRetry := p.backoff.GetFatalRetry()
+	if fatalRetry <= 0 {
+		log.WithFields(log.Fields{"program": p.GetName()}).Info("Don't start the program in fatal state again")
+		return false
+	}
+	log.WithFields(log.Fields{"program": p.GetName(), "after": fatalRetry}).Info("retry the program in fatal state later")
+	p.retryFatalNow.Store(false)
+	retryTime := time.Now().Add(fatalRetry)
+	for time.Now().Before(retryTime) && !p.retryFatalNow.Load() {
+		if p.stopByUser.Load() {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	p.retryFatalNow.Store(false)
+	return !p.stopByUser.Load()
+}
+
+// wait for the backoff delay before retrying to start the program.
+// Returns false if the program is stopped by user in the waiting
+func (p *Process) waitForBackoff() bool {
+	delay := p.backoff.GetDelay(p.retryTimes.Load())
+	if delay <= 0 {
+		return true
+	}
+	log.WithFields(log.Fields{"program": p.GetName(), "delay": delay}).Info("don't restart the program, start it after backoff delay")
+	restartTime := time.Now().Add(delay)
+	for time.Now().Before(restartTime) {
+		if p.stopByUser.Load() {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return !p.stopByUser.Load()
+}
+
 // GetName returns name of program or event listener
 func (p *Process) GetName() string {
 	if p.config.IsProgram() {
@@ -445,6 +635,21 @@ func (p *Process) GetStartTime() time.Time {
 	return p.startTime
 }
 
+// GetRestartCount returns the number of times the program is started again
+// after its first start
+func (p *Process) GetRestartCount() int64 {
+	count := p.startCount.Load()
+	if count <= 1 {
+		return 0
+	}
+	return count - 1
+}
+
+// GetStateDurations returns the total time the program spent in each state
+func (p *Process) GetStateDurations() map[State]time.Duration {
+	return p.state.GetDurations()
+}
+
 // GetStopTime returns process stop time
 func (p *Process) GetStopTime() time.Time {
 	p.lock.RLock()
@@ -464,6 +669,9 @@ func (p *Process) GetStopTime() time.Time {
 // GetStdoutLogfile returns program stdout log filename
 func (p *Process) GetStdoutLogfile() string {
 	fileName := p.config.GetStringExpression("stdout_logfile", "AUTO")
+	if isAutoLogFile(fileName) {
+		return p.getAutoLogFile("stdout")
+	}
 	expandFile, err := PathExpand(fileName)
 	if err != nil {
 		return fileName
@@ -474,6 +682,9 @@ func (p *Process) GetStdoutLogfile() string {
 // GetStderrLogfile returns program stderr log filename
 func (p *Process) GetStderrLogfile() string {
 	fileName := p.config.GetStringExpression("stderr_logfile", "AUTO")
+	if isAutoLogFile(fileName) {
+		return p.getAutoLogFile("stderr")
+	}
 	expandFile, err := PathExpand(fileName)
 	if err != nil {
 		return fileName
@@ -485,10 +696,6 @@ func (p *Process) getStartSeconds() int64 {
 	return int64(p.config.GetInt("startsecs", 1))
 }
 
-func (p *Process) getRestartPause() int {
-	return p.config.GetInt("restartpause", 0)
-}
-
 func (p *Process) getStartRetries() int32 {
 	return int32(p.config.GetInt("startretries", 3))
 }
@@ -497,6 +704,34 @@ func (p *Process) isAutoStart() bool {
 	return p.config.GetString("autostart", "true") == "true"
 }
 
+// GetDependsOn returns the programs in the depends_on of this program
+func (p *Process) GetDependsOn() []string {
+	result := make([]string, 0)
+	for _, dependsOn := range strings.Split(p.config.GetString("depends_on", ""), ",") {
+		dependsOn = strings.TrimSpace(dependsOn)
+		if dependsOn != "" {
+			result = append(result, dependsOn)
+		}
+	}
+	return result
+}
+
+// what to do with this program if one of its dependencies goes to Fatal state:
+// hold - don't start it until the dependencies are healthy, this is the default
+// stop - stop it and start it again once the dependencies are healthy
+func (p *Process) getDependsOnFatalAction() string {
+	return strings.ToLower(p.config.GetString("depends_on_fatal_action", "hold"))
+}
+
+// IsHealthy returns true if the process is in Running state and it does
+// not fail its liveness check
+func (p *Process) IsHealthy() bool {
+	if p.state.Load() != Running {
+		return false
+	}
+	return p.livenessChecker == nil || !p.livenessChecker.IsFailed()
+}
+
 // GetPriority returns program priority (as it set in config) with default value of 999
 func (p *Process) GetPriority() int {
 	return p.config.GetInt("priority", 999)
@@ -620,15 +855,79 @@ func (p *Process) createProgramCommand() error {
 		log.WithFields(log.Fields{"user": p.config.GetString("user", "")}).Error("fail to run as user")
 		return fmt.Errorf("fail to set user")
 	}
+	p.rlimits, err = parseRlimits(p.config)
+	if err == nil && len(p.rlimits) > 0 && !rlimitSupported {
+		err = fmt.Errorf("rlimit_* is only supported on linux")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error(err)
+		return err
+	}
+	if rlimitSupported && p.crashCollector != nil && p.config.GetString("rlimit_core", "") == "" {
+		// allow the crashed program to dump the core file
+		p.rlimits = append(p.rlimits, rlimitSetting{name: "core", soft: rlimitUnlimited, hard: rlimitUnlimited})
+	}
 	p.setProgramRestartChangeMonitor(args[0])
-	setDeathsig(p.cmd.SysProcAttr)
+	setDeathsig(p.cmd.SysProcAttr, p.processStore != nil)
+	if err = p.setCgroup(); err != nil {
+		return err
+	}
 	p.setEnv()
 	p.setDir()
 	p.setLog()
 
-	p.stdin, _ = p.cmd.StdinPipe()
-	return nil
+	p.pipes = nil
+	if p.processStore != nil && p.config.IsProgram() {
+		// the pipes can be passed to the new supervisord on upgrade
+		if p.pipes, err = newProcessPipes(p.cmd, p.getOutputFifo("stdout"), p.getOutputFifo("stderr")); err != nil {
+			return err
+		}
+		p.stdin = p.pipes.stdin
+	} else {
+		p.stdin, _ = p.cmd.StdinPipe()
+	}
+	if err = p.setSocket(); err != nil {
+		return err
+	}
+	// the program is executed by the helper after the resource limits are set
+	p.rlimitHelper, err = newRlimitHelper(p.cmd, p.rlimits)
+	return err
+}
 
+// setCgroup makes the program to be spawned into its cgroup if cgroup_parent is configured
+func (p *Process) setCgroup() error {
+	cgroup, err := NewCgroup(p.GetName(), p.GetGroup(), p.config)
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error(err)
+		return err
+	}
+	p.cgroup = cgroup
+	if cgroup == nil {
+		return nil
+	}
+	p.cgroupFile, err = cgroup.attach(p.cmd.SysProcAttr)
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName(), "cgroup": cgroup.GetPath()}).Error("fail to set cgroup: ", err)
+	}
+	return err
+}
+
+// GetCgroup returns the cgroup of the program, nil if cgroup is not configured
+func (p *Process) GetCgroup() *Cgroup {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.cgroup
+}
+
+// killCgroup kills the processes left in the cgroup of the stopped program
+func (p *Process) killCgroup() {
+	cgroup := p.GetCgroup()
+	if cgroup == nil || !cgroup.IsKillOnStop() {
+		return
+	}
+	if err := cgroup.Kill(); err != nil {
+		log.WithFields(log.Fields{"program": p.GetName(), "cgroup": cgroup.GetPath()}).Warn("fail to kill the processes in cgroup: ", err)
+	}
 }
 
 func (p *Process) setProgramRestartChangeMonitor(programPath string) {
@@ -653,7 +952,7 @@ func (p *Process) setProgramRestartChangeMonitor(programPath string) {
 			} else if len(s) > 0 {
 				p.sendSignals(strings.Fields(s), true, stopWaitSecs)
 			} else {
-				p.Stop(true)
+				p.stopBy("file_changed", true)
 				p.Start(true)
 			}
 
@@ -680,7 +979,7 @@ func (p *Process) setProgramRestartChangeMonitor(programPath string) {
 			} else if len(s) > 0 {
 				p.sendSignals(strings.Fields(s), true, stopWaitSecs)
 			} else {
-				p.Stop(true)
+				p.stopBy("file_changed", true)
 				p.Start(true)
 			}
 		})
@@ -690,7 +989,13 @@ func (p *Process) setProgramRestartChangeMonitor(programPath string) {
 
 // wait for the started program exit
 func (p *Process) waitForExit(startSecs int64) {
-	p.cmd.Wait()
+	if p.adopted != nil {
+		p.waitForAdoptedExit(p.adopted)
+	} else {
+		p.cmd.Wait()
+	}
+	p.pipes.wait()
+	p.processStore.RemoveProcess(p.GetName())
 	if p.cmd.ProcessState != nil {
 		log.WithFields(log.Fields{"program": p.GetName()}).Infof("program stopped with status:%v", p.cmd.ProcessState)
 	} else {
@@ -700,6 +1005,12 @@ func (p *Process) waitForExit(startSecs int64) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	p.stopTime = time.Now()
+	// the incomplete last line of stderr is kept in the tail
+	if p.stderrTailLog != nil {
+		p.stderrTailLog.Close()
+	}
+	p.addRunHistory()
+	p.collectCrash()
 
 	// FIXME: we didn't set eventlistener logger
 	// since it's stdout/stderr has been specifically managed.
@@ -712,6 +1023,98 @@ func (p *Process) waitForExit(startSecs int64) {
 
 }
 
+// add the exited run of the program to the run history
+func (p *Process) addRunHistory() {
+	run := types.ProcessRun{Pid: p.cmd.Process.Pid,
+		Start:      int(p.spawnTime.Unix()),
+		Stop:       int(p.stopTime.Unix()),
+		Exitstatus: -1,
+		Stoppedby:  p.stopReason}
+	if p.cmd.ProcessState != nil {
+		run.Exitstatus = p.cmd.ProcessState.ExitCode()
+		if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			run.Signal = status.Signal().String()
+		}
+	}
+	switch run.Stoppedby {
+	case "user", "dependency", "file_changed":
+		run.Expected = true
+	case "":
+		run.Expected = run.Signal == "" && p.inExitCodes(run.Exitstatus)
+	default:
+		// the program is stopped because it is unhealthy
+		run.Expected = false
+	}
+	if p.stderrTail != nil {
+		run.Stderrtail = p.stderrTail.String()
+	}
+	p.history.Add(run)
+}
+
+// collect the crash bundle if the program is crashed and crash_collect is enabled
+func (p *Process) collectCrash() {
+	if p.crashCollector == nil || p.cmd.ProcessState == nil || p.stopReason != "" {
+		return
+	}
+	status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !p.crashCollector.IsCrash(status) {
+		return
+	}
+	info := &CrashInfo{Program: p.GetName(),
+		Group:      p.GetGroup(),
+		Pid:        p.cmd.Process.Pid,
+		Signal:     status.Signal().String(),
+		CoreDumped: status.CoreDump(),
+		Command:    p.config.GetStringExpression("command", ""),
+		Directory:  p.cmd.Dir,
+		Start:      p.spawnTime,
+		Stop:       p.stopTime,
+		// read the log now, the log of next run may be appended soon
+		stdout: p.crashCollector.readLogTail(p.StdoutLog),
+		stderr: p.crashCollector.readLogTail(p.StderrLog)}
+	log.WithFields(log.Fields{"program": p.GetName(), "signal": info.Signal, "coreDumped": info.CoreDumped}).Warn("program crashed")
+	go func() {
+		bundle, err := p.crashCollector.Collect(info)
+		if err != nil {
+			log.WithFields(log.Fields{"program": info.Program}).Error("fail to collect crash bundle: ", err)
+		} else {
+			log.WithFields(log.Fields{"program": info.Program, "bundle": bundle}).Info("crash bundle is collected")
+		}
+		if p.config.IsProgram() {
+			events.EmitEvent(events.CreateProcessCrashEvent(info.Program, info.Group, info.Pid, info.Signal, info.CoreDumped, bundle))
+		}
+	}()
+}
+
+// RotateLogs rotates the stdout and stderr log files of the program now
+func (p *Process) RotateLogs() error {
+	p.lock.RLock()
+	stdoutLog, stderrLog := p.StdoutLog, p.StderrLog
+	p.lock.RUnlock()
+	var err error
+	if stdoutLog != nil {
+		err = logger.RotateLog(stdoutLog)
+	}
+	if stderrLog != nil && stderrLog != stdoutLog {
+		if e := logger.RotateLog(stderrLog); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GetRunHistory returns the past runs of the program, the latest run is the last one
+func (p *Process) GetRunHistory() []types.ProcessRun {
+	return p.history.GetRuns()
+}
+
+// GetSpawnError returns the error of last failed spawn, empty if the program is spawned successfully
+func (p *Process) GetSpawnError() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.spawnErr
+}
+
 // fail to start the program
 func (p *Process) failToStartProgram(reason string, finishCb func()) {
 	log.WithFields(log.Fields{"program": p.GetName()}).Errorf("%s", reason)
@@ -725,17 +1128,42 @@ func (p *Process) monitorProgramIsRunning(endTime time.Time, monitorExited *int3
 	for time.Now().Before(endTime) && atomic.LoadInt32(programExited) == 0 {
 		time.Sleep(time.Duration(100) * time.Millisecond)
 	}
+	ready := p.waitProgramIsReady(programExited)
 	atomic.StoreInt32(monitorExited, 1)
 
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	// if the program does not exit
-	if atomic.LoadInt32(programExited) == 0 && p.state.Load() == Starting {
+	if ready && atomic.LoadInt32(programExited) == 0 && p.state.Load() == Starting {
 		log.WithFields(log.Fields{"program": p.GetName()}).Info("success to start program")
 		p.changeStateTo(Running)
 	}
 }
 
+// wait for the program passing its readiness check. If the program is not
+// ready before the readiness check timeout, it will be killed and the retry
+// logic of run() moves it to Backoff or Fatal state
+func (p *Process) waitProgramIsReady(programExited *int32) bool {
+	if p.readinessChecker == nil || atomic.LoadInt32(programExited) != 0 || p.state.Load() != Starting {
+		return true
+	}
+	log.WithFields(log.Fields{"program": p.GetName()}).Info("wait for program to pass readiness check")
+	ready := p.readinessChecker.WaitReady(func() bool {
+		return atomic.LoadInt32(programExited) != 0 || p.state.Load() != Starting
+	})
+	if ready {
+		return true
+	}
+	if atomic.LoadInt32(programExited) == 0 && p.state.Load() == Starting {
+		log.WithFields(log.Fields{"program": p.GetName(), "timeout": p.readinessChecker.GetTimeout()}).Error("program is not ready before readiness check timeout, kill it")
+		p.lock.Lock()
+		p.stopReason = "readiness_check"
+		p.lock.Unlock()
+		p.sendSignals([]string{"KILL"}, p.config.GetBool("killasgroup", p.config.GetBool("stopasgroup", false)), p.config.GetInt("killwaitsecs", 2))
+	}
+	return false
+}
+
 // 这个函数可能有以下几种执行完成的情况：
 //
 // 1. 程序正在运行中，因此函数直接返回。
@@ -754,10 +1182,13 @@ func (p *Process) run(finishCb func()) {
 		return
 	}
 
+	// keep the retry counter on autorestart if the program exited before it
+	// was stable
+	if p.backoff.ShouldReset(p.runningTime, p.stopTime) {
+		p.retryTimes.Store(0)
+	}
 	p.startTime = time.Now()
-	p.retryTimes.Store(0)
 	startSecs := p.getStartSeconds()
-	restartPause := p.getRestartPause()
 	var once sync.Once
 
 	// finishCb can be only called one time
@@ -767,37 +1198,79 @@ func (p *Process) run(finishCb func()) {
 
 	//process is not expired and not stoped by user
 	for !p.stopByUser.Load() {
-		// if restartPause is set, we will pause before start the program again
-		if restartPause > 0 && p.retryTimes.Load() != 0 {
-			// pause
+		// if backoff (or restartPause) is set, we will pause before start the program again
+		if p.retryTimes.Load() != 0 {
 			p.lock.Unlock()
-			log.WithFields(log.Fields{"program": p.GetName(), "restartPause": restartPause}).Info("don't restart the program, start it after restartPause seconds")
-			time.Sleep(time.Duration(restartPause) * time.Second)
+			ok := p.waitForBackoff()
 			p.lock.Lock()
+			if !ok {
+				log.WithFields(log.Fields{"program": p.GetName()}).Info("program is stopped by user in backoff")
+				p.changeStateTo(Stopped)
+				finishCbWrapper()
+				break
+			}
 		}
 		endTime := time.Now().Add(time.Duration(startSecs) * time.Second)
 		p.changeStateTo(Starting)
 		p.retryTimes.Add(1)
 
-		err := p.createProgramCommand()
-		if err != nil {
-			p.failToStartProgram("fail to create program", finishCbWrapper)
-			break
+		// adopt the process of the previous supervisord instead of spawning
+		p.adopted, p.adoptRecord = p.adoptRecord, nil
+		if p.adopted != nil {
+			if err := p.createAdoptedCommand(p.adopted); err != nil {
+				log.WithFields(log.Fields{"program": p.GetName()}).Error("fail to adopt process: ", err)
+				p.adopted = nil
+			}
 		}
+		if p.adopted == nil {
+			err := p.createProgramCommand()
+			if err != nil {
+				p.spawnErr = fmt.Sprintf("fail to create program: %v", err)
+				p.failToStartProgram("fail to create program", finishCbWrapper)
+				break
+			}
 
-		p.executePreStartHook()
-
-		err = p.cmd.Start()
+			p.executePreStartHook()
 
-		if err != nil {
-			if p.retryTimes.Load() >= p.getStartRetries() {
-				p.failToStartProgram(fmt.Sprintf("fail to start program with error:%v", err), finishCbWrapper)
-				break
+			err = p.cmd.Start()
+			if p.cgroupFile != nil {
+				p.cgroupFile.Close()
+				p.cgroupFile = nil
+			}
+			p.pipes.closeChildFiles()
+			if err == nil {
+				if err = p.rlimitHelper.release(p.cmd.Process.Pid); err != nil {
+					// the helper exits without executing the program
+					_ = p.cmd.Wait()
+					err = fmt.Errorf("fail to set resource limits: %v", err)
+				}
 			} else {
-				log.WithFields(log.Fields{"program": p.GetName()}).Info("fail to start program with error:", err)
-				p.changeStateTo(Backoff)
-				continue
+				p.rlimitHelper.close()
 			}
+
+			if err != nil {
+				p.pipes.wait()
+				p.spawnErr = err.Error()
+				if p.retryTimes.Load() >= p.getStartRetries() {
+					p.failToStartProgram(fmt.Sprintf("fail to start program with error:%v", err), finishCbWrapper)
+					break
+				} else {
+					log.WithFields(log.Fields{"program": p.GetName()}).Info("fail to start program with error:", err)
+					p.changeStateTo(Backoff)
+					continue
+				}
+			}
+		}
+		p.startCount.Add(1)
+		p.spawnTime = time.Now()
+		p.spawnErr = ""
+		p.stopReason = ""
+		if p.adopted != nil {
+			// the uptime of the adopted process starts from its creation
+			p.spawnTime = time.UnixMilli(p.adopted.CreateTime)
+			p.startTime = p.spawnTime
+		} else {
+			p.recordProcess()
 		}
 		if p.StdoutLog != nil {
 			p.StdoutLog.SetPid(p.cmd.Process.Pid)
@@ -831,7 +1304,8 @@ func (p *Process) run(finishCb func()) {
 		programExited := int32(0)
 		// Set startsec to 0 to indicate that the program needn't stay
 		// running for any particular amount of time.
-		if startSecs <= 0 {
+		// the adopted process is already running
+		if p.adopted != nil || (startSecs <= 0 && p.readinessChecker == nil) {
 			atomic.StoreInt32(&monitorExited, 1)
 			log.WithFields(log.Fields{"program": p.GetName()}).Info("success to start program")
 			p.changeStateTo(Running)
@@ -903,6 +1377,9 @@ func (p *Process) run(finishCb func()) {
 func (p *Process) changeStateTo(procState State) {
 
 	state := p.state.Load()
+	if procState == Running {
+		p.runningTime = time.Now()
+	}
 	if p.config.IsProgram() {
 		progName := p.config.GetProgramName()
 		groupName := p.config.GetGroupName()
@@ -925,7 +1402,12 @@ func (p *Process) changeStateTo(procState State) {
 		case Fatal:
 			events.EmitEvent(events.CreateProcessFatalEvent(progName, groupName, state.String()))
 		case Stopped:
-			events.EmitEvent(events.CreateProcessStoppedEvent(progName, groupName, state.String(), p.cmd.Process.Pid))
+			// the program stopped in backoff may have no started process
+			pid := 0
+			if p.cmd != nil && p.cmd.Process != nil {
+				pid = p.cmd.Process.Pid
+			}
+			events.EmitEvent(events.CreateProcessStoppedEvent(progName, groupName, state.String(), pid))
 		case Unknown:
 			events.EmitEvent(events.CreateProcessUnknownEvent(progName, groupName, state.String()))
 		}
@@ -1046,6 +1528,15 @@ func (p *Process) setLog() {
 		}
 
 		p.cmd.Stderr = p.StderrLog
+		// keep the tail of stderr for the run history
+		if tailBytes := p.config.GetBytes("history_stderr_tail_bytes", 2048); tailBytes > 0 {
+			p.stderrTail = newTailBuffer(tailBytes)
+			p.stderrTailLog = p.createStderrTailLogger(p.stderrTail)
+			p.cmd.Stderr = io.MultiWriter(p.stderrTailLog, p.StderrLog)
+		} else {
+			p.stderrTail = nil
+			p.stderrTailLog = nil
+		}
 
 	} else if p.config.IsEventListener() {
 		in, err := p.cmd.StdoutPipe()
@@ -1125,9 +1616,14 @@ func (p *Process) createStdoutLogger() logger.Logger {
 		props["syslog_priority"] = syslog_priority
 	}
 
+	p.setLogRotateProps(props, "stdout")
+	p.setLogSinkProps(props, "stdout")
+
 	log.WithFields(log.Fields{"program": p.GetName(), "logFile": logFile}).Info("create stdout logger")
 
-	return logger.NewLogger(p.GetName(), logFile, logger.NewNullLocker(), maxBytes, backups, props, logEventEmitter)
+	stdoutLogger := logger.NewLogger(p.GetName(), logFile, logger.NewNullLocker(), maxBytes, backups, props, logEventEmitter)
+	stdoutLogger = p.createFormatLogger(stdoutLogger, p.config.GetString("stdout_log_format", ""), "stdout")
+	return p.createStripAnsiLogger(p.createLogFilter(stdoutLogger, "stdout"))
 }
 
 func (p *Process) createStderrLogger() logger.Logger {
@@ -1150,7 +1646,132 @@ func (p *Process) createStderrLogger() logger.Logger {
 		props["syslog_priority"] = syslog_priority
 	}
 
-	return logger.NewLogger(p.GetName(), logFile, logger.NewNullLocker(), maxBytes, backups, props, logEventEmitter)
+	p.setLogRotateProps(props, "stderr")
+	p.setLogSinkProps(props, "stderr")
+
+	stderrLogger := logger.NewLogger(p.GetName(), logFile, logger.NewNullLocker(), maxBytes, backups, props, logEventEmitter)
+	format := p.config.GetString("stderr_log_format", p.config.GetString("stdout_log_format", ""))
+	stderrLogger = p.createFormatLogger(stderrLogger, format, "stderr")
+	return p.createStripAnsiLogger(p.createLogFilter(stderrLogger, "stderr"))
+}
+
+// wrap the logger to remove the ANSI escape sequences from the program
+// output if strip_ansi is set in the supervisord section
+func (p *Process) createStripAnsiLogger(underlineLogger logger.Logger) logger.Logger {
+	if !p.isStripAnsi() {
+		return underlineLogger
+	}
+	return logger.NewStripAnsiLogger(underlineLogger)
+}
+
+// set the log rotation props from the stdout_/stderr_ prefixed options or
+// the options shared by stdout and stderr
+func (p *Process) setLogRotateProps(props map[string]string, stream string) {
+	rotateProps := make(map[string]string)
+	for _, name := range []string{logger.PropRotateInterval, logger.PropBackupName, logger.PropCompress} {
+		if value := strings.TrimSpace(p.config.GetString(stream+"_"+name, p.config.GetString(name, ""))); value != "" {
+			rotateProps[name] = value
+		}
+	}
+	name := logger.PropMaxTotalBytes
+	if maxTotalBytes := p.config.GetBytes(stream+"_"+name, p.config.GetBytes(name, 0)); maxTotalBytes > 0 {
+		rotateProps[name] = strconv.Itoa(maxTotalBytes)
+	}
+	if err := logger.ValidateRotateProps(rotateProps); err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error("invalid rotation options of ", stream, " log: ", err)
+		return
+	}
+	for name, value := range rotateProps {
+		props[name] = value
+	}
+}
+
+// set the log sink props from the stdout_/stderr_ prefixed options or the
+// options shared by stdout and stderr
+func (p *Process) setLogSinkProps(props map[string]string, stream string) {
+	sinkProps := map[string]string{logger.PropLogGroup: p.GetGroup(), logger.PropLogStream: stream}
+	for _, name := range []string{logger.PropSinkBatchSize,
+		logger.PropSinkFlushInterval,
+		logger.PropSinkBufferSize,
+		logger.PropSinkMaxRetries,
+		logger.PropSinkTimeout,
+		logger.PropSinkLabels,
+		logger.PropSinkHeaders} {
+		if value := strings.TrimSpace(p.config.GetString(stream+"_"+name, p.config.GetString(name, ""))); value != "" {
+			sinkProps[name] = value
+		}
+	}
+	if err := logger.ValidateSinkProps(sinkProps); err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error("invalid log sink options of ", stream, " log: ", err)
+		return
+	}
+	for name, value := range sinkProps {
+		props[name] = value
+	}
+}
+
+// get the log processing option from the stdout_/stderr_ prefixed option or
+// the option shared by stdout and stderr
+func (p *Process) getLogFilterOption(stream string, name string) string {
+	return strings.TrimSpace(p.config.GetString(stream+"_"+name, p.config.GetString(name, "")))
+}
+
+// get the patterns of the log processing option, one pattern per line
+func (p *Process) getLogFilterPatterns(stream string, name string) []string {
+	patterns := make([]string, 0)
+	for _, pattern := range strings.Split(p.getLogFilterOption(stream, name), "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// wrap the logger to redact, filter and group the lines of the program
+// output. The output is discarded if the rules are invalid, so the secrets
+// never reach the log files
+func (p *Process) createLogFilter(underlineLogger logger.Logger, stream string) logger.Logger {
+	config := logger.LogFilterConfig{Redact: p.getLogFilterPatterns(stream, "log_redact"),
+		RedactReplacement: p.getLogFilterOption(stream, "log_redact_replacement"),
+		Include:           p.getLogFilterPatterns(stream, "log_include"),
+		Exclude:           p.getLogFilterPatterns(stream, "log_exclude"),
+		MultilineStart:    p.getLogFilterOption(stream, "log_multiline_start"),
+		MultilineMaxLines: p.config.GetInt(stream+"_log_multiline_max_lines", p.config.GetInt("log_multiline_max_lines", 500)),
+		MultilineTimeout:  time.Duration(p.config.GetFloat(stream+"_log_multiline_timeout", p.config.GetFloat("log_multiline_timeout", 1)) * float64(time.Second))}
+	filter, err := logger.NewLogFilter(underlineLogger, config)
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error("invalid log processing rules of ", stream, " log, the output is discarded: ", err)
+		underlineLogger.Close()
+		return logger.NewNullLogger(logger.NewNullLogEventEmitter())
+	}
+	return filter
+}
+
+// create the logger keeping the tail of stderr for the run history. The
+// secrets are redacted like in the stderr log because the history is
+// returned by the rpc
+func (p *Process) createStderrTailLogger(tail *tailBuffer) logger.Logger {
+	config := logger.LogFilterConfig{Redact: p.getLogFilterPatterns("stderr", "log_redact"),
+		RedactReplacement: p.getLogFilterOption("stderr", "log_redact_replacement")}
+	filter, err := logger.NewLogFilter(newTailLogger(tail), config)
+	if err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error("invalid log_redact, the stderr is not kept in the run history: ", err)
+		return logger.NewNullLogger(logger.NewNullLogEventEmitter())
+	}
+	return filter
+}
+
+// wrap the logger to write each line of the program output in the
+// stdout_log_format/stderr_log_format
+func (p *Process) createFormatLogger(underlineLogger logger.Logger, format string, stream string) logger.Logger {
+	format = strings.TrimSpace(format)
+	if !logger.IsValidLogFormat(format) {
+		if format != "" {
+			log.WithFields(log.Fields{"program": p.GetName(), "format": format}).Error("invalid ", stream, "_log_format, the raw output is logged")
+		}
+		return underlineLogger
+	}
+	return logger.NewFormatLogger(underlineLogger, format, p.GetName(), p.GetGroup(), stream)
 }
 
 func (p *Process) setUser() error {
@@ -1253,8 +1874,17 @@ func filterRootEnv(env *[]string) {
 
 // Stop sends signal to process to make it quit
 func (p *Process) Stop(wait bool) {
+	// stop watching the socket if the program is started on connection
+	p.lazyStartGen.Add(1)
+	p.dependsOnGen.Add(1)
+	p.stopBy("user", wait)
+}
+
+// stopBy stops the program and records the reason in the run history
+func (p *Process) stopBy(reason string, wait bool) {
 	p.lock.Lock()
 	p.stopByUser.Store(true)
+	p.stopReason = reason
 
 	p.lock.Unlock()
 	if !p.IsRunning() {
@@ -1290,6 +1920,8 @@ func (p *Process) Stop(wait bool) {
 		} else {
 			log.WithFields(log.Fields{"program": p.GetName()}).Info("program is still running after sending stop signal and kill signal")
 		}
+		// kill the double-forked children which are not in the process group
+		p.killCgroup()
 	}()
 	if wait {
 		for p.IsRunning() {
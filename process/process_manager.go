@@ -14,13 +14,25 @@ import (
 type Manager struct {
 	procs          map[string]*Process
 	eventListeners map[string]*Process
-	lock           sync.Mutex
+	// the desired states of the programs, nil if the states are not persisted
+	stateStore *StateStore
+	// true if the running processes are recorded in the state store and
+	// adopted by the next supervisord
+	adoptProcesses bool
+	// the listening sockets of the programs by socket url
+	sockets map[string]*programSocket
+	// the directory of the AUTO log files, the temporary directory if empty
+	childLogDir string
+	// true if the ANSI escape sequences are removed from the program output
+	stripAnsi bool
+	lock      sync.Mutex
 }
 
 // NewManager creates new Manager object
 func NewManager() *Manager {
 	manager := &Manager{procs: make(map[string]*Process),
 		eventListeners: make(map[string]*Process),
+		sockets:        make(map[string]*programSocket),
 	}
 	manager.startLivenessCheckers()
 	return manager
@@ -39,10 +51,347 @@ func (pm *Manager) CreateProcess(supervisorID string, config *config.Entry) *Pro
 	}
 }
 
-// StartAutoStartPrograms starts all programs that set as should be autostarted
+// SetStateStore sets the store of the desired states of the programs
+func (pm *Manager) SetStateStore(stateStore *StateStore) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.stateStore = stateStore
+}
+
+// GetStateStore gets the store of the desired states of the programs, nil
+// if the states are not persisted
+func (pm *Manager) GetStateStore() *StateStore {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	return pm.stateStore
+}
+
+// SetAdoptProcesses enables or disables the adoption of the processes. If it
+// is enabled, the processes spawned are recorded in the state store and are
+// not killed when supervisord exits
+func (pm *Manager) SetAdoptProcesses(enable bool) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.adoptProcesses = enable
+}
+
+// SetChildLogSettings sets the childlogdir and the strip_ansi of the
+// supervisord section, they take effect when the programs are started again
+func (pm *Manager) SetChildLogSettings(childLogDir string, stripAnsi bool) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.childLogDir = childLogDir
+	pm.stripAnsi = stripAnsi
+}
+
+// AdoptProcesses adopts the processes recorded by the previous supervisord
+// which are still running, so the programs are not spawned again. An adopted
+// program is running regardless of its autostart. The pipes of the processes
+// are inherited only if supervisord is upgraded by the previous one
+func (pm *Manager) AdoptProcesses(upgraded bool) {
+	pm.lock.Lock()
+	stateStore, enabled := pm.stateStore, pm.adoptProcesses
+	pm.lock.Unlock()
+	for name, record := range stateStore.GetProcesses() {
+		if !upgraded {
+			record.Stdin, record.Stdout, record.Stderr = 0, 0, 0
+		}
+		proc := pm.Find(name)
+		if !enabled || proc == nil {
+			log.WithFields(log.Fields{"program": name, "pid": record.Pid}).Warn("don't adopt the recorded process")
+			stateStore.RemoveProcess(name)
+			continue
+		}
+		if err := proc.Adopt(record); err != nil {
+			log.WithFields(log.Fields{"program": name, "pid": record.Pid}).Info("fail to adopt process: ", err)
+			stateStore.RemoveProcess(name)
+		}
+	}
+}
+
+// PrepareUpgrade stops the event listeners and records the running programs
+// with their pipes, so the programs are adopted by the new supervisord after
+// upgrade
+func (pm *Manager) PrepareUpgrade() error {
+	pm.lock.Lock()
+	stateStore, enabled := pm.stateStore, pm.adoptProcesses
+	eventListeners := make([]*Process, 0, len(pm.eventListeners))
+	for _, evtListener := range pm.eventListeners {
+		eventListeners = append(eventListeners, evtListener)
+	}
+	pm.lock.Unlock()
+	if stateStore == nil || !enabled {
+		return fmt.Errorf("the processes can't be adopted without statefile and adopt_processes")
+	}
+	for _, evtListener := range eventListeners {
+		evtListener.Stop(true)
+	}
+	var err error
+	pm.ForEachProcess(func(proc *Process) {
+		if e := proc.prepareUpgrade(); e != nil && err == nil {
+			err = fmt.Errorf("fail to prepare program %s for upgrade: %v", proc.GetName(), e)
+		}
+	})
+	return err
+}
+
+// CancelUpgrade undoes PrepareUpgrade and InheritSockets after the upgrade
+// is failed, the pipes of the programs and the listening sockets are not
+// inherited by the spawned programs
+func (pm *Manager) CancelUpgrade() {
+	pm.ForEachProcess(func(proc *Process) {
+		proc.cancelUpgrade()
+	})
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	for _, socket := range pm.sockets {
+		if f := socket.getFile(); f != nil {
+			_ = SetCloseOnExec(int(f.Fd()), true)
+		}
+	}
+}
+
+// StartAutoStartPrograms starts all programs that set as should be
+// autostarted. The desired state of a program started or stopped by the user
+// takes precedence over its autostart
 func (pm *Manager) StartAutoStartPrograms() {
+	stateStore := pm.GetStateStore()
+	pm.ForEachProcess(func(proc *Process) {
+		autoStart := proc.isAutoStart()
+		if state, ok := stateStore.Get(proc.GetName()); ok && (state.State == DesiredStateStarted) != autoStart {
+			log.WithFields(log.Fields{"program": proc.GetName(),
+				"state":  state.State,
+				"action": state.Action,
+				"time":   state.Time.Format(time.RFC3339)}).Info("the desired state of program overrides autostart")
+			autoStart = state.State == DesiredStateStarted
+		}
+		if autoStart && proc.isLazyStart() {
+			proc.startOnConnection(func() { pm.StartProcess(proc, false) })
+		} else if autoStart {
+			pm.StartProcess(proc, false)
+		}
+	})
+}
+
+// StartProcess starts the process after all the programs in its depends_on
+// are healthy. The start is canceled if the program is stopped by the user
+// while it waits for the dependencies
+//
+// Args:
+//
+//	wait - true, wait the dependencies are healthy and the program started or failed
+func (pm *Manager) StartProcess(proc *Process, wait bool) {
+	if len(proc.GetDependsOn()) == 0 {
+		proc.Start(wait)
+		return
+	}
+	gen := proc.dependsOnGen.Load()
+	start := func() {
+		if pm.WaitForDependencies(proc) && proc.dependsOnGen.Load() == gen {
+			proc.Start(wait)
+		}
+	}
+	if wait {
+		start()
+	} else {
+		go start()
+	}
+}
+
+// StartProcesses starts the processes at the same time, so a process waiting
+// for its dependencies among the processes does not block their start
+//
+// Args:
+//
+//	wait - true, wait all the processes started or failed
+func (pm *Manager) StartProcesses(procs []*Process, wait bool) {
+	var wg sync.WaitGroup
+	for _, proc := range procs {
+		wg.Add(1)
+		go func(proc *Process) {
+			defer wg.Done()
+			pm.StartProcess(proc, wait)
+		}(proc)
+	}
+	if wait {
+		wg.Wait()
+	}
+}
+
+// WaitForDependencies waits until all the programs in depends_on of the process
+// are healthy. Returns false if it is timeout (depends_on_timeout), the process
+// is started or stopped by others in the waiting or a dependency goes to Fatal
+// state and depends_on_fatal_action is stop
+func (pm *Manager) WaitForDependencies(proc *Process) bool {
+	timeout := proc.config.GetInt("depends_on_timeout", 0)
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	gen := proc.dependsOnGen.Load()
+	logged := false
+	for {
+		healthy, fatalDependency := pm.checkDependencies(proc)
+		if healthy {
+			return true
+		}
+		if proc.IsRunning() {
+			return false
+		}
+		if proc.dependsOnGen.Load() != gen {
+			log.WithFields(log.Fields{"program": proc.GetName()}).Info("program is stopped while waiting for dependencies, don't start it")
+			return false
+		}
+		if fatalDependency != "" && proc.getDependsOnFatalAction() == "stop" {
+			log.WithFields(log.Fields{"program": proc.GetName(), "dependency": fatalDependency}).Error("don't start program because its dependency is in fatal state")
+			return false
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			log.WithFields(log.Fields{"program": proc.GetName(), "depends_on": strings.Join(proc.GetDependsOn(), ",")}).Error("timeout to wait for dependencies, don't start program")
+			return false
+		}
+		if !logged {
+			log.WithFields(log.Fields{"program": proc.GetName(), "depends_on": strings.Join(proc.GetDependsOn(), ",")}).Info("wait for dependencies to be healthy")
+			logged = true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// check if all the dependencies of the process are healthy. If not, the
+// name of dependency in Fatal state is also returned
+func (pm *Manager) checkDependencies(proc *Process) (bool, string) {
+	healthy := true
+	fatalDependency := ""
+	for _, dependsOn := range proc.GetDependsOn() {
+		for _, dependency := range pm.findDependency(dependsOn) {
+			if !dependency.IsHealthy() {
+				healthy = false
+				if dependency.GetState() == Fatal {
+					fatalDependency = dependency.GetName()
+				}
+			}
+		}
+	}
+	return healthy, fatalDependency
+}
+
+// find the processes of the depends_on item without logging if it is not found
+func (pm *Manager) findDependency(name string) []*Process {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	result := make([]*Process, 0)
+	if pos := strings.Index(name, ":"); pos != -1 {
+		groupName := name[0:pos]
+		programName := name[pos+1:]
+		for _, proc := range pm.procs {
+			if proc.GetGroup() == groupName && (programName == "*" || programName == proc.GetName()) {
+				result = append(result, proc)
+			}
+		}
+	} else if proc, ok := pm.procs[name]; ok {
+		result = append(result, proc)
+	}
+	return result
+}
+
+// get the processes which depend on the given process
+func (pm *Manager) getDependents(proc *Process) []*Process {
+	result := make([]*Process, 0)
+	pm.ForEachProcess(func(p *Process) {
+		if p == proc {
+			return
+		}
+		for _, dependsOn := range p.GetDependsOn() {
+			if dependsOn == proc.GetName() ||
+				dependsOn == fmt.Sprintf("%s:%s", proc.GetGroup(), proc.GetName()) ||
+				dependsOn == fmt.Sprintf("%s:*", proc.GetGroup()) {
+				result = append(result, p)
+				return
+			}
+		}
+	})
+	return result
+}
+
+// StopProcess stops the process after all the programs depending on it
+// are stopped, so the programs are stopped in reverse dependency order
+//
+// Args:
+//
+//	wait - true, wait the dependents and the program stopped
+func (pm *Manager) StopProcess(proc *Process, wait bool) {
+	pm.stopAfterDependents(proc, pm.getDependents(proc), wait)
+}
+
+// StopProcesses stops the processes at the same time in reverse dependency
+// order, a process is stopped after the processes depending on it among the
+// processes are stopped. The dependents not in the processes keep running
+// and are not waited for
+//
+// Args:
+//
+//	wait - true, wait all the processes stopped
+func (pm *Manager) StopProcesses(procs []*Process, wait bool) {
+	stopping := make(map[*Process]bool)
+	for _, proc := range procs {
+		stopping[proc] = true
+	}
+	var wg sync.WaitGroup
+	for _, proc := range procs {
+		dependents := make([]*Process, 0)
+		for _, dependent := range pm.getDependents(proc) {
+			if stopping[dependent] {
+				dependents = append(dependents, dependent)
+			}
+		}
+		wg.Add(1)
+		go func(proc *Process, dependents []*Process) {
+			defer wg.Done()
+			pm.stopAfterDependents(proc, dependents, true)
+		}(proc, dependents)
+	}
+	if wait {
+		wg.Wait()
+	}
+}
+
+// stop the process after the dependents are stopped
+func (pm *Manager) stopAfterDependents(proc *Process, dependents []*Process, wait bool) {
+	if len(dependents) == 0 {
+		proc.Stop(wait)
+		return
+	}
+	// cancel the start waiting for the dependencies before the dependents
+	// are stopped
+	proc.dependsOnGen.Add(1)
+	stop := func() {
+		for _, dependent := range dependents {
+			for dependent.IsRunning() {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		proc.Stop(true)
+	}
+	if wait {
+		stop()
+	} else {
+		go stop()
+	}
+}
+
+// stop or start again the programs whose depends_on_fatal_action is stop
+// according to their dependencies state
+func (pm *Manager) checkDependencyFailures() {
 	pm.ForEachProcess(func(proc *Process) {
-		if proc.isAutoStart() {
+		if len(proc.GetDependsOn()) == 0 || proc.getDependsOnFatalAction() != "stop" {
+			return
+		}
+		healthy, fatalDependency := pm.checkDependencies(proc)
+		if fatalDependency != "" && proc.IsRunning() && proc.GetState() != Stopping {
+			log.WithFields(log.Fields{"program": proc.GetName(), "dependency": fatalDependency}).Info("stop program because its dependency is in fatal state")
+			proc.stoppedByDependency.Store(true)
+			go proc.stopBy("dependency", false)
+		} else if healthy && !proc.IsRunning() && proc.stoppedByDependency.CompareAndSwap(true, false) {
+			log.WithFields(log.Fields{"program": proc.GetName()}).Info("start program again because its dependencies are healthy")
 			proc.Start(false)
 		}
 	})
@@ -57,10 +406,102 @@ func (pm *Manager) createProgram(supervisorID string, config *config.Entry) *Pro
 		proc = NewProcess(supervisorID, config)
 		pm.procs[procName] = proc
 	}
+	socket := pm.getSocket(config.GetString("socket", ""), config.GetString("socket_mode", ""))
+	proc.lock.Lock()
+	if pm.adoptProcesses {
+		proc.processStore = pm.stateStore
+	} else {
+		proc.processStore = nil
+	}
+	proc.socket = socket
+	proc.lock.Unlock()
+	proc.setChildLogSettings(pm.childLogDir, pm.stripAnsi)
 	log.Info("create process:", procName)
 	return proc
 }
 
+// getSocket gets the listening socket with the socket url, the socket is
+// created and starts listening if it is not used by other programs. Returns
+// nil if the socket url is empty or invalid
+func (pm *Manager) getSocket(url string, mode string) *programSocket {
+	if url == "" {
+		return nil
+	}
+	if socket, ok := pm.sockets[url]; ok {
+		return socket
+	}
+	socket, err := newProgramSocket(url, mode)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	// listen again when the program is spawned if it is failed now
+	if _, err = socket.File(); err != nil {
+		log.Error(err)
+	}
+	pm.sockets[url] = socket
+	return socket
+}
+
+// CloseUnusedSockets closes the listening sockets not used by any program
+// after the configuration is reloaded
+func (pm *Manager) CloseUnusedSockets() {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	used := make(map[*programSocket]bool)
+	for _, proc := range pm.procs {
+		proc.lock.RLock()
+		used[proc.socket] = true
+		proc.lock.RUnlock()
+	}
+	for url, socket := range pm.sockets {
+		if !used[socket] {
+			log.WithFields(log.Fields{"socket": url}).Info("close the unused socket")
+			socket.Close()
+			delete(pm.sockets, url)
+		}
+	}
+}
+
+// InheritSockets makes the listening sockets of the programs to be inherited
+// by the new supervisord on upgrade. Returns the file descriptors of the
+// sockets by socket url
+func (pm *Manager) InheritSockets() (map[string]int, error) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	fds := make(map[string]int)
+	for url, socket := range pm.sockets {
+		f := socket.getFile()
+		if f == nil {
+			continue
+		}
+		fd := int(f.Fd())
+		if err := SetCloseOnExec(fd, false); err != nil {
+			return nil, err
+		}
+		fds[url] = fd
+	}
+	return fds, nil
+}
+
+// SetInheritedSockets sets the listening sockets passed by the previous
+// supervisord on upgrade, so the programs keep using them
+func (pm *Manager) SetInheritedSockets(fds map[string]int) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	for url, fd := range fds {
+		socket, err := newProgramSocket(url, "")
+		if err == nil {
+			socket.file, err = inheritFile(fd, url)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"socket": url}).Error("invalid inherited socket: ", err)
+			continue
+		}
+		pm.sockets[url] = socket
+	}
+}
+
 func (pm *Manager) createEventListener(supervisorID string, config *config.Entry) *Process {
 	eventListenerName := config.GetEventListenerName()
 
@@ -70,6 +511,7 @@ func (pm *Manager) createEventListener(supervisorID string, config *config.Entry
 		evtListener = NewProcess(supervisorID, config)
 		pm.eventListeners[eventListenerName] = evtListener
 	}
+	evtListener.setChildLogSettings(pm.childLogDir, pm.stripAnsi)
 	log.Info("create event listener:", eventListenerName)
 	return evtListener
 }
@@ -202,7 +644,7 @@ func (pm *Manager) StopAllProcesses() {
 		go func(wg *sync.WaitGroup) {
 			defer wg.Done()
 
-			proc.Stop(true)
+			pm.StopProcess(proc, true)
 		}(&wg)
 	})
 
@@ -232,7 +674,9 @@ func (pm *Manager) startLivenessCheckers() {
 		for {
 			pm.ForEachProcess(func(proc *Process) {
 				proc.DoLivenessCheck()
+				proc.DoResourceCheck()
 			})
+			pm.checkDependencyFailures()
 			time.Sleep(2 * time.Second)
 		}
 	}()
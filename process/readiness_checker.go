@@ -0,0 +1,140 @@
+package process
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadinessChecker checks if a started program is ready to serve. A program
+// with readiness_check configured is changed from Starting to Running only
+// after the check passes.
+//
+// The readiness_check can be one of:
+//
+//	tcp://host:port - the port accepts connection (and sends readiness_check_includes if set)
+//	http(s)://host:port/path - a 2xx response is received
+//	any other value - a local script which exits with code 0
+type ReadinessChecker struct {
+	programName string
+	check       string
+	includes    []string
+	timeout     int
+	period      time.Duration
+}
+
+// NewReadinessChecker creates ReadinessChecker from program configuration,
+// returns nil if no readiness_check is configured
+func NewReadinessChecker(programName string, config *config.Entry) *ReadinessChecker {
+	check := strings.TrimSpace(config.GetStringExpression("readiness_check", ""))
+	if check == "" {
+		return nil
+	}
+	includes := make([]string, 0)
+	for _, include := range strings.Split(config.GetString("readiness_check_includes", ""), ",") {
+		include = strings.TrimSpace(include)
+		if include != "" {
+			includes = append(includes, include)
+		}
+	}
+	return &ReadinessChecker{
+		programName: programName,
+		check:       check,
+		includes:    includes,
+		timeout:     config.GetInt("readiness_check_timeout", 30),
+		period:      time.Duration(config.GetInt("readiness_check_period", 1)) * time.Second,
+	}
+}
+
+// GetTimeout returns the readiness check timeout in seconds
+func (rc *ReadinessChecker) GetTimeout() int {
+	return rc.timeout
+}
+
+func (rc *ReadinessChecker) createContentChecker(timeout int) ContentChecker {
+	if strings.HasPrefix(rc.check, "http://") || strings.HasPrefix(rc.check, "https://") {
+		return NewHTTPChecker(rc.check, timeout)
+	}
+	if strings.HasPrefix(rc.check, "tcp://") {
+		u, err := url.Parse(rc.check)
+		if err != nil {
+			log.WithFields(log.Fields{"program": rc.programName, "readiness_check": rc.check}).Error("invalid tcp readiness check: ", err)
+			return nil
+		}
+		host, portStr, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			log.WithFields(log.Fields{"program": rc.programName, "readiness_check": rc.check}).Error("invalid tcp readiness check: ", err)
+			return nil
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			log.WithFields(log.Fields{"program": rc.programName, "readiness_check": rc.check}).Error("invalid tcp readiness check port: ", err)
+			return nil
+		}
+		return NewTCPChecker(host, port, rc.includes, timeout)
+	}
+	args, err := parseCommand(rc.check)
+	if err != nil {
+		log.WithFields(log.Fields{"program": rc.programName, "readiness_check": rc.check}).Error("invalid readiness check script: ", err)
+		return nil
+	}
+	return NewScriptChecker(args, timeout)
+}
+
+// WaitReady blocks until the readiness check passes or the readiness timeout
+// expires, even if a check is still running. The wait is also aborted if the
+// aborted function returns true.
+//
+// Returns true if the program is ready
+func (rc *ReadinessChecker) WaitReady(aborted func() bool) bool {
+	deadline := time.Now().Add(time.Duration(rc.timeout) * time.Second)
+	deadlineTimer := time.NewTimer(time.Until(deadline))
+	defer deadlineTimer.Stop()
+	for {
+		remaining := int(time.Until(deadline).Seconds())
+		if remaining <= 0 {
+			remaining = 1
+		}
+		checker := rc.createContentChecker(remaining)
+		if checker == nil {
+			return false
+		}
+		result := make(chan bool, 1)
+		go func() {
+			result <- checker.Check()
+		}()
+
+		ready := false
+	WAIT:
+		for {
+			select {
+			case ready = <-result:
+				break WAIT
+			case <-deadlineTimer.C:
+				log.WithFields(log.Fields{"program": rc.programName}).Warn("readiness check is still running when the timeout expires")
+				return false
+			case <-time.After(100 * time.Millisecond):
+				if aborted() {
+					return false
+				}
+			}
+		}
+
+		if ready {
+			return true
+		}
+		if !time.Now().Add(rc.period).Before(deadline) {
+			return false
+		}
+		log.WithFields(log.Fields{"program": rc.programName}).Debug("program is not ready, check again")
+		time.Sleep(rc.period)
+		if aborted() {
+			return false
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScriptCheckerKilledOnTimeout(t *testing.T) {
+	start := time.Now()
+	checker := NewScriptChecker([]string{"/bin/sleep", "10"}, 1)
+	if checker.Check() {
+		t.Error("expected false when the script does not exit before the timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Check() took %v, should return within ~1s of timeout", elapsed)
+	}
+}
+
+func TestWaitReadyTimeoutWithRunningCheck(t *testing.T) {
+	rc := &ReadinessChecker{programName: "test", check: "/bin/sleep 10", timeout: 1, period: time.Second}
+	start := time.Now()
+	if rc.WaitReady(func() bool { return false }) {
+		t.Error("expected the program is not ready")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("WaitReady() took %v, should return within ~1s of timeout", elapsed)
+	}
+}
+
+func TestWaitReadyScriptSucceeds(t *testing.T) {
+	rc := &ReadinessChecker{programName: "test", check: "/bin/true", timeout: 5, period: time.Second}
+	if !rc.WaitReady(func() bool { return false }) {
+		t.Error("expected the program is ready")
+	}
+}
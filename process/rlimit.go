@@ -0,0 +1,154 @@
+package process
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+// the resource limits can be set on a program with rlimit_<name> options
+var rlimitNames = []string{"nofile", "nproc", "core", "as", "cpu"}
+
+// rlimitUnlimited the value of the unlimited resource limit
+const rlimitUnlimited = math.MaxUint64
+
+// the argument of supervisord started as the helper executing the program
+// with the resource limits
+const rlimitHelperArg = "--rlimit-helper"
+
+// the message sent to the helper after the resource limits are set on it,
+// followed by the soft and hard limit of rlimit_nofile if it is set
+const rlimitHelperExec = "exec"
+
+// rlimitSetting the soft and hard limit of a resource
+type rlimitSetting struct {
+	name string
+	soft uint64
+	hard uint64
+}
+
+// parseRlimits gets the rlimit_* options of the program. The value of the
+// option is in format "soft[:hard]", the hard limit is same as the soft
+// limit if it is not set. The limit can be "unlimited" or a number with
+// optional KB, MB or GB suffix
+func parseRlimits(config *config.Entry) ([]rlimitSetting, error) {
+	settings := make([]rlimitSetting, 0)
+	for _, name := range rlimitNames {
+		value := strings.TrimSpace(config.GetString("rlimit_"+name, ""))
+		if value == "" {
+			continue
+		}
+		fields := strings.SplitN(value, ":", 2)
+		soft, err := parseRlimitValue(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rlimit_%s %s: %v", name, value, err)
+		}
+		hard := soft
+		if len(fields) == 2 {
+			if hard, err = parseRlimitValue(fields[1]); err != nil {
+				return nil, fmt.Errorf("invalid rlimit_%s %s: %v", name, value, err)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid rlimit_%s %s: soft limit is greater than hard limit", name, value)
+		}
+		settings = append(settings, rlimitSetting{name: name, soft: soft, hard: hard})
+	}
+	return settings, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "unlimited" || s == "infinity" {
+		return rlimitUnlimited, nil
+	}
+	factor := uint64(1)
+	for suffix, f := range map[string]uint64{"KB": 1024, "MB": 1024 * 1024, "GB": 1024 * 1024 * 1024} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			factor = f
+			break
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * factor, nil
+}
+
+// rlimitHelper the supervisord started by itself to spawn the program with
+// the resource limits. The helper waits until the limits are set on it and
+// then executes the program in place, so the limits are set before the
+// program is executed and the hard limits can be raised for the program run
+// as another user
+type rlimitHelper struct {
+	settings []rlimitSetting
+	// the read end of the pipe is used by the helper, the write end releases it
+	r *os.File
+	w *os.File
+}
+
+// newRlimitHelper makes the command to spawn the helper executing the
+// program after the resource limits are set. Returns nil if no resource
+// limit is set
+func newRlimitHelper(cmd *exec.Cmd, settings []rlimitSetting) (*rlimitHelper, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("fail to find supervisord to set the resource limits: %v", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	cmd.Args = append([]string{self, rlimitHelperArg, strconv.Itoa(fd), cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return &rlimitHelper{settings: settings, r: r, w: w}, nil
+}
+
+// release sets the resource limits on the started helper and lets it
+// execute the program. The helper exits without executing the program if the
+// limits can't be set
+func (h *rlimitHelper) release(pid int) error {
+	if h == nil {
+		return nil
+	}
+	h.r.Close()
+	defer h.w.Close()
+	if err := setRlimits(pid, h.settings); err != nil {
+		return err
+	}
+	message := rlimitHelperExec
+	for _, setting := range h.settings {
+		if setting.name == "nofile" {
+			message = fmt.Sprintf("%s %d:%d", message, setting.soft, setting.hard)
+		}
+	}
+	_, err := h.w.Write([]byte(message))
+	return err
+}
+
+// close closes the pipe if the helper fails to start
+func (h *rlimitHelper) close() {
+	if h == nil {
+		return
+	}
+	h.r.Close()
+	h.w.Close()
+}
+
+// isRlimitHelper checks if the command line is the helper executing the
+// program after the resource limits are set
+func isRlimitHelper(cmdline []string) bool {
+	return len(cmdline) >= 2 && cmdline[1] == rlimitHelperArg
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// the resource limits of the programs are supported
+const rlimitSupported = true
+
+var rlimitResources = map[string]int{
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"core":   unix.RLIMIT_CORE,
+	"as":     unix.RLIMIT_AS,
+	"cpu":    unix.RLIMIT_CPU,
+}
+
+// setRlimits sets the resource limits of the started helper
+func setRlimits(pid int, settings []rlimitSetting) error {
+	for _, setting := range settings {
+		limit := unix.Rlimit{Cur: setting.soft, Max: setting.hard}
+		if err := unix.Prlimit(pid, rlimitResources[setting.name], &limit, nil); err != nil {
+			return fmt.Errorf("fail to set rlimit_%s: %v", setting.name, err)
+		}
+	}
+	return nil
+}
+
+// RunRlimitHelper executes the program if supervisord is started as the
+// helper setting the resource limits of the program, otherwise it returns at
+// once. It must be called at the beginning of main
+func RunRlimitHelper() {
+	if len(os.Args) < 5 || os.Args[1] != rlimitHelperArg {
+		return
+	}
+	fd, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid file descriptor %s\n", os.Args[2])
+		os.Exit(127)
+	}
+	f := os.NewFile(uintptr(fd), "rlimit-helper")
+	message, _ := io.ReadAll(f)
+	f.Close()
+	fields := strings.Fields(string(message))
+	if len(fields) == 0 || fields[0] != rlimitHelperExec {
+		// supervisord fails to set the resource limits
+		os.Exit(127)
+	}
+	if len(fields) > 1 {
+		// the exec restores the RLIMIT_NOFILE got by the go runtime when the
+		// helper is started, unless it is set by the helper itself
+		var limit syscall.Rlimit
+		if _, err = fmt.Sscanf(fields[1], "%d:%d", &limit.Cur, &limit.Max); err == nil {
+			err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fail to set rlimit_nofile: %v\n", err)
+			os.Exit(127)
+		}
+	}
+	err = syscall.Exec(os.Args[3], os.Args[4:], os.Environ())
+	fmt.Fprintf(os.Stderr, "fail to execute %s: %v\n", os.Args[3], err)
+	os.Exit(127)
+}
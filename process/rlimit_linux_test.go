@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is started as the helper of the programs with rlimit_*
+	RunRlimitHelper()
+	os.Exit(m.Run())
+}
+
+func TestRlimitsAreSetBeforeExec(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "supervisord.conf")
+	outFile := filepath.Join(dir, "limited.log")
+	if err := os.WriteFile(fileName, []byte("[program:limited]\ncommand=/bin/sh -c 'echo $(ulimit -n) $(ulimit -Hn) $(ulimit -c); exec sleep 10'\n"+
+		"rlimit_nofile=100:200\nrlimit_core=0\nstartsecs=0\nstdout_logfile="+outFile+"\nstderr_logfile=/dev/null\n"+
+		"[program:invalid]\ncommand=/bin/sleep 10\nrlimit_nofile=1:99999999999\nstartretries=0\nstdout_logfile=/dev/null\nstderr_logfile=/dev/null\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess("supervisord", cfg.GetProgram("limited"))
+	proc.Start(true)
+	defer proc.Stop(true)
+	var output []byte
+	for i := 0; i < 50 && len(output) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		output, _ = os.ReadFile(outFile)
+	}
+	if strings.TrimSpace(string(output)) != "100 200 0" {
+		t.Errorf("expected the program is executed with the resource limits, got %q", output)
+	}
+
+	invalid := NewProcess("supervisord", cfg.GetProgram("invalid"))
+	invalid.Start(true)
+	defer invalid.Stop(true)
+	if invalid.GetState() != Fatal || !strings.Contains(invalid.spawnErr, "rlimit_nofile") {
+		t.Errorf("expected the program fails to start if its resource limits can't be set, got %v %q", invalid.GetState(), invalid.spawnErr)
+	}
+}
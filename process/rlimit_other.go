@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"fmt"
+)
+
+// the resource limits of the programs are only supported on linux
+const rlimitSupported = false
+
+// setRlimits returns error because the resource limits are not supported
+func setRlimits(pid int, settings []rlimitSetting) error {
+	if len(settings) > 0 {
+		return fmt.Errorf("rlimit_* is only supported on linux")
+	}
+	return nil
+}
+
+// RunRlimitHelper returns at once because supervisord is never started as
+// the helper setting the resource limits
+func RunRlimitHelper() {
+}
@@ -0,0 +1,104 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RollingRestart restarts the processes batchSize at a time in the order of
+// their priority and process_num. A batch is restarted only after all the
+// processes of the previous batch are in Running state, which means they
+// passed their readiness check if it is configured. The rolling restart is
+// aborted if a process of the batch fails to start. Like restart, the
+// processes which are not running are skipped.
+//
+// Returns the names of the processes restarted successfully
+func (pm *Manager) RollingRestart(procs []*Process, batchSize int) ([]string, error) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	running := make([]*Process, 0, len(procs))
+	for _, proc := range procs {
+		if proc.IsRunning() {
+			running = append(running, proc)
+		} else {
+			log.WithFields(log.Fields{"program": proc.GetName()}).Info("skip the program not running in rolling restart")
+		}
+	}
+	procs = sortRollingRestart(running)
+	restarted := make([]string, 0, len(procs))
+	for start := 0; start < len(procs); start += batchSize {
+		batch := procs[start:min(start+batchSize, len(procs))]
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, proc := range batch {
+			wg.Add(1)
+			go func(i int, proc *Process) {
+				defer wg.Done()
+				errs[i] = pm.restartProcess(proc)
+			}(i, proc)
+		}
+		wg.Wait()
+		failed := make([]string, 0)
+		for i, proc := range batch {
+			if errs[i] != nil {
+				log.WithFields(log.Fields{"program": proc.GetName()}).Error("fail to restart program in rolling restart: ", errs[i])
+				failed = append(failed, fmt.Sprintf("%s (%v)", proc.GetName(), errs[i]))
+			} else {
+				restarted = append(restarted, proc.GetName())
+			}
+		}
+		if len(failed) > 0 {
+			return restarted, fmt.Errorf("rolling restart is aborted after %d of %d programs are restarted [%s], fail to restart %s", len(restarted), len(procs), strings.Join(restarted, ", "), strings.Join(failed, ", "))
+		}
+	}
+	return restarted, nil
+}
+
+// sortRollingRestart sorts the processes by priority, process_num and name
+func sortRollingRestart(procs []*Process) []*Process {
+	result := append([]*Process(nil), procs...)
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].GetPriority() != result[j].GetPriority() {
+			return result[i].GetPriority() < result[j].GetPriority()
+		}
+		numI, numJ := result[i].config.GetInt("process_num", 0), result[j].config.GetInt("process_num", 0)
+		if numI != numJ {
+			return numI < numJ
+		}
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result
+}
+
+// restartProcess stops the process and starts it again, it returns after the
+// process is in Running state or fails to start
+func (pm *Manager) restartProcess(proc *Process) error {
+	log.WithFields(log.Fields{"program": proc.GetName()}).Info("rolling restart program")
+	proc.Stop(true)
+	proc.waitForStartLoopExit()
+	pm.StartProcess(proc, true)
+	if state := proc.GetState(); state != Running {
+		return fmt.Errorf("program is in %s state", state)
+	}
+	return nil
+}
+
+// waitForStartLoopExit waits until the start loop of the stopped process
+// exits, so the process can be started again
+func (p *Process) waitForStartLoopExit() {
+	for {
+		p.lock.RLock()
+		inStart := p.inStart
+		p.lock.RUnlock()
+		if !inStart {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestSortRollingRestart(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=/bin/ls\nprocess_name=web_%(process_num)d\nnumprocs=11\n"+
+		"[program:db]\ncommand=/bin/ls\npriority=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	procs := make([]*Process, 0)
+	for _, entry := range cfg.GetPrograms() {
+		procs = append(procs, NewProcess("supervisord", entry))
+	}
+	expected := []string{"db", "web_1", "web_2", "web_3", "web_4", "web_5", "web_6", "web_7", "web_8", "web_9", "web_10", "web_11"}
+	sorted := sortRollingRestart(procs)
+	if len(sorted) != len(expected) {
+		t.Fatalf("expected %d processes, got %d", len(expected), len(sorted))
+	}
+	for i, proc := range sorted {
+		if proc.GetName() != expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, proc.GetName())
+		}
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=sleep 100\nprocess_name=web_%(process_num)d\nnumprocs=2\nstartsecs=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	procs := make([]*Process, 0)
+	for _, entry := range cfg.GetPrograms() {
+		procs = append(procs, NewProcess("supervisord", entry))
+	}
+	pm := NewManager()
+	pids := make(map[string]int)
+	for _, proc := range procs {
+		proc.Start(true)
+		pids[proc.GetName()] = proc.GetPid()
+		defer proc.Stop(true)
+	}
+	restarted, err := pm.RollingRestart(procs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted) != 2 || restarted[0] != "web_1" || restarted[1] != "web_2" {
+		t.Errorf("expected web_1 and web_2 restarted in order, got %v", restarted)
+	}
+	for _, proc := range procs {
+		if proc.GetState() != Running || proc.GetPid() == pids[proc.GetName()] {
+			t.Errorf("expected %s is running with new pid, got %s pid %d", proc.GetName(), proc.GetState(), proc.GetPid())
+		}
+	}
+}
+
+func TestRollingRestartAbortsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	// the program fails to start again after its fail file is created
+	script := filepath.Join(dir, "web.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ -e "+dir+"/fail$1 ] && exit 1\nexec sleep 100\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand="+script+" %(process_num)d\nprocess_name=web_%(process_num)d\nnumprocs=3\nstartsecs=1\nstartretries=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	procs := make([]*Process, 0)
+	for _, entry := range cfg.GetPrograms() {
+		procs = append(procs, NewProcess("supervisord", entry))
+	}
+	for _, proc := range procs {
+		proc.Start(true)
+		defer proc.Stop(true)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fail2"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewManager().RollingRestart(procs, 1)
+	if err == nil {
+		t.Fatal("expected the rolling restart is aborted")
+	}
+	if len(restarted) != 1 || restarted[0] != "web_1" || !strings.Contains(err.Error(), "[web_1]") {
+		t.Errorf("expected only web_1 is restarted and reported, got %v: %v", restarted, err)
+	}
+	if web3 := findTestProcess(procs, "web_3"); web3.GetState() != Running || web3.GetRestartCount() != 0 {
+		t.Errorf("expected web_3 is not restarted, got %s", web3.GetState())
+	}
+}
+
+func TestRollingRestartSkipsNotRunning(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:web]\ncommand=sleep 100\nprocess_name=web_%(process_num)d\nnumprocs=2\nstartsecs=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	procs := make([]*Process, 0)
+	for _, entry := range cfg.GetPrograms() {
+		procs = append(procs, NewProcess("supervisord", entry))
+	}
+	web1 := findTestProcess(procs, "web_1")
+	web1.Start(true)
+	defer web1.Stop(true)
+	restarted, err := NewManager().RollingRestart(procs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted) != 1 || restarted[0] != "web_1" {
+		t.Errorf("expected only the running web_1 is restarted, got %v", restarted)
+	}
+	if web2 := findTestProcess(procs, "web_2"); web2.GetState() != Stopped {
+		t.Errorf("expected web_2 is not started, got %s", web2.GetState())
+	}
+}
+
+func findTestProcess(procs []*Process, name string) *Process {
+	for _, proc := range procs {
+		if proc.GetName() == name {
+			return proc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package process
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the shell script executing the program with LISTEN_PID set to its own pid,
+// the pid of the program is not known before it is spawned
+const listenPidScript = `LISTEN_PID=$$; export LISTEN_PID; exec "$0" "$@"`
+
+// programSocket the listening socket owned by supervisord and passed to the
+// program. The socket is kept open across the restarts of the program and
+// is shared by all the processes of the program, so no connection is refused
+// while the program is restarted
+type programSocket struct {
+	url     string
+	network string
+	address string
+	// the permission of the unix domain socket file, 0 if not set
+	mode os.FileMode
+	lock sync.Mutex
+	// the listening socket, nil if it is not listening yet
+	file *os.File
+}
+
+// parseSocketURL parses the socket in format tcp://host:port or
+// unix:///path/to/socket
+func parseSocketURL(url string) (network string, address string, err error) {
+	pos := strings.Index(url, "://")
+	if pos == -1 {
+		return "", "", fmt.Errorf("invalid socket %s", url)
+	}
+	network, address = url[0:pos], url[pos+3:]
+	if (network != "tcp" && network != "unix") || address == "" {
+		return "", "", fmt.Errorf("invalid socket %s, it should be tcp://host:port or unix:///path", url)
+	}
+	return network, address, nil
+}
+
+// newProgramSocket creates the socket with the socket url and the permission
+// of the unix domain socket file in octal
+func newProgramSocket(url string, mode string) (*programSocket, error) {
+	network, address, err := parseSocketURL(url)
+	if err != nil {
+		return nil, err
+	}
+	socket := &programSocket{url: url, network: network, address: address}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket_mode %s", mode)
+		}
+		socket.mode = os.FileMode(m)
+	}
+	return socket, nil
+}
+
+// File gets the listening socket, it starts listening if the socket is not
+// listening yet
+func (s *programSocket) File() (*os.File, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		if err := s.listen(); err != nil {
+			return nil, fmt.Errorf("fail to listen on socket %s: %v", s.url, err)
+		}
+	}
+	return s.file, nil
+}
+
+func (s *programSocket) listen() error {
+	if s.network == "unix" {
+		// remove the socket file left by the previous supervisord
+		if fi, err := os.Stat(s.address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(s.address)
+		}
+	}
+	listener, err := net.Listen(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	// the duplicated socket keeps listening after the listener is closed
+	defer listener.Close()
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+		if s.mode != 0 {
+			if err = os.Chmod(s.address, s.mode); err != nil {
+				return err
+			}
+		}
+	}
+	fileListener, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("can't get the socket of %s listener", s.network)
+	}
+	s.file, err = fileListener.File()
+	return err
+}
+
+// Close closes the listening socket and removes the unix domain socket file
+func (s *programSocket) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	s.file = nil
+	if s.network == "unix" {
+		os.Remove(s.address)
+	}
+}
+
+// getFile gets the listening socket without starting listening, nil if the
+// socket is not listening
+func (s *programSocket) getFile() *os.File {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file
+}
+
+// isListenPidWrapper checks if the command line is the shell setting
+// LISTEN_PID before the program is executed
+func isListenPidWrapper(cmdline []string) bool {
+	return len(cmdline) >= 3 && cmdline[1] == "-c" && cmdline[2] == listenPidScript
+}
+
+// setSocket passes the listening socket to the program to be spawned. The
+// socket is passed as file descriptor socket_fd, by default 3 with
+// LISTEN_FDS and LISTEN_PID set like systemd socket activation, or 0 as the
+// stdin of the program like the fcgi-program of supervisor
+func (p *Process) setSocket() error {
+	if p.socket == nil {
+		return nil
+	}
+	f, err := p.socket.File()
+	if err != nil {
+		return err
+	}
+	fd := p.config.GetInt("socket_fd", 3)
+	if fd != 0 && fd < 3 {
+		return fmt.Errorf("invalid socket_fd %d, it should be 0 or not less than 3", fd)
+	}
+	if err = passSocket(p.cmd, f, fd, p.config.GetProgramName()); err != nil {
+		return err
+	}
+	if fd == 0 {
+		p.stdin = nil
+	}
+	return nil
+}
+
+// startOnConnection starts the program when a connection to its socket is
+// pending and the program is not running. The socket is watched until the
+// program is stopped by the user, so the program exited by itself is started
+// again on next connection
+func (p *Process) startOnConnection(start func()) {
+	socket := p.socket
+	if socket == nil {
+		start()
+		return
+	}
+	if _, err := socket.File(); err != nil {
+		log.WithFields(log.Fields{"program": p.GetName()}).Error(err)
+		return
+	}
+	gen := p.lazyStartGen.Add(1)
+	log.WithFields(log.Fields{"program": p.GetName(), "socket": socket.url}).Info("start program on first connection")
+	go func() {
+		for p.lazyStartGen.Load() == gen {
+			f := socket.getFile()
+			if f == nil {
+				return
+			}
+			pending, err := waitConnection(f, time.Second)
+			if err != nil {
+				log.WithFields(log.Fields{"program": p.GetName(), "socket": socket.url}).Error("fail to wait for connection: ", err)
+				return
+			}
+			if !pending {
+				continue
+			}
+			if p.isStartable() && p.lazyStartGen.Load() == gen {
+				log.WithFields(log.Fields{"program": p.GetName()}).Info("start program because a connection is pending")
+				start()
+			}
+			// the pending connection is accepted by the program
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+}
+
+// isStartable checks if the program is not running and is not being started
+func (p *Process) isStartable() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	state := p.state.Load()
+	return !p.inStart && (state == Stopped || state == Exited || state == Backoff)
+}
+
+// isLazyStart checks if the program is started on the first connection to
+// its socket instead of being started immediately
+func (p *Process) isLazyStart() bool {
+	return p.socket != nil && p.config.GetBool("socket_lazy", false)
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSocketURL(t *testing.T) {
+	network, address, err := parseSocketURL("tcp://127.0.0.1:8080")
+	if err != nil || network != "tcp" || address != "127.0.0.1:8080" {
+		t.Errorf("unexpected tcp socket %s %s %v", network, address, err)
+	}
+	network, address, err = parseSocketURL("unix:///tmp/app.sock")
+	if err != nil || network != "unix" || address != "/tmp/app.sock" {
+		t.Errorf("unexpected unix socket %s %s %v", network, address, err)
+	}
+	for _, url := range []string{"127.0.0.1:8080", "udp://127.0.0.1:53", "tcp://"} {
+		if _, _, err = parseSocketURL(url); err == nil {
+			t.Errorf("expected error for socket %s", url)
+		}
+	}
+}
+
+func TestProgramSocketPassedWithListenPid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	socket, err := newProgramSocket("unix://"+path, "600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	f, err := socket.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", `echo "$LISTEN_FDS $LISTEN_FDNAMES $LISTEN_PID $$"`)
+	if err = passSocket(cmd, f, 3, "app"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 4 || fields[0] != "1" || fields[1] != "app" || fields[2] != fields[3] {
+		t.Errorf("unexpected environment of program: %s", out)
+	}
+}
+
+func TestProgramSocketWaitConnection(t *testing.T) {
+	socket, err := newProgramSocket("tcp://127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	f, err := socket.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := waitConnection(f, 10*time.Millisecond); err != nil || pending {
+		t.Errorf("expected no pending connection, got %v %v", pending, err)
+	}
+	listener, err := net.FileListener(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if pending, err := waitConnection(f, time.Second); err != nil || !pending {
+		t.Errorf("expected pending connection, got %v %v", pending, err)
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// passSocket makes the socket to be file descriptor fd of the program. If fd
+// is 3 the LISTEN_FDS, LISTEN_FDNAMES and LISTEN_PID environment variables
+// are set for systemd socket activation
+func passSocket(cmd *exec.Cmd, f *os.File, fd int, name string) error {
+	if fd == 0 {
+		cmd.Stdin = f
+		return nil
+	}
+	// the files before fd are closed in the program
+	cmd.ExtraFiles = make([]*os.File, fd-2)
+	cmd.ExtraFiles[fd-3] = f
+	if fd != 3 {
+		return nil
+	}
+	cmd.Env = appendEnvWithOverride(cmd.Env, "LISTEN_FDS", "1", "LISTEN_FDNAMES", name)
+	cmd.Args = append([]string{"sh", "-c", listenPidScript, cmd.Path}, cmd.Args[1:]...)
+	path, err := exec.LookPath("sh")
+	if err != nil {
+		return fmt.Errorf("fail to set LISTEN_PID: %v", err)
+	}
+	cmd.Path = path
+	return nil
+}
+
+// waitConnection waits until a connection to the listening socket is pending
+// or timeout. Returns false if it is timeout
+func waitConnection(f *os.File, timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+	if err == unix.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if n > 0 && fds[0].Revents&unix.POLLNVAL != 0 {
+		return false, fmt.Errorf("socket is closed")
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// passSocket returns error because the socket can't be passed to the program
+// on windows
+func passSocket(cmd *exec.Cmd, f *os.File, fd int, name string) error {
+	return fmt.Errorf("passing socket to program is not supported on windows")
+}
+
+// waitConnection returns error because the socket can't be passed to the
+// program on windows
+func waitConnection(f *os.File, timeout time.Duration) (bool, error) {
+	return false, fmt.Errorf("passing socket to program is not supported on windows")
+}
@@ -0,0 +1,205 @@
+package process
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the desired states of a program
+const (
+	DesiredStateStarted = "started"
+	DesiredStateStopped = "stopped"
+)
+
+// DesiredState the state of a program wanted by the user
+type DesiredState struct {
+	// started or stopped
+	State string `json:"state"`
+	// the last manual action like start, stop, start_group or stop_all
+	Action string `json:"action"`
+	// the time of the last manual action
+	Time time.Time `json:"time"`
+}
+
+// ProcessRecord the process spawned by supervisord. It is adopted by the
+// next supervisord if supervisord exits without stopping it
+type ProcessRecord struct {
+	Pid int `json:"pid"`
+	// the create time of the process in milliseconds since epoch
+	CreateTime int64    `json:"create_time"`
+	Cmdline    []string `json:"cmdline"`
+	// the file descriptors of the stdin, stdout and stderr pipes inherited
+	// by the new supervisord on upgrade
+	Stdin  int `json:"stdin_fd,omitempty"`
+	Stdout int `json:"stdout_fd,omitempty"`
+	Stderr int `json:"stderr_fd,omitempty"`
+}
+
+// stateFileContent the content of the state file
+type stateFileContent struct {
+	Programs  map[string]DesiredState  `json:"programs"`
+	Processes map[string]ProcessRecord `json:"processes,omitempty"`
+}
+
+// StateStore keeps the desired state of the programs in a state file, so the
+// programs started or stopped by the user are kept started or stopped when
+// supervisord is restarted or its configuration is reloaded
+type StateStore struct {
+	file     string
+	lock     sync.Mutex
+	programs map[string]DesiredState
+	// the running processes of the programs if the adoption is enabled
+	processes map[string]ProcessRecord
+}
+
+// GetDefaultStateFile returns the state file next to the pidfile, like
+// /var/run/supervisord.state for /var/run/supervisord.pid
+func GetDefaultStateFile(pidfile string) string {
+	return strings.TrimSuffix(pidfile, filepath.Ext(pidfile)) + ".state"
+}
+
+// NewStateStore creates StateStore object and loads the desired states from
+// the state file. The store is empty if the file does not exist or is broken
+func NewStateStore(file string) *StateStore {
+	store := &StateStore{file: file,
+		programs:  make(map[string]DesiredState),
+		processes: make(map[string]ProcessRecord)}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"file": file}).Error("failed to read state file: ", err)
+		}
+		return store
+	}
+	content := stateFileContent{}
+	if err = json.Unmarshal(b, &content); err != nil {
+		log.WithFields(log.Fields{"file": file}).Error("failed to parse state file: ", err)
+		return store
+	}
+	for name, state := range content.Programs {
+		store.programs[name] = state
+	}
+	for name, record := range content.Processes {
+		store.processes[name] = record
+	}
+	return store
+}
+
+// GetFile gets the state file
+func (s *StateStore) GetFile() string {
+	if s == nil {
+		return ""
+	}
+	return s.file
+}
+
+// Get gets the desired state of the program
+func (s *StateStore) Get(program string) (DesiredState, bool) {
+	if s == nil {
+		return DesiredState{}, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.programs[program]
+	return state, ok
+}
+
+// Set records the desired state and the manual action of the programs
+func (s *StateStore) Set(state string, action string, programs ...string) {
+	if s == nil || len(programs) == 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for _, program := range programs {
+		s.programs[program] = DesiredState{State: state, Action: action, Time: now}
+	}
+	s.save()
+}
+
+// Reset forgets the desired state of the programs, or all the programs if
+// no program is given. The autostart of the forgotten programs takes effect
+// again. Returns the programs forgotten
+func (s *StateStore) Reset(programs ...string) []string {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(programs) == 0 {
+		for program := range s.programs {
+			programs = append(programs, program)
+		}
+	}
+	removed := make([]string, 0)
+	for _, program := range programs {
+		if _, ok := s.programs[program]; ok {
+			delete(s.programs, program)
+			removed = append(removed, program)
+		}
+	}
+	if len(removed) > 0 {
+		s.save()
+	}
+	return removed
+}
+
+// GetProcesses gets the recorded processes of the programs
+func (s *StateStore) GetProcesses() map[string]ProcessRecord {
+	result := make(map[string]ProcessRecord)
+	if s == nil {
+		return result
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for program, record := range s.processes {
+		result[program] = record
+	}
+	return result
+}
+
+// SetProcess records the running process of the program
+func (s *StateStore) SetProcess(program string, record ProcessRecord) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.processes[program] = record
+	s.save()
+}
+
+// RemoveProcess removes the recorded process of the program
+func (s *StateStore) RemoveProcess(program string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.processes[program]; ok {
+		delete(s.processes, program)
+		s.save()
+	}
+}
+
+// save writes the desired states and the processes to a temporary file and renames it to the
+// state file, so the state file is never partially written
+func (s *StateStore) save() {
+	b, err := json.MarshalIndent(&stateFileContent{Programs: s.programs, Processes: s.processes}, "", "  ")
+	if err == nil {
+		tmpFile := s.file + ".tmp"
+		if err = os.WriteFile(tmpFile, b, 0644); err == nil {
+			err = os.Rename(tmpFile, s.file)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"file": s.file}).Error("failed to write state file: ", err)
+	}
+}
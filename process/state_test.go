@@ -0,0 +1,91 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDefaultStateFile(t *testing.T) {
+	if file := GetDefaultStateFile("/var/run/supervisord.pid"); file != "/var/run/supervisord.state" {
+		t.Errorf("unexpected state file %s", file)
+	}
+	if file := GetDefaultStateFile("/tmp/supervisord"); file != "/tmp/supervisord.state" {
+		t.Errorf("unexpected state file %s", file)
+	}
+}
+
+func TestStateStorePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "supervisord.state")
+	store := NewStateStore(file)
+	store.Set(DesiredStateStopped, "stop", "web")
+	store.Set(DesiredStateStarted, "start_group", "worker1", "worker2")
+
+	store = NewStateStore(file)
+	state, ok := store.Get("web")
+	if !ok || state.State != DesiredStateStopped || state.Action != "stop" {
+		t.Errorf("unexpected state of web: %v %v", state, ok)
+	}
+	state, ok = store.Get("worker2")
+	if !ok || state.State != DesiredStateStarted || state.Action != "start_group" {
+		t.Errorf("unexpected state of worker2: %v %v", state, ok)
+	}
+	if _, ok = store.Get("db"); ok {
+		t.Error("expected no state of db")
+	}
+}
+
+func TestStateStoreReset(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "supervisord.state")
+	store := NewStateStore(file)
+	store.Set(DesiredStateStopped, "stop_all", "web", "db", "cache")
+
+	if removed := store.Reset("web", "unknown"); len(removed) != 1 || removed[0] != "web" {
+		t.Errorf("unexpected removed programs %v", removed)
+	}
+	if _, ok := NewStateStore(file).Get("web"); ok {
+		t.Error("expected the state of web is reset in the file")
+	}
+	if removed := store.Reset(); len(removed) != 2 {
+		t.Errorf("expected all the programs are reset, got %v", removed)
+	}
+	if _, ok := NewStateStore(file).Get("db"); ok {
+		t.Error("expected the state of db is reset in the file")
+	}
+}
+
+func TestStateStoreBrokenFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "supervisord.state")
+	if err := os.WriteFile(file, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStateStore(file)
+	if _, ok := store.Get("web"); ok {
+		t.Error("expected empty store from broken state file")
+	}
+	var nilStore *StateStore
+	nilStore.Set(DesiredStateStarted, "start", "web")
+	if _, ok := nilStore.Get("web"); ok {
+		t.Error("expected no state from nil store")
+	}
+}
+
+func TestStateStoreProcesses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "supervisord.state")
+	store := NewStateStore(file)
+	store.Set(DesiredStateStopped, "stop", "db")
+	store.SetProcess("web", ProcessRecord{Pid: 100, CreateTime: 1000, Cmdline: []string{"/bin/web"}})
+
+	store = NewStateStore(file)
+	records := store.GetProcesses()
+	if record, ok := records["web"]; !ok || record.Pid != 100 || record.CreateTime != 1000 {
+		t.Errorf("unexpected recorded processes %v", records)
+	}
+	if _, ok := store.Get("db"); !ok {
+		t.Error("expected the desired state is kept with the processes")
+	}
+	store.RemoveProcess("web")
+	if records = NewStateStore(file).GetProcesses(); len(records) != 0 {
+		t.Errorf("expected no recorded process, got %v", records)
+	}
+}
@@ -0,0 +1,151 @@
+package process
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ResourceWatchdog samples the memory and CPU usage of a running program and
+// reports when the memory_limit or cpu_limit is exceeded for limit_sustain_secs
+type ResourceWatchdog struct {
+	lock        sync.Mutex
+	programName string
+	// the memory limit in bytes, 0 if not set
+	memoryLimit uint64
+	// the CPU limit in percent of one CPU, 0 if not set
+	cpuLimit      float64
+	sustain       time.Duration
+	checkPeriod   time.Duration
+	processTree   bool
+	action        string
+	signal        string
+	nextCheckTime time.Time
+	// the last CPU sample of the process
+	lastPid        int
+	lastCPUSeconds float64
+	lastCPUTime    time.Time
+	// the time the limit is exceeded first in the sustained period
+	memoryExceededSince time.Time
+	cpuExceededSince    time.Time
+}
+
+// LimitExceeded the information of an exceeded resource limit
+type LimitExceeded struct {
+	// memory or cpu
+	Limit     string
+	Value     float64
+	Threshold float64
+}
+
+// NewResourceWatchdog creates ResourceWatchdog from program configuration,
+// returns nil if neither memory_limit nor cpu_limit is configured
+func NewResourceWatchdog(programName string, config *config.Entry) *ResourceWatchdog {
+	memoryLimit := config.GetBytes("memory_limit", 0)
+	cpuLimit := config.GetFloat("cpu_limit", 0)
+	if memoryLimit <= 0 && cpuLimit <= 0 {
+		return nil
+	}
+	if memoryLimit < 0 {
+		memoryLimit = 0
+	}
+	if cpuLimit < 0 {
+		cpuLimit = 0
+	}
+	return &ResourceWatchdog{
+		programName: programName,
+		memoryLimit: uint64(memoryLimit),
+		cpuLimit:    cpuLimit,
+		sustain:     time.Duration(config.GetInt("limit_sustain_secs", 30)) * time.Second,
+		checkPeriod: time.Duration(config.GetInt("limit_check_period", 5)) * time.Second,
+		processTree: config.GetBool("limit_process_tree", false),
+		action:      strings.TrimSpace(config.GetString("limit_action", "restart")),
+		signal:      strings.TrimSpace(config.GetString("limit_signal", "TERM")),
+	}
+}
+
+// GetAction returns the action when the limit is exceeded: restart, stop,
+// signal or a script
+func (w *ResourceWatchdog) GetAction() string {
+	return w.action
+}
+
+// GetSignal returns the signal sent to the program with signal action
+func (w *ResourceWatchdog) GetSignal() string {
+	return w.signal
+}
+
+// Check samples the resource usage of process pid if it is time to check and
+// returns the limit exceeded for the sustained period
+func (w *ResourceWatchdog) Check(pid int) *LimitExceeded {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+	if now.Before(w.nextCheckTime) {
+		return nil
+	}
+	w.nextCheckTime = now.Add(w.checkPeriod)
+
+	usage := &resourceUsage{}
+	if err := addResourceUsage(int32(pid), w.processTree, usage); err != nil {
+		log.WithFields(log.Fields{"program": w.programName}).Debug("failed to get resource usage of program: ", err)
+		return nil
+	}
+	if pid != w.lastPid {
+		// the program is restarted
+		w.reset()
+		w.lastPid = pid
+	}
+
+	if w.memoryLimit > 0 {
+		if exceeded := w.checkSustained(&w.memoryExceededSince, usage.rss > w.memoryLimit, now); exceeded {
+			return &LimitExceeded{Limit: "memory", Value: float64(usage.rss), Threshold: float64(w.memoryLimit)}
+		}
+	}
+
+	if w.cpuLimit > 0 {
+		cpuPercent := -1.0
+		if !w.lastCPUTime.IsZero() && now.After(w.lastCPUTime) {
+			cpuPercent = (usage.cpuSeconds - w.lastCPUSeconds) / now.Sub(w.lastCPUTime).Seconds() * 100
+		}
+		w.lastCPUSeconds = usage.cpuSeconds
+		w.lastCPUTime = now
+		if cpuPercent >= 0 {
+			if exceeded := w.checkSustained(&w.cpuExceededSince, cpuPercent > w.cpuLimit, now); exceeded {
+				return &LimitExceeded{Limit: "cpu", Value: cpuPercent, Threshold: w.cpuLimit}
+			}
+		}
+	}
+	return nil
+}
+
+// checkSustained returns true if the limit is exceeded since at least the sustained period
+func (w *ResourceWatchdog) checkSustained(since *time.Time, exceeded bool, now time.Time) bool {
+	if !exceeded {
+		*since = time.Time{}
+		return false
+	}
+	if since.IsZero() {
+		*since = now
+	}
+	return now.Sub(*since) >= w.sustain
+}
+
+// Reset clears the samples, it should be called after the action is taken
+func (w *ResourceWatchdog) Reset() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.reset()
+}
+
+func (w *ResourceWatchdog) reset() {
+	w.lastPid = 0
+	w.lastCPUSeconds = 0
+	w.lastCPUTime = time.Time{}
+	w.memoryExceededSince = time.Time{}
+	w.cpuExceededSince = time.Time{}
+}
@@ -0,0 +1,85 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+func TestParseRlimits(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:limited]\ncommand=/bin/ls\nrlimit_nofile=1024:4096\nrlimit_core=unlimited\nrlimit_as=512MB\n"+
+		"[program:soft_above_hard]\ncommand=/bin/ls\nrlimit_nofile=4096:1024\n"+
+		"[program:invalid]\ncommand=/bin/ls\nrlimit_nproc=many\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := parseRlimits(cfg.GetProgram("limited"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []rlimitSetting{
+		{name: "nofile", soft: 1024, hard: 4096},
+		{name: "core", soft: rlimitUnlimited, hard: rlimitUnlimited},
+		{name: "as", soft: 512 * 1024 * 1024, hard: 512 * 1024 * 1024},
+	}
+	if len(settings) != len(expected) {
+		t.Fatalf("expect %d rlimits, got %v", len(expected), settings)
+	}
+	for i, setting := range settings {
+		if setting != expected[i] {
+			t.Errorf("expect %v, got %v", expected[i], setting)
+		}
+	}
+
+	if _, err = parseRlimits(cfg.GetProgram("soft_above_hard")); err == nil {
+		t.Error("soft limit greater than hard limit should fail")
+	}
+	if _, err = parseRlimits(cfg.GetProgram("invalid")); err == nil {
+		t.Error("invalid rlimit value should fail")
+	}
+}
+
+func TestResourceWatchdogNotConfigured(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:test]\ncommand=/bin/ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if NewResourceWatchdog("test", cfg.GetProgram("test")) != nil {
+		t.Error("watchdog should not be created without memory_limit and cpu_limit")
+	}
+}
+
+func TestResourceWatchdogMemoryLimit(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte("[program:immediate]\ncommand=/bin/ls\nmemory_limit=1KB\nlimit_sustain_secs=0\nlimit_check_period=0\nlimit_action=stop\n"+
+		"[program:sustained]\ncommand=/bin/ls\nmemory_limit=1KB\nlimit_sustain_secs=60\nlimit_check_period=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig(fileName)
+	if _, err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	watchdog := NewResourceWatchdog("immediate", cfg.GetProgram("immediate"))
+	exceeded := watchdog.Check(os.Getpid())
+	if exceeded == nil || exceeded.Limit != "memory" || exceeded.Threshold != 1024 {
+		t.Fatalf("memory limit should be exceeded, got %+v", exceeded)
+	}
+	if watchdog.GetAction() != "stop" {
+		t.Errorf("expect stop action, got %s", watchdog.GetAction())
+	}
+
+	watchdog = NewResourceWatchdog("sustained", cfg.GetProgram("sustained"))
+	if exceeded = watchdog.Check(os.Getpid()); exceeded != nil {
+		t.Errorf("memory limit should not be exceeded before the sustained period, got %+v", exceeded)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/rpc"
+	"github.com/ochinchina/gorilla-xmlrpc/xml"
+	"github.com/ochinchina/supervisord/config"
+	"github.com/ochinchina/supervisord/process"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the permissions granted by the roles
+const (
+	// get the state and the information of supervisord and the programs
+	permissionView = "view"
+	// read and search the logs of the programs
+	permissionLog = "log"
+	// start, stop, restart and signal the programs
+	permissionControl = "control"
+	// reload the configuration and read the program configuration files
+	permissionConfig = "config"
+	// shutdown, restart and upgrade supervisord
+	permissionAdmin = "admin"
+)
+
+// the permissions of the builtin roles, the users are assigned to them in
+// [role:viewer], [role:operator] and [role:admin] sections
+var builtinRoles = map[string][]string{
+	"viewer":   {permissionView},
+	"operator": {permissionView, permissionLog, permissionControl},
+	"admin":    {permissionView, permissionLog, permissionControl, permissionConfig, permissionAdmin},
+}
+
+// rpcMethodAccess the permission required by an XML-RPC method and if the
+// first parameter of the method is the program or group name
+type rpcMethodAccess struct {
+	permission string
+	target     bool
+}
+
+// the permissions of the XML-RPC methods by lower case method name, the
+// methods not listed here need the admin permission
+var rpcMethodAccesses = map[string]rpcMethodAccess{
+	"supervisor.getversion":           {permissionView, false},
+	"supervisor.getapiversion":        {permissionView, false},
+	"supervisor.getsupervisorversion": {permissionView, false},
+	"supervisor.getidentification":    {permissionView, false},
+	"supervisor.getstate":             {permissionView, false},
+	"supervisor.getpid":               {permissionView, false},
+	"supervisor.getallprocessinfo":    {permissionView, false},
+	"supervisor.getprocessinfo":       {permissionView, true},
+	"supervisor.getprocesshistory":    {permissionView, true},
+	"supervisor.readlog":              {permissionLog, false},
+	"supervisor.readprocessstdoutlog": {permissionLog, true},
+	"supervisor.readprocessstderrlog": {permissionLog, true},
+	"supervisor.tailprocessstdoutlog": {permissionLog, true},
+	"supervisor.tailprocessstderrlog": {permissionLog, true},
+	"supervisor.searchprocesslogs":    {permissionLog, false},
+	"supervisor.startprocess":         {permissionControl, true},
+	"supervisor.startprocessgroup":    {permissionControl, true},
+	"supervisor.startallprocesses":    {permissionControl, false},
+	"supervisor.stopprocess":          {permissionControl, true},
+	"supervisor.stopprocessgroup":     {permissionControl, true},
+	"supervisor.stopallprocesses":     {permissionControl, false},
+	"supervisor.signalprocess":        {permissionControl, true},
+	"supervisor.signalprocessgroup":   {permissionControl, true},
+	"supervisor.signalallprocesses":   {permissionControl, false},
+	"supervisor.sendprocessstdin":     {permissionControl, true},
+	"supervisor.sendremotecommevent":  {permissionControl, false},
+	"supervisor.resetprocessstate":    {permissionControl, true},
+	"supervisor.rollingrestart":       {permissionControl, true},
+	"supervisor.clearprocesslogs":     {permissionControl, true},
+	"supervisor.clearallprocesslogs":  {permissionControl, false},
+	"supervisor.rotateprocesslogs":    {permissionControl, true},
+	"supervisor.rotateallprocesslogs": {permissionControl, false},
+	"supervisor.reloadconfig":         {permissionConfig, false},
+	"supervisor.addprocessgroup":      {permissionConfig, false},
+	"supervisor.removeprocessgroup":   {permissionConfig, false},
+	"supervisor.shutdown":             {permissionAdmin, false},
+	"supervisor.restart":              {permissionAdmin, false},
+	"supervisor.upgrade":              {permissionAdmin, false},
+	"supervisor.clearlog":             {permissionAdmin, false},
+	"supervisor.rotatelog":            {permissionAdmin, false},
+}
+
+// accessRole the permissions granted to the users of a role
+type accessRole struct {
+	name string
+	// the permissions or the lower case XML-RPC method names
+	permissions map[string]bool
+	// the program patterns the permissions are limited to, empty if the
+	// permissions are granted for all the programs
+	programs []string
+}
+
+// accessControl the users and their roles of the http servers
+type accessControl struct {
+	// the password hash by user
+	passwords map[string]string
+	// the roles by user
+	roles map[string][]*accessRole
+	// the tokens by the hex sha256 hash of the token
+	tokens map[string]*accessToken
+	// the roles by the uid and gid of the peer process connecting to the unix
+	// socket http server
+	peerUsers  map[uint32][]*accessRole
+	peerGroups map[uint32][]*accessRole
+}
+
+// accessRequest the permission and the programs needed by a http request
+type accessRequest struct {
+	// the lower case XML-RPC method name, empty for other requests
+	method string
+	// the XML-RPC method name without the namespace recorded in the audit
+	// log, empty for other requests
+	operation  string
+	permission string
+	// the program or group names the request is on, empty if the request is
+	// on supervisord or all the programs
+	names []string
+}
+
+type requestUserKey struct{}
+
+// requestUser the authenticated user of a http request
+type requestUser struct {
+	name string
+	// the user configured in the http server section, it has all the
+	// permissions
+	admin bool
+	// the roles of the token or the peer process, nil if the roles are
+	// configured for the user name
+	roles []*accessRole
+}
+
+// withRequestUser attaches the authenticated user to the http request
+func withRequestUser(r *http.Request, user *requestUser) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestUserKey{}, user))
+}
+
+// getRequestUser gets the authenticated user of the http request, nil if the
+// request is not authenticated
+func getRequestUser(r *http.Request) *requestUser {
+	user, _ := r.Context().Value(requestUserKey{}).(*requestUser)
+	return user
+}
+
+// newAccessControl loads the users from the [users] section and the
+// users_file of the [supervisord] section, their roles from the
+// [role:<name>] sections and the tokens from the [token:<name>] sections.
+// Returns nil if no user or token is configured
+func newAccessControl(cfg *config.Config) (*accessControl, error) {
+	ac := &accessControl{passwords: make(map[string]string),
+		roles:      make(map[string][]*accessRole),
+		tokens:     make(map[string]*accessToken),
+		peerUsers:  make(map[uint32][]*accessRole),
+		peerGroups: make(map[uint32][]*accessRole),
+	}
+	if entry, ok := cfg.GetSupervisord(); ok {
+		if usersFile := entry.GetString("users_file", ""); usersFile != "" {
+			if err := ac.loadUsersFile(usersFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if entry, ok := cfg.GetUsers(); ok {
+		for _, user := range entry.GetKeys() {
+			ac.passwords[user] = entry.GetString(user, "")
+		}
+	}
+	for _, entry := range cfg.GetRoles() {
+		role, err := newAccessRole(strings.TrimPrefix(entry.Name, "role:"), entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range entry.GetStringArray("users", ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				ac.roles[user] = append(ac.roles[user], role)
+			}
+		}
+		if err = ac.addPeerRole(role, entry); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range cfg.GetTokens() {
+		token, err := newAccessToken(entry)
+		if err != nil {
+			return nil, err
+		}
+		ac.tokens[token.hash] = token
+	}
+	if len(ac.passwords) == 0 && len(ac.roles) == 0 && len(ac.tokens) == 0 && len(ac.peerUsers) == 0 && len(ac.peerGroups) == 0 {
+		return nil, nil
+	}
+	return ac, nil
+}
+
+// loadUsersFile loads the users from the htpasswd style file with one
+// user:password line per user
+func (ac *accessControl) loadUsersFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos := strings.Index(line, ":")
+		if pos <= 0 {
+			return fmt.Errorf("invalid line in users file %s: %s", fileName, line)
+		}
+		ac.passwords[line[0:pos]] = line[pos+1:]
+	}
+	return scanner.Err()
+}
+
+// newAccessRole creates the role from the [role:<name>] or [token:<name>]
+// section with the permissions and the program patterns
+func newAccessRole(name string, entry *config.Entry) (*accessRole, error) {
+	role := &accessRole{name: name, permissions: make(map[string]bool)}
+	permissions := builtinRoles[role.name]
+	if entry.HasParameter("permissions") {
+		permissions = entry.GetStringArray("permissions", ",")
+	}
+	for _, permission := range permissions {
+		permission = strings.ToLower(strings.TrimSpace(permission))
+		if permission == "" {
+			continue
+		}
+		if rolePermissions, ok := builtinRoles[permission]; ok {
+			// a builtin role grants all its permissions
+			for _, p := range rolePermissions {
+				role.permissions[p] = true
+			}
+		} else if _, ok := rpcMethodAccesses[permission]; ok || isPermission(permission) {
+			role.permissions[permission] = true
+		} else {
+			return nil, fmt.Errorf("invalid permission %s of role %s", permission, role.name)
+		}
+	}
+	if len(role.permissions) == 0 {
+		return nil, fmt.Errorf("no permissions of role %s", role.name)
+	}
+	for _, pattern := range entry.GetStringArray("programs", ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid program pattern %s of role %s", pattern, role.name)
+			}
+			role.programs = append(role.programs, pattern)
+		}
+	}
+	return role, nil
+}
+
+func isPermission(permission string) bool {
+	for _, p := range builtinRoles["admin"] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate checks the password of the user
+func (ac *accessControl) authenticate(user string, password string) bool {
+	hash, ok := ac.passwords[user]
+	return ok && checkPassword(hash, password)
+}
+
+// checkPassword checks the password against the bcrypt hash ($2a$, $2b$ or
+// $2y$), the SHA1 hash in hex or base64 with {SHA} prefix, or the plain text
+func checkPassword(hash string, password string) bool {
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password)) //nolint:gosec
+		expected := hash[5:]
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(expected)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(expected)) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+}
+
+// getRoles gets the roles of the authenticated user
+func (ac *accessControl) getRoles(user *requestUser) []*accessRole {
+	if user.roles != nil {
+		return user.roles
+	}
+	return ac.roles[user.name]
+}
+
+// isAllowed checks if any role of the user grants the request
+func (ac *accessControl) isAllowed(user *requestUser, req *accessRequest, procMgr *process.Manager) bool {
+	for _, role := range ac.getRoles(user) {
+		if role.isAllowed(req, procMgr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed checks if the role has the permission of the request, and all the
+// programs of the request match the program patterns of the role if the role
+// is limited to some programs
+func (role *accessRole) isAllowed(req *accessRequest, procMgr *process.Manager) bool {
+	if !role.permissions[req.permission] && (req.method == "" || !role.permissions[req.method]) {
+		return false
+	}
+	if len(role.programs) == 0 {
+		return true
+	}
+	if len(req.names) == 0 {
+		return false
+	}
+	for _, name := range req.names {
+		if !role.isProgramAllowed(name, procMgr) {
+			return false
+		}
+	}
+	return true
+}
+
+// isProgramAllowed checks if all the processes of the program or group name
+// match the program patterns of the role
+func (role *accessRole) isProgramAllowed(name string, procMgr *process.Manager) bool {
+	procs := procMgr.FindMatch(name)
+	if len(procs) == 0 && !strings.Contains(name, ":") {
+		procs = procMgr.FindMatch(name + ":*")
+	}
+	if len(procs) == 0 {
+		return role.matchProgram(name)
+	}
+	for _, proc := range procs {
+		if !role.matchProgram(proc.GetName()) && !role.matchProgram(proc.GetGroup()+":"+proc.GetName()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (role *accessRole) matchProgram(name string) bool {
+	for _, pattern := range role.programs {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// getRoleNames gets the names of the roles
+func getRoleNames(roles []*accessRole) []string {
+	names := make([]string, 0)
+	for _, role := range roles {
+		names = append(names, role.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// accessRule gets the permission and the programs needed by the http request
+type accessRule func(r *http.Request) (*accessRequest, error)
+
+// accessHandler checks if the authenticated user of the request is allowed
+// to access the handler by the roles of the user
+type accessHandler struct {
+	supervisor *Supervisor
+	rule       accessRule
+	handler    http.Handler
+}
+
+// newAccessHandler creates the handler checking the permissions of the
+// requests to the handler with the rule
+func newAccessHandler(s *Supervisor, rule accessRule, handler http.Handler) *accessHandler {
+	return &accessHandler{supervisor: s, rule: rule, handler: handler}
+}
+
+func (h *accessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ac := h.supervisor.getAccessControl()
+	user := getRequestUser(r)
+	if ac == nil || (user != nil && user.admin) {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	req, err := h.rule(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user != nil && ac.isAllowed(user, req, h.supervisor.GetManager()) {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	userName, roleNames := "", make([]string, 0)
+	if user != nil {
+		userName, roleNames = user.name, getRoleNames(ac.getRoles(user))
+	}
+	log.WithFields(log.Fields{"user": userName, "roles": strings.Join(roleNames, ","), "permission": req.permission, "method": req.method, "programs": strings.Join(req.names, ",")}).Warn("access is denied")
+	h.supervisor.auditDeniedRequest(r, req)
+	http.Error(w, "permission denied", http.StatusForbidden)
+}
+
+// newPermissionRule creates the rule of the requests needing the permission
+// on all the programs
+func newPermissionRule(permission string) accessRule {
+	return func(r *http.Request) (*accessRequest, error) {
+		return &accessRequest{permission: permission}, nil
+	}
+}
+
+// newProgramPermissionRule creates the rule of the requests needing the
+// permission on the program
+func newProgramPermissionRule(permission string, program string) accessRule {
+	return func(r *http.Request) (*accessRequest, error) {
+		return &accessRequest{permission: permission, names: []string{program}}, nil
+	}
+}
+
+// newPathPermissionRule creates the rule of the requests needing the
+// permission on the program, which is the first path element after the
+// prefix like /logtail/<program>/stdout
+func newPathPermissionRule(permission string, prefix string) accessRule {
+	return func(r *http.Request) (*accessRequest, error) {
+		name := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)[0]
+		return &accessRequest{permission: permission, names: []string{name}}, nil
+	}
+}
+
+// rpcAccessRule gets the permission of the XML-RPC request by its method
+// name and its first parameter. The request is decoded by the codec which
+// runs the method, so the program authorized is the one the method acts on
+func rpcAccessRule(r *http.Request) (*accessRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	codecReq := xml.NewCodec().NewRequest(&http.Request{Body: io.NopCloser(bytes.NewReader(body))})
+	methodName, err := codecReq.Method()
+	if err != nil {
+		return nil, fmt.Errorf("invalid XML-RPC request: %v", err)
+	}
+	method := strings.ToLower(methodName)
+	operation := strings.TrimPrefix(methodName, "supervisor.")
+	methodAccess, ok := rpcMethodAccesses[method]
+	if !ok {
+		return &accessRequest{method: method, operation: operation, permission: permissionAdmin}, nil
+	}
+	req := &accessRequest{method: method, operation: operation, permission: methodAccess.permission}
+	if methodAccess.target {
+		req.names = decodeRPCTargets(codecReq, method)
+	}
+	return req, nil
+}
+
+// decodeRPCTargets decodes the programs the XML-RPC method acts on from the
+// first field of its arguments, like the codec does before calling the method
+func decodeRPCTargets(codecReq rpc.CodecRequest, method string) (names []string) {
+	defer func() {
+		// the codec panics if an array is passed for a string
+		if recover() != nil {
+			names = []string{""}
+		}
+	}()
+	if method == "supervisor.resetprocessstate" {
+		var args struct{ Names []string }
+		_ = codecReq.ReadRequest(&args)
+		return args.Names
+	}
+	// the name is empty if the first parameter is not a string
+	var args struct{ Name string }
+	_ = codecReq.ReadRequest(&args)
+	return []string{args.Name}
+}
+
+// restAccessRule gets the permission of the request to the program and
+// supervisor restful interface by its path
+func restAccessRule(r *http.Request) (*accessRequest, error) {
+	elems := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if elems[0] == "supervisor" {
+		switch elems[len(elems)-1] {
+		case "shutdown", "audit":
+			return &accessRequest{permission: permissionAdmin}, nil
+		case "reload":
+			return &accessRequest{permission: permissionConfig}, nil
+		}
+		return &accessRequest{permission: permissionView}, nil
+	}
+	if len(elems) < 2 {
+		return &accessRequest{permission: permissionView}, nil
+	}
+	switch elems[1] {
+	case "info", "history":
+		return &accessRequest{permission: permissionView, names: elems[len(elems)-1:]}, nil
+	case "start", "stop", "restart", "rollingRestart":
+		return &accessRequest{permission: permissionControl, names: elems[len(elems)-1:]}, nil
+	case "startPrograms", "stopPrograms":
+		return programsAccessRequest(r)
+	case "log":
+		if len(elems) > 2 && elems[2] == "search" {
+			req := &accessRequest{permission: permissionLog}
+			if programs := r.URL.Query().Get("programs"); programs != "" {
+				for _, program := range strings.Split(programs, ",") {
+					req.names = append(req.names, strings.TrimSpace(program))
+				}
+			}
+			return req, nil
+		}
+		if len(elems) > 3 {
+			return &accessRequest{permission: permissionLog, names: []string{elems[len(elems)-2]}}, nil
+		}
+		return &accessRequest{permission: permissionLog}, nil
+	}
+	return &accessRequest{permission: permissionView}, nil
+}
+
+// programsAccessRequest gets the programs in the json body of the request to
+// start or stop programs
+func programsAccessRequest(r *http.Request) (*accessRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var programs []struct {
+		Program string `json:"program"`
+	}
+	// the invalid body is rejected by the handler
+	_ = json.Unmarshal(body, &programs)
+	req := &accessRequest{permission: permissionControl, names: make([]string, 0)}
+	for _, program := range programs {
+		req.names = append(req.names, program.Program)
+	}
+	return req, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const rbacTestConfig = `
+[program:web]
+command=/bin/cat
+process_name=web_%(process_num)d
+numprocs=2
+numprocs_start=1
+
+[program:db]
+command=/bin/cat
+
+[program:api]
+command=/bin/cat
+
+[group:backend]
+programs=db
+
+[users]
+alice=secret
+bob=secret
+
+[role:web-operator]
+permissions=operator
+programs=web_*
+users=alice
+
+[role:backend-operator]
+permissions=view,supervisor.startprocess
+programs=backend:*
+users=bob
+`
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("secret")) //nolint:gosec
+	tests := []struct {
+		hash     string
+		password string
+		expected bool
+	}{
+		{string(bcryptHash), "secret", true},
+		{string(bcryptHash), "wrong", false},
+		{"$2y$" + strings.TrimPrefix(string(bcryptHash), "$2a$"), "secret", true},
+		{"{SHA}" + hex.EncodeToString(sum[:]), "secret", true},
+		{"{SHA}" + hex.EncodeToString(sum[:]), "wrong", false},
+		{"{SHA}" + base64.StdEncoding.EncodeToString(sum[:]), "secret", true},
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		if checkPassword(test.hash, test.password) != test.expected {
+			t.Errorf("expected %v for the hash %s and the password %s", test.expected, test.hash, test.password)
+		}
+	}
+}
+
+func TestRoleIsAllowed(t *testing.T) {
+	s := newTestSupervisor(t, rbacTestConfig)
+	ac, err := newAccessControl(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := ac.roles["alice"][0]
+	bob := ac.roles["bob"][0]
+	tests := []struct {
+		role     *accessRole
+		req      *accessRequest
+		expected bool
+	}{
+		{alice, &accessRequest{permission: permissionControl, names: []string{"web_1"}}, true},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"web:web_2"}}, true},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"web:*"}}, true},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"web"}}, true},
+		{alice, &accessRequest{permission: permissionLog, names: []string{"web_1", "web_2"}}, true},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"web_1", "db"}}, false},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"api"}}, false},
+		{alice, &accessRequest{permission: permissionControl, names: []string{"all"}}, false},
+		{alice, &accessRequest{permission: permissionControl}, false},
+		{alice, &accessRequest{permission: permissionConfig, names: []string{"web_1"}}, false},
+		{bob, &accessRequest{permission: permissionView, names: []string{"db"}}, true},
+		{bob, &accessRequest{permission: permissionView, names: []string{"backend:*"}}, true},
+		{bob, &accessRequest{permission: permissionView, names: []string{"backend"}}, true},
+		{bob, &accessRequest{permission: permissionView, names: []string{"api"}}, false},
+		{bob, &accessRequest{method: "supervisor.startprocess", permission: permissionControl, names: []string{"db"}}, true},
+		{bob, &accessRequest{method: "supervisor.stopprocess", permission: permissionControl, names: []string{"db"}}, false},
+	}
+	for i, test := range tests {
+		if test.role.isAllowed(test.req, s.procMgr) != test.expected {
+			t.Errorf("%d: expected %v for the role %s, the permission %s and the programs %v", i, test.expected, test.role.name, test.req.permission, test.req.names)
+		}
+	}
+}
+
+func TestRPCAccessRule(t *testing.T) {
+	tests := []struct {
+		body       string
+		method     string
+		permission string
+		names      []string
+	}{
+		{"<methodCall><methodName>supervisor.startProcess</methodName><params><param><value><string>web_1</string></value></param></params></methodCall>",
+			"supervisor.startprocess", permissionControl, []string{"web_1"}},
+		{"<methodCall><methodName>supervisor.stopProcess</methodName><params><param><value>db</value></param></params></methodCall>",
+			"supervisor.stopprocess", permissionControl, []string{"db"}},
+		// the name is not trimmed like the codec running the method
+		{"<methodCall><methodName>supervisor.signalProcess</methodName><params><param><value><string> db</string></value></param><param><value>HUP</value></param></params></methodCall>",
+			"supervisor.signalprocess", permissionControl, []string{" db"}},
+		{"<methodCall><methodName>supervisor.startProcess</methodName><params><param><value><string></string>web_1</value></param></params></methodCall>",
+			"supervisor.startprocess", permissionControl, []string{"<string></string>web_1"}},
+		{"<methodCall><methodName>supervisor.startProcess</methodName><params><param><value><int>1</int></value></param></params></methodCall>",
+			"supervisor.startprocess", permissionControl, []string{""}},
+		{"<methodCall><methodName>supervisor.startProcess</methodName><params><param><value><array><data><value>db</value></data></array></value></param></params></methodCall>",
+			"supervisor.startprocess", permissionControl, []string{""}},
+		{"<methodCall><methodName>supervisor.resetProcessState</methodName><params><param><value><array><data><value>db</value><value><string>api</string></value></data></array></value></param></params></methodCall>",
+			"supervisor.resetprocessstate", permissionControl, []string{"db", "api"}},
+		{"<methodCall><methodName>supervisor.startAllProcesses</methodName><params></params></methodCall>",
+			"supervisor.startallprocesses", permissionControl, nil},
+		{"<methodCall><methodName>supervisor.getState</methodName></methodCall>",
+			"supervisor.getstate", permissionView, nil},
+		{"<methodCall><methodName>system.multicall</methodName></methodCall>",
+			"system.multicall", permissionAdmin, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/RPC2", strings.NewReader(test.body))
+		req, err := rpcAccessRule(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.method != test.method || req.permission != test.permission || !reflect.DeepEqual(req.names, test.names) {
+			t.Errorf("expected %s %s %q, got %s %s %q", test.method, test.permission, test.names, req.method, req.permission, req.names)
+		}
+	}
+	if _, err := rpcAccessRule(httptest.NewRequest("POST", "/RPC2", strings.NewReader("<methodCall>"))); err == nil {
+		t.Error("expected the invalid request is rejected")
+	}
+}
+
+func TestRestAccessRule(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		permission string
+		names      []string
+	}{
+		{"GET", "/program/list", "", permissionView, nil},
+		{"GET", "/program/info/node1/web_1", "", permissionView, []string{"web_1"}},
+		{"GET", "/program/history/node1/web_1", "", permissionView, []string{"web_1"}},
+		{"POST", "/program/start/node1/web_1", "", permissionControl, []string{"web_1"}},
+		{"POST", "/program/stop/node1/web_1", "", permissionControl, []string{"web_1"}},
+		{"POST", "/program/restart/node1/web_1", "", permissionControl, []string{"web_1"}},
+		{"POST", "/program/rollingRestart/node1/web", "", permissionControl, []string{"web"}},
+		{"POST", "/program/rollingRestart/web", "", permissionControl, []string{"web"}},
+		{"GET", "/program/log/search?programs=web_1,+db", "", permissionLog, []string{"web_1", "db"}},
+		{"GET", "/program/log/search", "", permissionLog, nil},
+		{"GET", "/program/log/node1/web_1/stdout", "", permissionLog, []string{"web_1"}},
+		{"GET", "/program/log/node1/web_1/stderr", "", permissionLog, []string{"web_1"}},
+		{"GET", "/program/log/web_1/stdout", "", permissionLog, []string{"web_1"}},
+		{"GET", "/program/log/web_1/stderr", "", permissionLog, []string{"web_1"}},
+		{"POST", "/program/startPrograms", `[{"program":"web_1"},{"program":"db"}]`, permissionControl, []string{"web_1", "db"}},
+		{"POST", "/program/stopPrograms", `[{"program":"web_1"}]`, permissionControl, []string{"web_1"}},
+		{"POST", "/program/stopPrograms", `invalid`, permissionControl, []string{}},
+		{"GET", "/supervisor/listNodes", "", permissionView, nil},
+		{"GET", "/supervisor/node1/ping", "", permissionView, nil},
+		{"POST", "/supervisor/shutdown", "", permissionAdmin, nil},
+		{"POST", "/supervisor/node1/shutdown", "", permissionAdmin, nil},
+		{"POST", "/supervisor/reload", "", permissionConfig, nil},
+		{"POST", "/supervisor/node1/reload", "", permissionConfig, nil},
+		{"GET", "/supervisor/audit", "", permissionAdmin, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req, err := restAccessRule(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.permission != test.permission || !reflect.DeepEqual(req.names, test.names) {
+			t.Errorf("%s: expected %s %q, got %s %q", test.path, test.permission, test.names, req.permission, req.names)
+		}
+	}
+}
+
+func TestProgramLogFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSupervisor(t, "[program:web]\ncommand=/bin/cat\nstdout_logfile="+dir+"/web.log\nstderr_logfile="+dir+"/web.err\n"+
+		"[program:db]\ncommand=/bin/cat\nstdout_logfile="+dir+"/db.log\n")
+	for _, name := range []string{"web.log", "web.log.1", "web.err.2.gz", "db.log", "webx.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &programLogFileSystem{dir: http.Dir(dir), program: "web", procMgr: s.procMgr}
+	for _, name := range []string{"/web.log", "/web.log.1", "/web.err.2.gz"} {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Errorf("expected %s is served: %v", name, err)
+			continue
+		}
+		f.Close()
+	}
+	for _, name := range []string{"/db.log", "/webx.log", "/../db.log"} {
+		if f, err := fs.Open(name); err == nil {
+			f.Close()
+			t.Errorf("expected %s is not served", name)
+		}
+	}
+	d, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	infos, err := d.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"web.err.2.gz", "web.log", "web.log.1"}) {
+		t.Errorf("unexpected log files %v", names)
+	}
+}
+
+func TestReloadKeepsConfigWithInvalidRole(t *testing.T) {
+	s := newTestSupervisor(t, rbacTestConfig)
+	db := s.config.GetProgram("db")
+
+	content := strings.Replace(rbacTestConfig, "[program:db]\ncommand=/bin/cat", "[program:db]\ncommand=/bin/sleep 10", 1) +
+		"\n[program:cache]\ncommand=/bin/cat\n\n[role:broken]\npermissions=unknown\nusers=alice\n"
+	if err := os.WriteFile(filepath.Join(s.config.GetConfigFileDir(), "supervisord.conf"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.Reload(false); err == nil {
+		t.Fatal("expected the configuration with invalid role is not loaded")
+	}
+	if s.config.GetProgram("db") != db || db.GetString("command", "") != "/bin/cat" {
+		t.Errorf("expected the previous program is kept, got command %s", db.GetString("command", ""))
+	}
+	if s.config.GetProgram("cache") != nil {
+		t.Error("expected the program of the invalid configuration is not added")
+	}
+}
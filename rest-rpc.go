@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -48,11 +51,17 @@ func (sr *SupervisorRestful) AddRemoteSupervisors(remoteSupervisors map[string]s
 func (sr *SupervisorRestful) CreateProgramHandler() http.Handler {
 	sr.router.HandleFunc("/program/list", sr.ListProgram).Methods("GET")
 	sr.router.HandleFunc("/program/info/{node}/{name}", sr.GetProgramInfo).Methods("GET")
+	sr.router.HandleFunc("/program/history/{node}/{name}", sr.GetProgramHistory).Methods("GET")
 	sr.router.HandleFunc("/program/start/{node}/{name}", sr.StartProgram).Methods("POST", "PUT")
 	sr.router.HandleFunc("/program/stop/{node}/{name}", sr.StopProgram).Methods("POST", "PUT")
 	sr.router.HandleFunc("/program/restart/{node}/{name}", sr.RestartProgram).Methods("POST", "PUT")
+	sr.router.HandleFunc("/program/rollingRestart/{node}/{name}", sr.RollingRestartProgram).Methods("POST", "PUT")
+	sr.router.HandleFunc("/program/rollingRestart/{name}", sr.RollingRestartProgram).Methods("POST", "PUT")
+	sr.router.HandleFunc("/program/log/search", sr.SearchLog).Methods("GET")
 	sr.router.HandleFunc("/program/log/{node}/{name}/stdout", sr.ReadStdoutLog).Methods("GET")
 	sr.router.HandleFunc("/program/log/{node}/{name}/stderr", sr.ReadStderrLog).Methods("GET")
+	sr.router.HandleFunc("/program/log/{name}/stdout", sr.ReadStdoutLog).Methods("GET")
+	sr.router.HandleFunc("/program/log/{name}/stderr", sr.ReadStderrLog).Methods("GET")
 	sr.router.HandleFunc("/program/startPrograms", sr.StartPrograms).Methods("POST", "PUT")
 	sr.router.HandleFunc("/program/stopPrograms", sr.StopPrograms).Methods("POST", "PUT")
 	return sr.router
@@ -64,6 +73,7 @@ func (sr *SupervisorRestful) CreateSupervisorHandler() http.Handler {
 	sr.router.HandleFunc("/supervisor/{node}/ping", sr.PingNode).Methods("GET")
 	sr.router.HandleFunc("/supervisor/shutdown", sr.Shutdown).Methods("PUT", "POST")
 	sr.router.HandleFunc("/supervisor/reload", sr.Reload).Methods("PUT", "POST")
+	sr.router.HandleFunc("/supervisor/audit", sr.ReadAuditLog).Methods("GET")
 	sr.router.HandleFunc("/supervisor/{node}/reload", sr.Reload).Methods("PUT", "POST")
 	sr.router.HandleFunc("/supervisor/{node}/shutdown", sr.Shutdown).Methods("PUT", "POST")
 	return sr.router
@@ -270,24 +280,76 @@ func (sr *SupervisorRestful) getRemoteProgramInfo(node, programName string) (*ty
 	return &result, nil
 }
 
+// GetProgramHistory get the past runs of the given program
+func (sr *SupervisorRestful) GetProgramHistory(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	node := params["node"]
+	programName := params["name"]
+	w.Header().Set("Content-Type", "application/json")
+	if node == "" || node == sr.supervisor.getNodeName() {
+		result := struct{ Runs []types.ProcessRun }{}
+		err := sr.supervisor.GetProcessHistory(req, &struct{ Name string }{Name: programName}, &result)
+		if err != nil {
+			log.WithFields(log.Fields{"node": node, "program": programName}).Warn("failed to get program history: ", err)
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		} else {
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(&result.Runs)
+		}
+	} else {
+		// get the program history from the remote supervisor
+		runs, err := sr.getRemoteProgramHistory(node, programName)
+		if err != nil {
+			log.WithFields(log.Fields{"node": node, "program": programName}).Warn("failed to get program history from remote node: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "failed to get program history from remote node"})
+		} else {
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(&runs)
+		}
+	}
+}
+
+func (sr *SupervisorRestful) getRemoteProgramHistory(node, programName string) ([]types.ProcessRun, error) {
+	url, ok := sr.remoteSupervisors[node]
+	if !ok {
+		return nil, fmt.Errorf("failed to find remote supervisor for node: %s", node)
+	}
+	response, err := http.Get(url + "/program/history/" + node + "/" + programName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get program history from remote node: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get program history from remote node: status code %d", response.StatusCode)
+	}
+	var result []types.ProcessRun
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response from remote node: %v", err)
+	}
+	return result, nil
+}
+
 // StartProgram start the given program through restful interface
 func (sr *SupervisorRestful) StartProgram(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	params := mux.Vars(req)
-	success, err := sr._startProgram(params["node"], params["name"])
+	success, err := sr._startProgram(req, params["node"], params["name"])
 	r := map[string]bool{"success": err == nil && success}
 	w.WriteHeader(200)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(&r)
 }
 
-func (sr *SupervisorRestful) _startProgram(node, program string) (bool, error) {
+func (sr *SupervisorRestful) _startProgram(req *http.Request, node, program string) (bool, error) {
 	log.WithFields(log.Fields{"node": node, "program": program}).Info("start program")
 	startArgs := StartProcessArgs{Name: program, Wait: true}
 
 	if node == "" || node == sr.supervisor.getNodeName() {
 		result := struct{ Success bool }{false}
-		err := sr.supervisor.StartProcess(nil, &startArgs, &result)
+		err := sr.supervisor.StartProcess(req, &startArgs, &result)
 		if err != nil {
 			log.WithFields(log.Fields{"node": node, "program": program}).Warn("failed to start program: ", err)
 		}
@@ -327,7 +389,7 @@ func (sr *SupervisorRestful) StartPrograms(w http.ResponseWriter, req *http.Requ
 		_, _ = w.Write([]byte("not a valid request"))
 	} else {
 		for _, program := range programs {
-			if _, err := sr._startProgram(program.Node, program.Program); err != nil {
+			if _, err := sr._startProgram(req, program.Node, program.Program); err != nil {
 				log.WithField("program", program).Warn("failed to start program: ", err)
 			}
 		}
@@ -340,19 +402,19 @@ func (sr *SupervisorRestful) StopProgram(w http.ResponseWriter, req *http.Reques
 	defer req.Body.Close()
 
 	params := mux.Vars(req)
-	success, err := sr._stopProgram(params["node"], params["name"])
+	success, err := sr._stopProgram(req, params["node"], params["name"])
 	r := map[string]bool{"success": err == nil && success}
 	w.WriteHeader(200)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(&r)
 }
 
-func (sr *SupervisorRestful) _stopProgram(node, programName string) (bool, error) {
+func (sr *SupervisorRestful) _stopProgram(req *http.Request, node, programName string) (bool, error) {
 	log.WithFields(log.Fields{"node": node, "program": programName}).Info("stop program")
 	stopArgs := StartProcessArgs{Name: programName, Wait: true}
 	result := struct{ Success bool }{false}
 	if node == "" || node == sr.supervisor.getNodeName() {
-		err := sr.supervisor.StopProcess(nil, &stopArgs, &result)
+		err := sr.supervisor.StopProcess(req, &stopArgs, &result)
 		if err != nil {
 			log.WithFields(log.Fields{"node": node, "program": programName}).Warn("failed to stop program: ", err)
 		}
@@ -386,7 +448,7 @@ func (sr *SupervisorRestful) RestartProgram(w http.ResponseWriter, req *http.Req
 	node := params["node"]
 	name := params["name"]
 
-	if _, err := sr._stopProgram(node, name); err != nil {
+	if _, err := sr._stopProgram(req, node, name); err != nil {
 		log.WithFields(log.Fields{"node": node, "program": name}).Warn("failed to stop program: ", err)
 		w.WriteHeader(500)
 		w.Header().Set("Content-Type", "application/json")
@@ -395,7 +457,7 @@ func (sr *SupervisorRestful) RestartProgram(w http.ResponseWriter, req *http.Req
 		return
 	}
 
-	if _, err := sr._startProgram(node, name); err != nil {
+	if _, err := sr._startProgram(req, node, name); err != nil {
 		log.WithFields(log.Fields{"node": node, "program": name}).Warn("failed to start program: ", err)
 		w.WriteHeader(500)
 		w.Header().Set("Content-Type", "application/json")
@@ -410,6 +472,74 @@ func (sr *SupervisorRestful) RestartProgram(w http.ResponseWriter, req *http.Req
 	_ = json.NewEncoder(w).Encode(&result)
 }
 
+// RollingRestartResult the result of the rolling restart through the restful interface
+type RollingRestartResult struct {
+	Success   bool     `json:"success"`
+	Restarted []string `json:"restarted"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// RollingRestartProgram restarts the processes of a program or group batch
+// (query parameter, default 1) at a time through the restful interface
+func (sr *SupervisorRestful) RollingRestartProgram(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	node := params["node"]
+	name := params["name"]
+	batch := 1
+	if value := req.URL.Query().Get("batch"); value != "" {
+		var err error
+		if batch, err = strconv.Atoi(value); err != nil || batch <= 0 {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("invalid batch"))
+			return
+		}
+	}
+
+	result := sr._rollingRestart(req, node, name, batch)
+	w.Header().Set("Content-Type", "application/json")
+	if result.Success {
+		w.WriteHeader(200)
+	} else {
+		w.WriteHeader(500)
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (sr *SupervisorRestful) _rollingRestart(req *http.Request, node, name string, batch int) *RollingRestartResult {
+	log.WithFields(log.Fields{"node": node, "program": name, "batch": batch}).Info("rolling restart program")
+	if node == "" || node == sr.supervisor.getNodeName() {
+		reply := struct{ Names []string }{}
+		err := sr.supervisor.RollingRestart(req, &RollingRestartArgs{Name: name, BatchSize: batch}, &reply)
+		result := &RollingRestartResult{Success: err == nil, Restarted: reply.Names}
+		if result.Restarted == nil {
+			result.Restarted = make([]string, 0)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	// restart the program on the remote supervisor
+	url, ok := sr.remoteSupervisors[node]
+	if !ok {
+		log.WithFields(log.Fields{"node": node, "program": name}).Error("Fail to find node")
+		return &RollingRestartResult{Restarted: make([]string, 0), Error: fmt.Sprintf("no node named %s", node)}
+	}
+	response, err := http.Post(fmt.Sprintf("%s/program/rollingRestart/%s/%s?batch=%d", url, node, name, batch), "application/json", nil)
+	if err != nil {
+		log.WithFields(log.Fields{"node": node, "program": name}).Warn("failed to rolling restart program on remote node: ", err)
+		return &RollingRestartResult{Restarted: make([]string, 0), Error: err.Error()}
+	}
+	defer response.Body.Close()
+	result := &RollingRestartResult{}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		log.WithFields(log.Fields{"node": node, "program": name}).Warn("failed to decode response from remote node: ", err)
+		return &RollingRestartResult{Restarted: make([]string, 0), Error: err.Error()}
+	}
+	return result
+}
+
 // StopPrograms stop programs through the restful interface
 func (sr *SupervisorRestful) StopPrograms(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
@@ -424,7 +554,7 @@ func (sr *SupervisorRestful) StopPrograms(w http.ResponseWriter, req *http.Reque
 		_, _ = w.Write([]byte("not a valid request"))
 	} else {
 		for _, program := range programs {
-			if _, err := sr._stopProgram(program.Node, program.Program); err != nil {
+			if _, err := sr._stopProgram(req, program.Node, program.Program); err != nil {
 				log.WithField("program", program).Warn("failed to stop program: ", err)
 			}
 		}
@@ -439,6 +569,10 @@ func (sr *SupervisorRestful) ReadStdoutLog(w http.ResponseWriter, req *http.Requ
 	params := mux.Vars(req)
 	node := params["node"]
 	programName := params["name"]
+	if isFollowLogRequest(req) {
+		sr.followLog(w, req, node, programName, "stdout")
+		return
+	}
 	if node == "" || node == sr.supervisor.getNodeName() {
 		readInfo := ProcessLogReadInfo{Name: programName, Offset: 0, Length: 0}
 		reply := struct{ LogData string }{LogData: ""}
@@ -481,11 +615,143 @@ func (sr *SupervisorRestful) readRemoteLog(node, programName, logType string) (s
 	return string(data), nil
 }
 
+// LogSearchResult the result of searching the program logs
+type LogSearchResult struct {
+	Matches   []types.LogMatch `json:"matches"`
+	Truncated bool             `json:"truncated"`
+	// key: node name, value: the error of searching on the node
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// SearchLog searches the current and rotated log files of the programs on
+// the local and remote nodes with following query parameters:
+//
+//   - q: the text to search
+//   - regexp: true if q is a regular expression
+//   - ignore_case: true to ignore the case of q
+//   - programs: comma separated programs, all the programs if not set
+//   - stream: stdout or stderr, both if not set
+//   - since: RFC3339 time, unix seconds or a duration like "1h" before now
+//   - max: the max number of matched lines of each node, default is 1000
+//   - nodes: comma separated nodes to search, all the nodes if not set
+func (sr *SupervisorRestful) SearchLog(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	query := req.URL.Query()
+	args := LogSearchArgs{Query: query.Get("q"),
+		Regexp:     query.Get("regexp") == "true",
+		IgnoreCase: query.Get("ignore_case") == "true",
+		Stream:     query.Get("stream"),
+		Since:      query.Get("since")}
+	if programs := query.Get("programs"); programs != "" {
+		args.Programs = strings.Split(programs, ",")
+	}
+	if max := query.Get("max"); max != "" {
+		args.MaxMatches, _ = strconv.Atoi(max)
+	}
+	nodes := make(map[string]bool)
+	if query.Get("nodes") != "" {
+		for _, node := range strings.Split(query.Get("nodes"), ",") {
+			nodes[strings.TrimSpace(node)] = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	result := LogSearchResult{Matches: make([]types.LogMatch, 0), Errors: make(map[string]string)}
+	nodeName := sr.supervisor.getNodeName()
+	// the programs are not found if all the searched nodes have no such programs
+	searchedNodes := 0
+	badNames := 0
+	badNameErr := ""
+	if len(nodes) == 0 || nodes[nodeName] {
+		reply := struct {
+			Matches   []types.LogMatch
+			Truncated bool
+		}{}
+		err := sr.supervisor.SearchProcessLogs(req, &args, &reply)
+		if err != nil && !strings.HasPrefix(err.Error(), "BAD_NAME") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		searchedNodes++
+		if err != nil {
+			// the programs may be on the other nodes
+			badNames++
+			badNameErr = err.Error()
+		} else {
+			result.Matches = append(result.Matches, reply.Matches...)
+			result.Truncated = reply.Truncated
+		}
+	}
+	// the remote nodes don't search their remote nodes again
+	if query.Get("local") != "true" {
+		remoteNodes := make([]string, 0)
+		for node := range sr.remoteSupervisors {
+			if len(nodes) == 0 || nodes[node] {
+				remoteNodes = append(remoteNodes, node)
+			}
+		}
+		sort.Strings(remoteNodes)
+		for _, node := range remoteNodes {
+			searchedNodes++
+			remoteResult, err := sr.searchRemoteLog(node, query)
+			if err != nil && strings.Contains(err.Error(), "BAD_NAME") {
+				badNames++
+				continue
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"node": node}).Warn("failed to search log on remote node: ", err)
+				result.Errors[node] = err.Error()
+				continue
+			}
+			result.Matches = append(result.Matches, remoteResult.Matches...)
+			result.Truncated = result.Truncated || remoteResult.Truncated
+		}
+	}
+	if searchedNodes > 0 && badNames == searchedNodes {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": badNameErr})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(&result)
+}
+
+func (sr *SupervisorRestful) searchRemoteLog(node string, query url.Values) (*LogSearchResult, error) {
+	remoteQuery := url.Values{}
+	for name, values := range query {
+		remoteQuery[name] = values
+	}
+	remoteQuery.Del("nodes")
+	remoteQuery.Set("local", "true")
+	response, err := http.Get(sr.remoteSupervisors[node] + "/program/log/search?" + remoteQuery.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("status code %d: %s", response.StatusCode, strings.TrimSpace(string(b)))
+	}
+	result := LogSearchResult{}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response from remote node: %v", err)
+	}
+	for i := range result.Matches {
+		result.Matches[i].Node = node
+	}
+	return &result, nil
+}
+
 func (sr *SupervisorRestful) ReadStderrLog(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	params := mux.Vars(req)
 	node := params["node"]
 	programName := params["name"]
+	if isFollowLogRequest(req) {
+		sr.followLog(w, req, node, programName, "stderr")
+		return
+	}
 	if node == "" || node == sr.supervisor.getNodeName() {
 		readInfo := ProcessLogReadInfo{Name: programName, Offset: 0, Length: 0}
 		reply := struct{ LogData string }{LogData: ""}
@@ -515,7 +781,7 @@ func (sr *SupervisorRestful) Shutdown(w http.ResponseWriter, req *http.Request)
 
 	reply := struct{ Ret bool }{false}
 	if node == "" || node == sr.supervisor.getNodeName() {
-		if err := sr.supervisor.Shutdown(nil, nil, &reply); err != nil {
+		if err := sr.supervisor.Shutdown(req, nil, &reply); err != nil {
 			log.Warn("shutdown error: ", err)
 		}
 		result := map[string]bool{"success": reply.Ret}
@@ -564,8 +830,8 @@ func (sr *SupervisorRestful) Reload(w http.ResponseWriter, req *http.Request) {
 	node := params["node"]
 
 	if node == "" || node == sr.supervisor.getNodeName() {
-		log.Info("reload supervisor configuration")
-		_, _, _, err := sr.supervisor.Reload(false)
+		reply := types.ReloadConfigResult{}
+		err := sr.supervisor.ReloadConfig(req, &struct{}{}, &reply)
 		if err != nil {
 			log.Warn("reload error: ", err)
 		}
@@ -607,3 +873,57 @@ func (sr *SupervisorRestful) reloadRemote(node string) (bool, error) {
 	}
 	return result.Success, nil
 }
+
+// ReadAuditLog reads the last records of the audit log of the local
+// supervisord with following query parameters:
+//   - lines: the max number of returned records, default is 100
+//   - principal: only the records of the user or the token
+//   - operation: only the records of the operation like "stopProcess"
+//   - target: only the records of the program, the group like "group:*" or "all"
+//   - since: RFC3339 time, unix seconds or a duration like "1h" before now
+func (sr *SupervisorRestful) ReadAuditLog(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	query := req.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	file := sr.supervisor.getAuditLogFile()
+	if file == "" {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "the audit log is not enabled"})
+		return
+	}
+	lines := 100
+	if value := query.Get("lines"); value != "" {
+		var err error
+		if lines, err = strconv.Atoi(value); err != nil || lines <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid lines " + value})
+			return
+		}
+	}
+	since, err := parseLogSearchSince(query.Get("since"), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid since " + query.Get("since")})
+		return
+	}
+	principal, operation, target := query.Get("principal"), query.Get("operation"), query.Get("target")
+	records, err := readAuditLog(file, func(record *AuditRecord) bool {
+		if (principal != "" && record.Principal != principal) ||
+			(operation != "" && !strings.EqualFold(record.Operation, operation)) ||
+			(target != "" && record.Target != target) {
+			return false
+		}
+		if t, err := time.Parse(time.RFC3339Nano, record.Time); err == nil && t.Before(since) {
+			return false
+		}
+		return true
+	}, lines)
+	if err != nil {
+		log.WithFields(log.Fields{"file": file}).Warn("failed to read audit log: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(&AuditLogResult{Records: records})
+}
@@ -10,7 +10,9 @@ import (
 
 func (s *Supervisor) checkRequiredResources() error {
 	if minfds, vErr := s.getMinRequiredRes("minfds"); vErr == nil {
-		return s.checkMinLimit(syscall.RLIMIT_NOFILE, "NOFILE", minfds)
+		if err := s.checkMinLimit(syscall.RLIMIT_NOFILE, "NOFILE", minfds); err != nil {
+			return err
+		}
 	}
 	if minprocs, vErr := s.getMinRequiredRes("minprocs"); vErr == nil {
 		//RPROC = 6
@@ -50,7 +52,7 @@ func (s *Supervisor) checkMinLimit(resource int, resourceName string, minRequire
 	}
 
 	limit.Cur = limit.Max
-	if syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit) != nil {
+	if syscall.Setrlimit(resource, &limit) != nil {
 		return fmt.Errorf(fmt.Sprintf("fail to set the %s to %d", resourceName, limit.Cur))
 	}
 	return nil
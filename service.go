@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/kardianos/service"
 	log "github.com/sirupsen/logrus"
@@ -30,10 +31,25 @@ func (p *program) Start(s service.Service) error {
 
 func (p *program) run() {
 	log.SetOutput(os.Stdout)
-	if options.Daemon {
+	// the daemon may change to another directory, so the files given by
+	// the relative paths are found from the current directory
+	if configFile, err := findSupervisordConf(); err == nil {
+		options.Configuration = configFile
+	}
+	if envFile, err := filepath.Abs(options.EnvFile); err == nil && options.EnvFile != "" {
+		options.EnvFile = envFile
+	}
+	// supervisord re-executed by upgrade keeps the pid of the daemon
+	if isDaemonMode(options.Configuration) && !hasUpgradeState() {
 		logFile := getSupervisordLogFile(options.Configuration)
-		p.supervisor, _ = initServer()
+		directory := getSupervisordDirectory(options.Configuration)
 		Daemonize(logFile, func() {
+			if directory != "" {
+				if err := os.Chdir(directory); err != nil {
+					log.WithFields(log.Fields{"directory": directory}).Fatal("fail to change the directory: ", err)
+				}
+			}
+			p.supervisor, _ = initServer()
 			p.supervisor.WaitForExit()
 		})
 	} else {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+// unixSocketPermission the mode and the owner of the socket file of the
+// unix_http_server
+type unixSocketPermission struct {
+	mode os.FileMode
+	// -1 if the owner is not changed
+	uid int
+	gid int
+}
+
+// newUnixSocketPermission gets the chmod and the chown of the
+// unix_http_server section. The default mode is 0700 and the owner is not
+// changed if chown is not set
+func newUnixSocketPermission(entry *config.Entry) (*unixSocketPermission, error) {
+	perm := &unixSocketPermission{mode: 0700, uid: -1, gid: -1}
+	chmod := strings.TrimSpace(entry.GetString("chmod", "0700"))
+	mode, err := strconv.ParseUint(chmod, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("invalid chmod %s, it should be an octal number like 0700", chmod)
+	}
+	perm.mode = os.FileMode(mode)
+	chown := strings.TrimSpace(entry.GetString("chown", ""))
+	if chown == "" {
+		return perm, nil
+	}
+	userName, groupName, hasGroup := strings.Cut(chown, ":")
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chown %s: %v", chown, err)
+	}
+	if perm.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("invalid chown %s: %v", chown, err)
+	}
+	gid := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chown %s: %v", chown, err)
+		}
+		gid = g.Gid
+	}
+	if perm.gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("invalid chown %s: %v", chown, err)
+	}
+	return perm, nil
+}
+
+// apply changes the mode and the owner of the socket file, nothing is done
+// for the socket in the abstract namespace which has no file
+func (perm *unixSocketPermission) apply(file string) error {
+	if strings.HasPrefix(file, "@") {
+		return nil
+	}
+	if err := os.Chmod(file, perm.mode); err != nil {
+		return err
+	}
+	if perm.uid == -1 {
+		return nil
+	}
+	return os.Chown(file, perm.uid, perm.gid)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,6 +37,10 @@ type Supervisor struct {
 	logger     logger.Logger    // logger manager
 	lock       sync.Mutex
 	restarting atomic.Bool // if supervisor is in restarting state
+	// the users and roles of the http servers, nil if they are not configured
+	access atomic.Pointer[accessControl]
+	// the audit log of the control operations, nil if it is not configured
+	auditLog atomic.Pointer[auditLog]
 }
 
 // StartProcessArgs arguments for starting a process
@@ -42,6 +49,12 @@ type StartProcessArgs struct {
 	Wait bool   `default:"true"` // Wait the program starting finished
 }
 
+// RollingRestartArgs arguments for the rolling restart of a program or group
+type RollingRestartArgs struct {
+	Name      string // program, group or program with numprocs
+	BatchSize int    // how many processes are restarted at a time
+}
+
 // ProcessStdin  process stdin from client
 type ProcessStdin struct {
 	Name  string // program name
@@ -81,6 +94,17 @@ type ProcessLogReadInfo struct {
 	Length int    // the length of log to read
 }
 
+// LogSearchArgs the arguments of searching the program logs
+type LogSearchArgs struct {
+	Query      string   // the text to search
+	Regexp     bool     // the query is a regular expression
+	IgnoreCase bool     // ignore the case of the query
+	Programs   []string // the programs to search like "web" or "group:*", all the programs if empty
+	Stream     string   // stdout, stderr or empty for both
+	Since      string   // RFC3339 time, unix seconds or a duration like "1h" before now
+	MaxMatches int      // the max number of returned lines, default is 1000
+}
+
 // ProcessTailLog the output of tail the program log
 type ProcessTailLog struct {
 	LogData  string
@@ -162,16 +186,19 @@ func (s *Supervisor) ReadLog(r *http.Request, args *LogReadInfo, reply *struct{
 }
 
 // ClearLog clear the supervisor log
-func (s *Supervisor) ClearLog(r *http.Request, args *struct{}, reply *struct{ Ret bool }) error {
-	err := s.logger.ClearAllLogFile()
+func (s *Supervisor) ClearLog(r *http.Request, args *struct{}, reply *struct{ Ret bool }) (err error) {
+	defer func() { s.audit(r, "clearLog", "", "", err) }()
+	err = s.logger.ClearAllLogFile()
 	reply.Ret = err == nil
 	return err
 }
 
 // Shutdown the supervisor
-func (s *Supervisor) Shutdown(r *http.Request, args *struct{}, reply *struct{ Ret bool }) error {
+func (s *Supervisor) Shutdown(r *http.Request, args *struct{}, reply *struct{ Ret bool }) (err error) {
+	defer func() { s.audit(r, "shutdown", "", "", err) }()
 	reply.Ret = true
 	log.Info("received rpc request to stop all processes & exit")
+	events.EmitEvent(events.CreateSupervisorStateChangeStopping())
 	s.procMgr.StopAllProcesses()
 	go func() {
 		time.Sleep(1 * time.Second)
@@ -181,7 +208,8 @@ func (s *Supervisor) Shutdown(r *http.Request, args *struct{}, reply *struct{ Re
 }
 
 // Restart the supervisor
-func (s *Supervisor) Restart(r *http.Request, args *struct{}, reply *struct{ Ret bool }) error {
+func (s *Supervisor) Restart(r *http.Request, args *struct{}, reply *struct{ Ret bool }) (err error) {
+	defer func() { s.audit(r, "restart", "", "", err) }()
 	log.Info("Receive instruction to restart")
 	s.restarting.Store(true)
 	reply.Ret = true
@@ -205,7 +233,7 @@ func getProcessInfo(nodename string, proc *process.Process) *types.ProcessInfo {
 		Now:           int(time.Now().Unix()),
 		State:         int(proc.GetState()),
 		Statename:     proc.GetState().String(),
-		Spawnerr:      "",
+		Spawnerr:      proc.GetSpawnError(),
 		Exitstatus:    proc.GetExitstatus(),
 		Logfile:       proc.GetStdoutLogfile(),
 		StdoutLogfile: proc.GetStdoutLogfile(),
@@ -248,19 +276,31 @@ func (s *Supervisor) GetProcessInfo(r *http.Request, args *struct{ Name string }
 	return nil
 }
 
+// GetProcessHistory get the past runs of one program, the latest run is the last one
+func (s *Supervisor) GetProcessHistory(r *http.Request, args *struct{ Name string }, reply *struct{ Runs []types.ProcessRun }) error {
+	proc := s.procMgr.Find(args.Name)
+	if proc == nil {
+		return fmt.Errorf("BAD_NAME no process named %s", args.Name)
+	}
+
+	reply.Runs = proc.GetRunHistory()
+	return nil
+}
+
 // StartProcess start the given program
-func (s *Supervisor) StartProcess(r *http.Request, args *StartProcessArgs, reply *struct{ Success bool }) error {
+func (s *Supervisor) StartProcess(r *http.Request, args *StartProcessArgs, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "startProcess", args.Name, "", err) }()
 	procs := s.procMgr.FindMatch(args.Name)
 
 	if len(procs) <= 0 {
 		return fmt.Errorf("fail to find process %s", args.Name)
 	}
-	for _, proc := range procs {
-		proc.Start(args.Wait)
-	}
+	s.setDesiredState(process.DesiredStateStarted, "start", procs)
+	s.procMgr.StartProcesses(procs, args.Wait)
 
+	// the program may wait for its dependencies if the start is not waited
 	for _, proc := range procs {
-		if !proc.IsRunning() {
+		if args.Wait && !proc.IsRunning() {
 			reply.Success = false
 			return fmt.Errorf("fail to start process %s", args.Name)
 		}
@@ -273,12 +313,14 @@ func (s *Supervisor) StartProcess(r *http.Request, args *StartProcessArgs, reply
 // StartAllProcesses start all the programs
 func (s *Supervisor) StartAllProcesses(r *http.Request, args *struct {
 	Wait bool `default:"true"`
-}, reply *struct{ RPCTaskResults []RPCTaskResult }) error {
+}, reply *struct{ RPCTaskResults []RPCTaskResult }) (err error) {
+	defer func() { s.audit(r, "startAllProcesses", "all", "", err) }()
 
+	s.setDesiredState(process.DesiredStateStarted, "start_all", s.findProcesses(func(proc *process.Process) bool { return true }))
 	finishedProcCh := make(chan *process.Process)
 
 	n := s.procMgr.AsyncForEachProcess(func(proc *process.Process) {
-		proc.Start(args.Wait)
+		s.procMgr.StartProcess(proc, args.Wait)
 	}, finishedProcCh)
 
 	for i := 0; i < n; i++ {
@@ -297,38 +339,30 @@ func (s *Supervisor) StartAllProcesses(r *http.Request, args *struct {
 }
 
 // StartProcessGroup start all the processes in one group
-func (s *Supervisor) StartProcessGroup(r *http.Request, args *StartProcessArgs, reply *struct{ AllProcessInfo []types.ProcessInfo }) error {
+func (s *Supervisor) StartProcessGroup(r *http.Request, args *StartProcessArgs, reply *struct{ AllProcessInfo []types.ProcessInfo }) (err error) {
+	defer func() { s.audit(r, "startProcessGroup", args.Name+":*", "", err) }()
 	log.WithFields(log.Fields{"group": args.Name}).Info("start process group")
-	finishedProcCh := make(chan *process.Process)
-
-	n := s.procMgr.AsyncForEachProcess(func(proc *process.Process) {
-		if proc.GetGroup() == args.Name {
-			proc.Start(args.Wait)
-		}
-	}, finishedProcCh)
-
-	for range n {
-		proc, ok := <-finishedProcCh
-		if ok && proc.GetGroup() == args.Name {
-			reply.AllProcessInfo = append(reply.AllProcessInfo, *getProcessInfo(s.getNodeName(), proc))
-		}
+	procs := s.findProcesses(func(proc *process.Process) bool { return proc.GetGroup() == args.Name })
+	s.setDesiredState(process.DesiredStateStarted, "start_group", procs)
+	s.procMgr.StartProcesses(procs, args.Wait)
+	for _, proc := range procs {
+		reply.AllProcessInfo = append(reply.AllProcessInfo, *getProcessInfo(s.getNodeName(), proc))
 	}
-
 	return nil
 }
 
 // StopProcess stop given program
-func (s *Supervisor) StopProcess(r *http.Request, args *StartProcessArgs, reply *struct{ Success bool }) error {
+func (s *Supervisor) StopProcess(r *http.Request, args *StartProcessArgs, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "stopProcess", args.Name, "", err) }()
 	log.WithFields(log.Fields{"program": args.Name}).Info("stop process")
 	procs := s.procMgr.FindMatch(args.Name)
 	if len(procs) <= 0 {
 		return fmt.Errorf("fail to find process %s", args.Name)
 	}
+	s.setDesiredState(process.DesiredStateStopped, "stop", procs)
+	s.procMgr.StopProcesses(procs, args.Wait)
 	for _, proc := range procs {
-		proc.Stop(args.Wait)
-	}
-	for _, proc := range procs {
-		if proc.IsRunning() {
+		if args.Wait && proc.IsRunning() {
 			reply.Success = false
 			return fmt.Errorf("fail to stop process %s", args.Name)
 		}
@@ -338,20 +372,14 @@ func (s *Supervisor) StopProcess(r *http.Request, args *StartProcessArgs, reply
 }
 
 // StopProcessGroup stop all processes in one group
-func (s *Supervisor) StopProcessGroup(r *http.Request, args *StartProcessArgs, reply *struct{ AllProcessInfo []types.ProcessInfo }) error {
+func (s *Supervisor) StopProcessGroup(r *http.Request, args *StartProcessArgs, reply *struct{ AllProcessInfo []types.ProcessInfo }) (err error) {
+	defer func() { s.audit(r, "stopProcessGroup", args.Name+":*", "", err) }()
 	log.WithFields(log.Fields{"group": args.Name}).Info("stop process group")
-	finishedProcCh := make(chan *process.Process)
-	n := s.procMgr.AsyncForEachProcess(func(proc *process.Process) {
-		if proc.GetGroup() == args.Name {
-			proc.Stop(args.Wait)
-		}
-	}, finishedProcCh)
-
-	for i := 0; i < n; i++ {
-		proc, ok := <-finishedProcCh
-		if ok && proc.GetGroup() == args.Name {
-			reply.AllProcessInfo = append(reply.AllProcessInfo, *getProcessInfo(s.getNodeName(), proc))
-		}
+	procs := s.findProcesses(func(proc *process.Process) bool { return proc.GetGroup() == args.Name })
+	s.setDesiredState(process.DesiredStateStopped, "stop_group", procs)
+	s.procMgr.StopProcesses(procs, args.Wait)
+	for _, proc := range procs {
+		reply.AllProcessInfo = append(reply.AllProcessInfo, *getProcessInfo(s.getNodeName(), proc))
 	}
 	return nil
 }
@@ -359,11 +387,13 @@ func (s *Supervisor) StopProcessGroup(r *http.Request, args *StartProcessArgs, r
 // StopAllProcesses stop all programs managed by supervisor
 func (s *Supervisor) StopAllProcesses(r *http.Request, args *struct {
 	Wait bool `default:"true"`
-}, reply *struct{ RPCTaskResults []RPCTaskResult }) error {
+}, reply *struct{ RPCTaskResults []RPCTaskResult }) (err error) {
+	defer func() { s.audit(r, "stopAllProcesses", "all", "", err) }()
+	s.setDesiredState(process.DesiredStateStopped, "stop_all", s.findProcesses(func(proc *process.Process) bool { return true }))
 	finishedProcCh := make(chan *process.Process)
 
 	n := s.procMgr.AsyncForEachProcess(func(proc *process.Process) {
-		proc.Stop(args.Wait)
+		s.procMgr.StopProcess(proc, args.Wait)
 	}, finishedProcCh)
 
 	for i := 0; i < n; i++ {
@@ -381,8 +411,97 @@ func (s *Supervisor) StopAllProcesses(r *http.Request, args *struct {
 	return nil
 }
 
+// findProcesses finds the programs matching the filter
+func (s *Supervisor) findProcesses(filter func(proc *process.Process) bool) []*process.Process {
+	procs := make([]*process.Process, 0)
+	s.procMgr.ForEachProcess(func(proc *process.Process) {
+		if filter(proc) {
+			procs = append(procs, proc)
+		}
+	})
+	return procs
+}
+
+// setDesiredState records the state of the programs wanted by the user, so
+// they are not started or stopped against the user intent after supervisord
+// is restarted
+func (s *Supervisor) setDesiredState(state string, action string, procs []*process.Process) {
+	names := make([]string, 0, len(procs))
+	for _, proc := range procs {
+		names = append(names, proc.GetName())
+	}
+	s.procMgr.GetStateStore().Set(state, action, names...)
+}
+
+// ResetProcessState forgets the desired state of the programs recorded by
+// the manual start and stop, so their autostart takes effect again when
+// supervisord is restarted. The states of all the programs are forgotten if
+// the program "all" is given
+func (s *Supervisor) ResetProcessState(r *http.Request, args *struct{ Names []string }, reply *struct{ Names []string }) (err error) {
+	defer func() { s.audit(r, "resetProcessState", strings.Join(args.Names, ","), "", err) }()
+	stateStore := s.procMgr.GetStateStore()
+	if stateStore == nil {
+		return fmt.Errorf("FAILED the program states are not persisted")
+	}
+	names := make([]string, 0)
+	for _, name := range args.Names {
+		if name == "all" {
+			names = nil
+			break
+		}
+		procs := s.procMgr.FindMatch(name)
+		if len(procs) == 0 {
+			// the program may be removed from the configuration
+			names = append(names, name)
+		}
+		for _, proc := range procs {
+			names = append(names, proc.GetName())
+		}
+	}
+	reply.Names = stateStore.Reset(names...)
+	sort.Strings(reply.Names)
+	log.WithFields(log.Fields{"programs": strings.Join(reply.Names, ",")}).Info("reset the desired state of programs")
+	return nil
+}
+
+// RollingRestart restarts the processes of the program, the group or the
+// program with numprocs BatchSize at a time. The next batch is restarted only
+// after the restarted processes are running and ready, the rolling restart
+// is aborted if a process fails to start
+func (s *Supervisor) RollingRestart(r *http.Request, args *RollingRestartArgs, reply *struct{ Names []string }) (err error) {
+	defer func() { s.audit(r, "rollingRestart", args.Name, fmt.Sprintf("batch:%d", args.BatchSize), err) }()
+	procs := s.procMgr.FindMatch(args.Name)
+	if len(procs) == 0 && !strings.Contains(args.Name, ":") {
+		// the processes of the program with numprocs are in the group of the program
+		procs = s.procMgr.FindMatch(args.Name + ":*")
+	}
+	if len(procs) == 0 {
+		return fmt.Errorf("BAD_NAME no process named %s", args.Name)
+	}
+	log.WithFields(log.Fields{"program": args.Name, "batch": args.BatchSize}).Info("rolling restart programs")
+	names, err := s.procMgr.RollingRestart(procs, args.BatchSize)
+	reply.Names = names
+	// only the restarted processes are desired to be started
+	restartedNames := make(map[string]bool)
+	for _, name := range names {
+		restartedNames[name] = true
+	}
+	restarted := make([]*process.Process, 0, len(names))
+	for _, proc := range procs {
+		if restartedNames[proc.GetName()] {
+			restarted = append(restarted, proc)
+		}
+	}
+	s.setDesiredState(process.DesiredStateStarted, "restart", restarted)
+	if err != nil {
+		return fmt.Errorf("FAILED %v", err)
+	}
+	return nil
+}
+
 // SignalProcess send a signal to running program
-func (s *Supervisor) SignalProcess(r *http.Request, args *types.ProcessSignal, reply *struct{ Success bool }) error {
+func (s *Supervisor) SignalProcess(r *http.Request, args *types.ProcessSignal, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "signalProcess", args.Name, "signal:"+args.Signal, err) }()
 	procs := s.procMgr.FindMatch(args.Name)
 	if len(procs) <= 0 {
 		reply.Success = false
@@ -396,7 +515,8 @@ func (s *Supervisor) SignalProcess(r *http.Request, args *types.ProcessSignal, r
 }
 
 // SignalProcessGroup send signal to all processes in one group
-func (s *Supervisor) SignalProcessGroup(r *http.Request, args *types.ProcessSignal, reply *struct{ AllProcessInfo []types.ProcessInfo }) error {
+func (s *Supervisor) SignalProcessGroup(r *http.Request, args *types.ProcessSignal, reply *struct{ AllProcessInfo []types.ProcessInfo }) (err error) {
+	defer func() { s.audit(r, "signalProcessGroup", args.Name+":*", "signal:"+args.Signal, err) }()
 	s.procMgr.ForEachProcess(func(proc *process.Process) {
 		if proc.GetGroup() == args.Name {
 			_ = proc.Signal(args.Signal, true)
@@ -412,7 +532,8 @@ func (s *Supervisor) SignalProcessGroup(r *http.Request, args *types.ProcessSign
 }
 
 // SignalAllProcesses send signal to all the processes in the supervisor
-func (s *Supervisor) SignalAllProcesses(r *http.Request, args *types.ProcessSignal, reply *struct{ AllProcessInfo []types.ProcessInfo }) error {
+func (s *Supervisor) SignalAllProcesses(r *http.Request, args *types.ProcessSignal, reply *struct{ AllProcessInfo []types.ProcessInfo }) (err error) {
+	defer func() { s.audit(r, "signalAllProcesses", "all", "signal:"+args.Signal, err) }()
 	s.procMgr.ForEachProcess(func(proc *process.Process) {
 		_ = proc.Signal(args.Signal, true)
 	})
@@ -423,7 +544,8 @@ func (s *Supervisor) SignalAllProcesses(r *http.Request, args *types.ProcessSign
 }
 
 // SendProcessStdin send data to program through stdin
-func (s *Supervisor) SendProcessStdin(r *http.Request, args *ProcessStdin, reply *struct{ Success bool }) error {
+func (s *Supervisor) SendProcessStdin(r *http.Request, args *ProcessStdin, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "sendProcessStdin", args.Name, fmt.Sprintf("bytes:%d", len(args.Chars)), err) }()
 	proc := s.procMgr.Find(args.Name)
 	if proc == nil {
 		log.WithFields(log.Fields{"program": args.Name}).Error("program does not exist")
@@ -433,7 +555,7 @@ func (s *Supervisor) SendProcessStdin(r *http.Request, args *ProcessStdin, reply
 		log.WithFields(log.Fields{"program": args.Name}).Error("program does not run")
 		return fmt.Errorf("NOT_RUNNING")
 	}
-	err := proc.SendProcessStdin(args.Chars)
+	err = proc.SendProcessStdin(args.Chars)
 	if err == nil {
 		reply.Success = true
 	} else {
@@ -443,7 +565,8 @@ func (s *Supervisor) SendProcessStdin(r *http.Request, args *ProcessStdin, reply
 }
 
 // SendRemoteCommEvent emit a remote communication event
-func (s *Supervisor) SendRemoteCommEvent(r *http.Request, args *RemoteCommEvent, reply *struct{ Success bool }) error {
+func (s *Supervisor) SendRemoteCommEvent(r *http.Request, args *RemoteCommEvent, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "sendRemoteCommEvent", "", "type:"+args.Type, err) }()
 	events.EmitEvent(events.NewRemoteCommunicationEvent(args.Type, args.Data))
 	reply.Success = true
 	return nil
@@ -457,7 +580,15 @@ func (s *Supervisor) Reload(restart bool) (addedGroup []string, changedGroup []s
 	prevPrograms := s.config.GetProgramNames()
 	prevProgGroup := s.config.ProgramGroup.Clone()
 
-	loadedPrograms, err := s.config.Load()
+	// the users and roles are checked before the configuration is applied
+	var access *accessControl
+	loadedPrograms, err := s.config.Load(func(cfg *config.Config) error {
+		var accessErr error
+		if access, accessErr = newAccessControl(cfg); accessErr != nil {
+			return fmt.Errorf("failed to load users and roles: %v", accessErr)
+		}
+		return nil
+	})
 
 	if err != nil {
 		log.Error("failed to load config: ", err)
@@ -466,6 +597,8 @@ func (s *Supervisor) Reload(restart bool) (addedGroup []string, changedGroup []s
 
 	log.WithFields(log.Fields{"programs": strings.Join(loadedPrograms, ",")}).Info("loaded programs")
 
+	s.access.Store(access)
+
 	if checkErr := s.checkRequiredResources(); checkErr != nil {
 		log.Error(checkErr)
 		os.Exit(1)
@@ -473,17 +606,42 @@ func (s *Supervisor) Reload(restart bool) (addedGroup []string, changedGroup []s
 	}
 
 	s.setSupervisordInfo()
+	s.setStateStore()
+	s.setAuditLog()
+	if restart {
+		s.startHTTPServer()
+		closeInheritedListeners()
+		// like python supervisord, the privileges are dropped after the
+		// http servers are listening and the log and pid files are opened
+		if err := s.setUmask(); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		if err := s.dropPrivileges(); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}
+	s.setEnvironment()
+	s.setChildLogSettings(restart)
 	s.startEventListeners()
+	if restart {
+		s.procMgr.SetInheritedSockets(takeInheritedSockets())
+	}
 	s.createPrograms(prevPrograms)
 	if restart {
-		s.startHTTPServer()
+		s.procMgr.AdoptProcesses(consumeUpgraded())
 	}
 	s.startAutoStartPrograms()
+	if restart {
+		events.EmitEvent(events.CreateSupervisorStateChangeRunning())
+	}
 
 	removedPrograms := util.Sub(prevPrograms, loadedPrograms)
 	for _, removedProg := range removedPrograms {
 		log.WithFields(log.Fields{"program": removedProg}).Info("the program is removed and will be stopped")
 		s.config.RemoveProgram(removedProg)
+		s.procMgr.GetStateStore().Reset(removedProg)
 		proc := s.procMgr.Remove(removedProg)
 		if proc != nil {
 			proc.Stop(false)
@@ -495,6 +653,12 @@ func (s *Supervisor) Reload(restart bool) (addedGroup []string, changedGroup []s
 
 }
 
+// getAccessControl gets the users and roles of the http servers, nil if they
+// are not configured
+func (s *Supervisor) getAccessControl() *accessControl {
+	return s.access.Load()
+}
+
 // WaitForExit waits for supervisord to exit
 func (s *Supervisor) WaitForExit() {
 	for {
@@ -521,6 +685,7 @@ func (s *Supervisor) createPrograms(prevPrograms []string) {
 	for _, p := range removedPrograms {
 		s.procMgr.Remove(p)
 	}
+	s.procMgr.CloseUnusedSockets()
 }
 
 func (s *Supervisor) startAutoStartPrograms() {
@@ -547,7 +712,10 @@ func parseRemoteSupervisors(remotes string) map[string]string {
 		namePorts := strings.SplitN(field, ":", 2)
 		if len(namePorts) == 2 {
 			name := strings.TrimSpace(namePorts[0])
-			port := "http://" + strings.TrimSpace(namePorts[1])
+			port := strings.TrimSpace(namePorts[1])
+			if !strings.Contains(port, "://") {
+				port = "http://" + port
+			}
 			remoteSupervisors[name] = port
 
 		}
@@ -562,12 +730,17 @@ func (s *Supervisor) startHTTPServer() {
 		addr := httpServerConfig.GetString("port", "")
 
 		if addr != "" {
+			serverTLS, err := newServerTLS(httpServerConfig)
+			if err != nil {
+				log.WithFields(log.Fields{"addr": addr}).Fatal("fail to enable tls: ", err)
+			}
 			cond := sync.NewCond(&sync.Mutex{})
 			cond.L.Lock()
 			defer cond.L.Unlock()
 			go s.xmlRPC.StartInetHTTPServer(httpServerConfig.GetString("username", ""),
 				httpServerConfig.GetString("password", ""),
 				addr,
+				serverTLS,
 				s,
 				parseRemoteSupervisors(httpServerConfig.GetString("remotes", "")),
 				func() {
@@ -584,12 +757,17 @@ func (s *Supervisor) startHTTPServer() {
 		env := config.NewStringExpression("here", s.config.GetConfigFileDir())
 		sockFile, err := env.Eval(httpServerConfig.GetString("file", "/tmp/supervisord.sock"))
 		if err == nil {
+			socketPerm, err := newUnixSocketPermission(httpServerConfig)
+			if err != nil {
+				log.WithFields(log.Fields{"file": sockFile}).Fatal("invalid unix socket permission: ", err)
+			}
 			cond := sync.NewCond(&sync.Mutex{})
 			cond.L.Lock()
 			defer cond.L.Unlock()
 			go s.xmlRPC.StartUnixHTTPServer(httpServerConfig.GetString("username", ""),
 				httpServerConfig.GetString("password", ""),
 				sockFile,
+				socketPerm,
 				s,
 				func() {
 					cond.L.Lock()
@@ -616,16 +794,33 @@ func (s *Supervisor) setSupervisordInfo() {
 			return
 		}
 		logEventEmitter := logger.NewNullLogEventEmitter()
+		prevLogger := s.logger
 		s.logger = logger.NewNullLogger(logEventEmitter)
 		if err == nil {
 			logfileMaxbytes := int64(supervisordConf.GetBytes("logfile_maxbytes", 50*1024*1024))
 			logfileBackups := supervisordConf.GetInt("logfile_backups", 10)
 			loglevel := supervisordConf.GetString("loglevel", "info")
 			props := make(map[string]string)
+			for _, name := range []string{logger.PropRotateInterval, logger.PropBackupName, logger.PropCompress} {
+				if value := strings.TrimSpace(supervisordConf.GetString(name, "")); value != "" {
+					props[name] = value
+				}
+			}
+			if maxTotalBytes := supervisordConf.GetBytes(logger.PropMaxTotalBytes, 0); maxTotalBytes > 0 {
+				props[logger.PropMaxTotalBytes] = strconv.Itoa(maxTotalBytes)
+			}
+			if err := logger.ValidateRotateProps(props); err != nil {
+				fmt.Println("invalid supervisord log rotation options:", err)
+				props = make(map[string]string)
+			}
 			s.logger = logger.NewLogger("supervisord", logFile, &sync.Mutex{}, logfileMaxbytes, logfileBackups, props, logEventEmitter)
 			log.SetLevel(toLogLevel(loglevel))
 			log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
 			log.SetOutput(s.logger)
+			// the log file of the previous configuration is not rotated anymore
+			if prevLogger != nil {
+				prevLogger.Close()
+			}
 		}
 		// set the pid
 		pidfile, err := env.Eval(supervisordConf.GetString("pidfile", "supervisord.pid"))
@@ -639,6 +834,48 @@ func (s *Supervisor) setSupervisordInfo() {
 	}
 }
 
+// setEnvironment sets the environment of the supervisord section to
+// supervisord, so all the programs inherit it
+func (s *Supervisor) setEnvironment() {
+	supervisordConf, ok := s.config.GetSupervisord()
+	if !ok {
+		return
+	}
+	for _, env := range supervisordConf.GetEnv("environment") {
+		if pos := strings.Index(env, "="); pos > 0 {
+			os.Setenv(env[0:pos], env[pos+1:])
+		}
+	}
+}
+
+// setStateStore loads the desired states of the programs from the statefile
+// of supervisord, the default is the file next to the pidfile with .state
+// extension. The states are not persisted if the statefile is none
+func (s *Supervisor) setStateStore() {
+	stateFile := "none"
+	if supervisordConf, ok := s.config.GetSupervisord(); ok {
+		env := config.NewStringExpression("here", s.config.GetConfigFileDir())
+		var err error
+		pidfile := supervisordConf.GetString("pidfile", "supervisord.pid")
+		stateFile, err = env.Eval(supervisordConf.GetString("statefile", process.GetDefaultStateFile(pidfile)))
+		if err != nil {
+			log.Error("invalid statefile: ", err)
+			stateFile = "none"
+		}
+	}
+	if stateFile == "none" {
+		s.procMgr.SetStateStore(nil)
+		s.procMgr.SetAdoptProcesses(false)
+		return
+	}
+	// keep the store in use, the running programs may record their processes in it
+	if s.procMgr.GetStateStore().GetFile() != stateFile {
+		s.procMgr.SetStateStore(process.NewStateStore(stateFile))
+	}
+	supervisordConf, _ := s.config.GetSupervisord()
+	s.procMgr.SetAdoptProcesses(supervisordConf.GetBool("adopt_processes", false))
+}
+
 func toLogLevel(level string) log.Level {
 	switch strings.ToLower(level) {
 	case "critical":
@@ -655,7 +892,8 @@ func toLogLevel(level string) log.Level {
 }
 
 // ReloadConfig reloads supervisord configuration file
-func (s *Supervisor) ReloadConfig(r *http.Request, args *struct{}, reply *types.ReloadConfigResult) error {
+func (s *Supervisor) ReloadConfig(r *http.Request, args *struct{}, reply *types.ReloadConfigResult) (err error) {
+	defer func() { s.audit(r, "reloadConfig", "", "", err) }()
 	log.Info("start to reload config")
 	addedGroup, changedGroup, removedGroup, err := s.Reload(false)
 	if len(addedGroup) > 0 {
@@ -678,13 +916,17 @@ func (s *Supervisor) ReloadConfig(r *http.Request, args *struct{}, reply *types.
 // AddProcessGroup adds a process group to the supervisor
 func (s *Supervisor) AddProcessGroup(r *http.Request, args *struct{ Name string }, reply *struct{ Success bool }) error {
 	reply.Success = false
-	return nil
+	err := fmt.Errorf("adding the process group is not supported")
+	s.audit(r, "addProcessGroup", args.Name, "", err)
+	return err
 }
 
 // RemoveProcessGroup removes a process group from the supervisor
 func (s *Supervisor) RemoveProcessGroup(r *http.Request, args *struct{ Name string }, reply *struct{ Success bool }) error {
 	reply.Success = false
-	return nil
+	err := fmt.Errorf("removing the process group is not supported")
+	s.audit(r, "removeProcessGroup", args.Name, "", err)
+	return err
 }
 
 // ReadProcessStdoutLog reads stdout of given program
@@ -735,8 +977,122 @@ func (s *Supervisor) TailProcessStderrLog(r *http.Request, args *ProcessLogReadI
 	return err
 }
 
+// SearchProcessLogs searches the lines matching the query in the current
+// and rotated log files of the programs
+func (s *Supervisor) SearchProcessLogs(r *http.Request, args *LogSearchArgs, reply *struct {
+	Matches   []types.LogMatch
+	Truncated bool
+}) error {
+	pattern, err := compileLogSearchQuery(args.Query, args.Regexp, args.IgnoreCase)
+	if err != nil {
+		return fmt.Errorf("BAD_ARGUMENTS invalid query %s: %v", args.Query, err)
+	}
+	since, err := parseLogSearchSince(args.Since, time.Now())
+	if err != nil {
+		return fmt.Errorf("BAD_ARGUMENTS invalid since %s", args.Since)
+	}
+	if args.Stream != "" && args.Stream != "stdout" && args.Stream != "stderr" {
+		return fmt.Errorf("BAD_ARGUMENTS invalid stream %s", args.Stream)
+	}
+	maxMatches := args.MaxMatches
+	if maxMatches <= 0 {
+		maxMatches = 1000
+	}
+
+	procs := make([]*process.Process, 0)
+	if len(args.Programs) == 0 {
+		s.procMgr.ForEachProcess(func(proc *process.Process) {
+			procs = append(procs, proc)
+		})
+	} else {
+		for _, name := range args.Programs {
+			procs = append(procs, s.procMgr.FindMatch(strings.TrimSpace(name))...)
+		}
+		if len(procs) == 0 {
+			return fmt.Errorf("BAD_NAME no process named %s", strings.Join(args.Programs, ","))
+		}
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].GetName() < procs[j].GetName() })
+
+	reply.Matches = make([]types.LogMatch, 0)
+	nodeName := s.getNodeName()
+	searched := make(map[string]bool)
+	for _, proc := range procs {
+		streams := []struct {
+			name   string
+			logger logger.Logger
+		}{{"stdout", proc.StdoutLog}, {"stderr", proc.StderrLog}}
+		for _, stream := range streams {
+			// the stderr is written to the stdout log if redirect_stderr is true
+			file := logger.GetLogFileName(stream.logger)
+			if (args.Stream != "" && args.Stream != stream.name) || file == "" || searched[file] {
+				continue
+			}
+			searched[file] = true
+			err := logger.SearchLog(stream.logger, pattern, since, func(match *logger.LogMatch) bool {
+				if len(reply.Matches) >= maxMatches {
+					reply.Truncated = true
+					return false
+				}
+				logMatch := types.LogMatch{Node: nodeName,
+					Program: proc.GetName(),
+					Stream:  stream.name,
+					File:    match.File,
+					Offset:  int(match.Offset),
+					Line:    match.Line,
+					Text:    match.Text}
+				if !match.Time.IsZero() {
+					logMatch.Time = match.Time.Format(time.RFC3339Nano)
+				}
+				reply.Matches = append(reply.Matches, logMatch)
+				return true
+			})
+			if err != nil {
+				log.WithFields(log.Fields{"program": proc.GetName(), "file": file}).Warn("failed to search log: ", err)
+			}
+		}
+	}
+	return nil
+}
+
+// compileLogSearchQuery compiles the query to regular expression, the plain
+// text query is quoted
+func compileLogSearchQuery(query string, isRegexp bool, ignoreCase bool) (*regexp.Regexp, error) {
+	if query == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+	if !isRegexp {
+		query = regexp.QuoteMeta(query)
+	}
+	if ignoreCase {
+		query = "(?i)" + query
+	}
+	return regexp.Compile(query)
+}
+
+// parseLogSearchSince parses the since argument which can be a RFC3339 time,
+// unix seconds or a duration before now. The zero time is returned if it is empty
+func parseLogSearchSince(since string, now time.Time) (time.Time, error) {
+	since = strings.TrimSpace(since)
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(-d), nil
+}
+
 // ClearProcessLogs clears log of given program
-func (s *Supervisor) ClearProcessLogs(r *http.Request, args *struct{ Name string }, reply *struct{ Success bool }) error {
+func (s *Supervisor) ClearProcessLogs(r *http.Request, args *struct{ Name string }, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "clearProcessLogs", args.Name, "", err) }()
 	proc := s.procMgr.Find(args.Name)
 	if proc == nil {
 		return fmt.Errorf("no such process %s", args.Name)
@@ -751,7 +1107,8 @@ func (s *Supervisor) ClearProcessLogs(r *http.Request, args *struct{ Name string
 }
 
 // ClearAllProcessLogs clears logs of all programs
-func (s *Supervisor) ClearAllProcessLogs(r *http.Request, args *struct{}, reply *struct{ RPCTaskResults []RPCTaskResult }) error {
+func (s *Supervisor) ClearAllProcessLogs(r *http.Request, args *struct{}, reply *struct{ RPCTaskResults []RPCTaskResult }) (err error) {
+	defer func() { s.audit(r, "clearAllProcessLogs", "all", "", err) }()
 
 	s.procMgr.ForEachProcess(func(proc *process.Process) {
 		_ = proc.StdoutLog.ClearAllLogFile()
@@ -768,6 +1125,71 @@ func (s *Supervisor) ClearAllProcessLogs(r *http.Request, args *struct{}, reply
 	return nil
 }
 
+// RotateProcessLogs rotates the log files of given program now
+func (s *Supervisor) RotateProcessLogs(r *http.Request, args *struct{ Name string }, reply *struct{ Success bool }) (err error) {
+	defer func() { s.audit(r, "rotateProcessLogs", args.Name, "", err) }()
+	proc := s.procMgr.Find(args.Name)
+	if proc == nil {
+		return fmt.Errorf("no such process %s", args.Name)
+	}
+	err = proc.RotateLogs()
+	reply.Success = err == nil
+	return err
+}
+
+// RotateAllProcessLogs rotates the log files of all programs now
+func (s *Supervisor) RotateAllProcessLogs(r *http.Request, args *struct{}, reply *struct{ RPCTaskResults []RPCTaskResult }) (err error) {
+	defer func() { s.audit(r, "rotateAllProcessLogs", "all", "", err) }()
+	s.procMgr.ForEachProcess(func(proc *process.Process) {
+		procInfo := getProcessInfo(s.getNodeName(), proc)
+		result := RPCTaskResult{
+			Name:        procInfo.Name,
+			Group:       procInfo.Group,
+			Status:      faults.Success,
+			Description: "OK",
+		}
+		if err := proc.RotateLogs(); err != nil {
+			result.Status = faults.Failed
+			result.Description = err.Error()
+		}
+		reply.RPCTaskResults = append(reply.RPCTaskResults, result)
+	})
+
+	return nil
+}
+
+// RotateLog rotates the supervisor log file now
+func (s *Supervisor) RotateLog(r *http.Request, args *struct{}, reply *struct{ Ret bool }) (err error) {
+	defer func() { s.audit(r, "rotateLog", "", "", err) }()
+	err = logger.RotateLog(s.logger)
+	reply.Ret = err == nil
+	return err
+}
+
+// handleUSR2Signal rotates all the log files, or upgrades supervisord if
+// sigusr2_action is upgrade
+func (s *Supervisor) handleUSR2Signal() {
+	if supervisordConf, ok := s.config.GetSupervisord(); ok && supervisordConf.GetString("sigusr2_action", "rotate") == "upgrade" {
+		s.upgradeBySignal()
+		return
+	}
+	s.rotateAllLogs()
+	s.auditSignal("SIGUSR2", "rotateAllLogs", nil)
+}
+
+// rotateAllLogs rotates the supervisor log and the log files of all programs
+func (s *Supervisor) rotateAllLogs() {
+	log.Info("rotate all the log files")
+	if err := logger.RotateLog(s.logger); err != nil {
+		log.Error("fail to rotate supervisor log: ", err)
+	}
+	s.procMgr.ForEachProcess(func(proc *process.Process) {
+		if err := proc.RotateLogs(); err != nil {
+			log.WithFields(log.Fields{"program": proc.GetName()}).Error("fail to rotate program log: ", err)
+		}
+	})
+}
+
 // GetManager get the Manager object created by supervisor
 func (s *Supervisor) GetManager() *process.Manager {
 	return s.procMgr
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSupervisor creates the supervisor with the configuration content
+// and the processes of its programs, the processes are not started
+func newTestSupervisor(t *testing.T, content string) *Supervisor {
+	fileName := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSupervisor(fileName)
+	if _, err := s.config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range s.config.GetPrograms() {
+		s.procMgr.CreateProcess(s.GetSupervisorID(), entry)
+	}
+	return s
+}
+
+func TestIsDaemonMode(t *testing.T) {
+	defer func(daemon bool) { options.Daemon = daemon }(options.Daemon)
+	dir := t.TempDir()
+	tests := []struct {
+		conf     string
+		daemon   bool
+		expected bool
+	}{
+		{"[supervisord]\n", false, false},
+		{"[supervisord]\n", true, true},
+		{"[supervisord]\nnodaemon=false\n", false, true},
+		{"[supervisord]\nnodaemon=true\n", false, false},
+		// the -d option is not overridden by the configuration
+		{"[supervisord]\nnodaemon=true\n", true, true},
+	}
+	for i, test := range tests {
+		fileName := filepath.Join(dir, "supervisord.conf")
+		if err := os.WriteFile(fileName, []byte(test.conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		options.Daemon = test.daemon
+		if daemon := isDaemonMode(fileName); daemon != test.expected {
+			t.Errorf("case %d: expected daemon mode %v, got %v", i, test.expected, daemon)
+		}
+	}
+}
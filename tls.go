@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ochinchina/supervisord/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// serverTLS the TLS settings of the inet http server. The certificate, the
+// key and the client CA are loaded again when their files are changed, so a
+// renewed certificate is used without restarting supervisord
+type serverTLS struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// tls.RequireAndVerifyClientCert or tls.VerifyClientCertIfGiven if the
+	// client CA is set
+	clientAuth tls.ClientAuthType
+	// the users of the client certificates by common name, nil if the common
+	// name is the user
+	clientUsers map[string]string
+
+	lock sync.Mutex
+	// the loaded configuration and the modification time of the loaded files
+	config    *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// newServerTLS creates the TLS settings from the tls_cert, tls_key,
+// tls_client_ca, tls_client_auth and tls_client_users of the
+// inet_http_server section. Returns nil if tls_cert is not set
+func newServerTLS(entry *config.Entry) (*serverTLS, error) {
+	certFile := entry.GetString("tls_cert", "")
+	keyFile := entry.GetString("tls_key", "")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both tls_cert and tls_key must be set")
+	}
+	t := &serverTLS{certFile: certFile,
+		keyFile:      keyFile,
+		clientCAFile: entry.GetString("tls_client_ca", ""),
+		clientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	switch clientAuth := entry.GetString("tls_client_auth", "require"); clientAuth {
+	case "require":
+	case "optional":
+		t.clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid tls_client_auth %s, it should be require or optional", clientAuth)
+	}
+	if users := entry.GetString("tls_client_users", ""); users != "" {
+		t.clientUsers = make(map[string]string)
+		for _, pair := range strings.Split(users, ",") {
+			fields := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+			if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+				return nil, fmt.Errorf("invalid tls_client_users %s, it should be <common name>:<user>,...", users)
+			}
+			t.clientUsers[fields[0]] = fields[1]
+		}
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *serverTLS) files() []string {
+	files := []string{t.certFile, t.keyFile}
+	if t.clientCAFile != "" {
+		files = append(files, t.clientCAFile)
+	}
+	return files
+}
+
+// load loads the certificate, the key and the client CA
+func (t *serverTLS) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	for _, file := range t.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("fail to load tls_cert %s and tls_key %s: %v", t.certFile, t.keyFile, err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.clientCAFile != "" {
+		b, err := os.ReadFile(t.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate is found in tls_client_ca %s", t.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = t.clientAuth
+	}
+	t.config = config
+	t.modTimes = modTimes
+	return nil
+}
+
+// isChanged checks if any of the loaded files is changed
+func (t *serverTLS) isChanged() bool {
+	for i, file := range t.files() {
+		if fi, err := os.Stat(file); err == nil && !fi.ModTime().Equal(t.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getConfigForClient gets the TLS configuration for the new connection, the
+// files are checked for change at most once a second
+func (t *serverTLS) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if time.Since(t.lastCheck) >= time.Second {
+		t.lastCheck = time.Now()
+		if t.isChanged() {
+			if err := t.load(); err != nil {
+				// keep using the loaded certificate until the files are fixed
+				log.WithFields(log.Fields{"tls_cert": t.certFile}).Error("fail to reload the certificate: ", err)
+			} else {
+				log.WithFields(log.Fields{"tls_cert": t.certFile}).Info("the certificate is reloaded")
+			}
+		}
+	}
+	return t.config, nil
+}
+
+// TLSConfig gets the TLS configuration of the http server
+func (t *serverTLS) TLSConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: t.getConfigForClient, MinVersion: tls.VersionTLS12}
+}
+
+// getClientUser gets the user of the verified client certificate, returns
+// false if no client certificate is verified or its common name is not in
+// tls_client_users
+func (t *serverTLS) getClientUser(state *tls.ConnectionState) (string, bool) {
+	if t == nil || state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	commonName := state.VerifiedChains[0][0].Subject.CommonName
+	if t.clientUsers == nil {
+		return commonName, commonName != ""
+	}
+	user, ok := t.clientUsers[commonName]
+	return user, ok
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert a certificate and its key signed by the parent, or self-signed if
+// the parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{SerialNumber: serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and the key in PEM format
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// newTestServerTLS creates the TLS settings from the inet_http_server
+// section with the parameters
+func newTestServerTLS(t *testing.T, params string) (*serverTLS, error) {
+	s := newTestSupervisor(t, "[inet_http_server]\nport=127.0.0.1:9001\n"+params)
+	entry, ok := s.config.GetInetHTTPServer()
+	if !ok {
+		t.Fatal("expected the inet_http_server section")
+	}
+	return newServerTLS(entry)
+}
+
+func TestNewServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	newTestCert(t, "server", nil, false).write(t, certFile, keyFile)
+	files := "tls_cert=" + certFile + "\ntls_key=" + keyFile + "\n"
+
+	if serverTLS, err := newTestServerTLS(t, ""); serverTLS != nil || err != nil {
+		t.Errorf("expected TLS is disabled without tls_cert, got %v", err)
+	}
+	serverTLS, err := newTestServerTLS(t, files)
+	if err != nil || serverTLS == nil || serverTLS.config.ClientCAs != nil {
+		t.Fatalf("expected TLS without client verification, got %v", err)
+	}
+	for _, params := range []string{
+		"tls_cert=" + certFile + "\n",
+		files + "tls_client_auth=never\n",
+		files + "tls_client_users=ops\n",
+		"tls_cert=" + certFile + "\ntls_key=" + certFile + "\n",
+		files + "tls_client_ca=" + keyFile + "\n",
+	} {
+		if _, err := newTestServerTLS(t, params); err == nil {
+			t.Errorf("expected the invalid TLS settings %q", params)
+		}
+	}
+}
+
+func TestServerTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	first := newTestCert(t, "first", nil, false)
+	first.write(t, certFile, keyFile)
+	serverTLS, err := newTestServerTLS(t, "tls_cert="+certFile+"\ntls_key="+keyFile+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCommonName := func() string {
+		config, err := serverTLS.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Subject.CommonName
+	}
+	if name := getCommonName(); name != "first" {
+		t.Fatalf("expected the first certificate, got %s", name)
+	}
+
+	// the renewed certificate is used after the files are changed
+	newTestCert(t, "second", nil, false).write(t, certFile, keyFile)
+	modTime := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serverTLS.lastCheck = time.Time{}
+	if name := getCommonName(); name != "second" {
+		t.Errorf("expected the renewed certificate, got %s", name)
+	}
+
+	// the loaded certificate is kept if the changed files are invalid
+	if err := os.WriteFile(certFile, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime = modTime.Add(time.Minute)
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	serverTLS.lastCheck = time.Time{}
+	if name := getCommonName(); name != "second" {
+		t.Errorf("expected the loaded certificate is kept, got %s", name)
+	}
+}
+
+func TestServerTLSClientVerification(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca.write(t, caFile, "")
+	server.write(t, certFile, keyFile)
+	files := "tls_cert=" + certFile + "\ntls_key=" + keyFile + "\ntls_client_ca=" + caFile + "\n"
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	tests := []struct {
+		params string
+		// the common name of the client certificate, empty if no certificate
+		client    string
+		untrusted bool
+		// the authenticated user, empty if the request fails
+		expected string
+	}{
+		{"", "alice", false, "alice"},
+		{"", "", false, ""},
+		{"", "alice", true, ""},
+		{"tls_client_users=deployer:ops\n", "deployer", false, "ops"},
+		{"tls_client_users=deployer:ops\n", "alice", false, "-"},
+		{"tls_client_auth=optional\n", "", false, "-"},
+		{"tls_client_auth=optional\n", "alice", false, "alice"},
+	}
+	for i, test := range tests {
+		serverTLS, err := newTestServerTLS(t, files+test.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := serverTLS.getClientUser(r.TLS)
+			if !ok {
+				user = "-"
+			}
+			_, _ = io.WriteString(w, user)
+		}))
+		httpServer.TLS = serverTLS.TLSConfig()
+		httpServer.StartTLS()
+
+		clientConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		if test.client != "" {
+			issuer := ca
+			if test.untrusted {
+				issuer = newTestCert(t, "other-ca", nil, true)
+			}
+			clientConfig.Certificates = []tls.Certificate{newTestCert(t, test.client, issuer, false).tlsCertificate()}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		user := ""
+		if resp, err := client.Get(httpServer.URL); err == nil {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			user = string(b)
+		}
+		client.CloseIdleConnections()
+		httpServer.Close()
+		if user != test.expected {
+			t.Errorf("%d: expected the user %q, got %q", i, test.expected, user)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ochinchina/supervisord/config"
+)
+
+// accessToken the static bearer token, it has the permissions and the
+// program patterns of its [token:<name>] section like a role
+type accessToken struct {
+	name string
+	// the hex sha256 hash of the token, the token itself is not configured
+	hash string
+	role *accessRole
+}
+
+// newAccessToken creates the token from the [token:<name>] section with the
+// token_sha256, permissions and programs parameters
+func newAccessToken(entry *config.Entry) (*accessToken, error) {
+	name := strings.TrimPrefix(entry.Name, "token:")
+	hash := strings.ToLower(entry.GetString("token_sha256", ""))
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid token_sha256 of token %s, it should be the sha256 hash of the token in hex", name)
+	}
+	role, err := newAccessRole("token:"+name, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &accessToken{name: name, hash: hash, role: role}, nil
+}
+
+// authenticateToken gets the user of the bearer token, returns false if the
+// token is not configured
+func (ac *accessControl) authenticateToken(token string) (*requestUser, bool) {
+	sum := sha256.Sum256([]byte(token))
+	t, ok := ac.tokens[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, false
+	}
+	return &requestUser{name: "token:" + t.name, roles: []*accessRole{t.role}}, true
+}
+
+// getBearerToken gets the token in the "Authorization: Bearer <token>"
+// header of the request
+func getBearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[0:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticateToken(t *testing.T) {
+	sum := sha256.Sum256([]byte("s3cret"))
+	s := newTestSupervisor(t, "[program:web_1]\ncommand=/bin/cat\n\n[program:db]\ncommand=/bin/cat\n\n[token:deploy]\ntoken_sha256="+hex.EncodeToString(sum[:])+"\npermissions=operator\nprograms=web_*\n")
+	ac, err := newAccessControl(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ac.authenticateToken("other"); ok {
+		t.Error("expected the token which is not configured is rejected")
+	}
+	user, ok := ac.authenticateToken("s3cret")
+	if !ok || user.name != "token:deploy" || user.admin {
+		t.Fatalf("unexpected user of the token %+v", user)
+	}
+	if !ac.isAllowed(user, &accessRequest{permission: permissionControl, names: []string{"web_1"}}, s.procMgr) {
+		t.Error("expected the token is allowed to control web_1")
+	}
+	if ac.isAllowed(user, &accessRequest{permission: permissionControl, names: []string{"db"}}, s.procMgr) {
+		t.Error("expected the token is not allowed to control db")
+	}
+
+	for _, hash := range []string{"", "abc", hex.EncodeToString(sum[:16]), "zz" + hex.EncodeToString(sum[1:])} {
+		s = newTestSupervisor(t, "[token:deploy]\ntoken_sha256="+hash+"\npermissions=view\n")
+		if _, err := newAccessControl(s.config); err == nil {
+			t.Errorf("expected the token_sha256 %q is invalid", hash)
+		}
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		header   string
+		token    string
+		expected bool
+	}{
+		{"Bearer s3cret", "s3cret", true},
+		{"bearer  s3cret ", "s3cret", true},
+		{"Bearer ", "", false},
+		{"Basic YWxpY2U6c2VjcmV0", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/program/list", nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		if token, ok := getBearerToken(r); token != test.token || ok != test.expected {
+			t.Errorf("expected %q %v for the header %q, got %q %v", test.token, test.expected, test.header, token, ok)
+		}
+	}
+}
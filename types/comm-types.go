@@ -23,6 +23,44 @@ type ProcessInfo struct {
 	Pid           int    `xml:"pid" json:"pid"`
 }
 
+// ProcessRun the information of a past run of the program
+type ProcessRun struct {
+	Pid   int `xml:"pid" json:"pid"`
+	Start int `xml:"start" json:"start"`
+	Stop  int `xml:"stop" json:"stop"`
+	// the exit code, -1 if the program is terminated by signal
+	Exitstatus int `xml:"exitstatus" json:"exitstatus"`
+	// the signal terminated the program, empty if the program exited
+	Signal string `xml:"signal" json:"signal"`
+	// true if the program exited with a code in exitcodes or it is stopped by
+	// user, dependency or file change. false if it crashed or it is stopped
+	// because it is unhealthy
+	Expected bool `xml:"expected" json:"expected"`
+	// who stopped the program: user, liveness_check, readiness_check,
+	// resource_limit, dependency, file_changed, or empty if the program exited by itself
+	Stoppedby string `xml:"stoppedby" json:"stoppedby"`
+	// the tail of the stderr when the program exited
+	Stderrtail string `xml:"stderrtail" json:"stderrtail"`
+}
+
+// LogMatch a line of the program log matching the search
+type LogMatch struct {
+	Node    string `xml:"node" json:"node"`
+	Program string `xml:"program" json:"program"`
+	// stdout or stderr
+	Stream string `xml:"stream" json:"stream"`
+	File   string `xml:"file" json:"file"`
+	// the offset of the line in the file, or in the decompressed content of
+	// the compressed backup
+	Offset int `xml:"offset" json:"offset"`
+	// the line number starting from 1
+	Line int `xml:"line" json:"line"`
+	// the timestamp of the line in RFC3339 format, empty if the line has no
+	// known timestamp
+	Time string `xml:"time" json:"time"`
+	Text string `xml:"text" json:"text"`
+}
+
 // ReloadConfigResult the result of supervisor configuration reloading
 type ReloadConfigResult struct {
 	AddedGroup   []string
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ochinchina/supervisord/process"
+	log "github.com/sirupsen/logrus"
+)
+
+// the environment variable passing the listening sockets to the new
+// supervisord on upgrade
+const upgradeEnv = "SUPERVISORD_UPGRADE"
+
+// the environment variable set by go-daemon for the daemon child process
+const goDaemonMark = "_GO_DAEMON"
+
+// inheritedListener the listening socket passed to the new supervisord
+type inheritedListener struct {
+	Protocol string `json:"protocol"`
+	Addr     string `json:"addr"`
+	Fd       int    `json:"fd"`
+}
+
+// upgradeState the state passed to the new supervisord on upgrade
+type upgradeState struct {
+	Listeners []inheritedListener `json:"listeners"`
+	// the file descriptors of the listening sockets of the programs by url
+	Sockets map[string]int `json:"sockets,omitempty"`
+}
+
+// the state passed by the previous supervisord, nil if supervisord is not
+// started by upgrade
+var inheritedState struct {
+	sync.Mutex
+	state *upgradeState
+	// true if the processes passed by the previous supervisord are adopted
+	adopted bool
+}
+
+// loadUpgradeState loads the state passed by the previous supervisord if
+// supervisord is started by upgrade. The environment variable is removed so
+// it is not passed to the programs
+func loadUpgradeState() {
+	value, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(upgradeEnv)
+	state := &upgradeState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		log.Error("invalid upgrade state: ", err)
+	}
+	for _, l := range state.Listeners {
+		if err := process.SetCloseOnExec(l.Fd, true); err != nil {
+			log.WithFields(log.Fields{"protocol": l.Protocol, "addr": l.Addr}).Error("invalid inherited listener: ", err)
+		}
+	}
+	for url, fd := range state.Sockets {
+		if err := process.SetCloseOnExec(fd, true); err != nil {
+			log.WithFields(log.Fields{"socket": url}).Error("invalid inherited socket: ", err)
+		}
+	}
+	inheritedState.Lock()
+	inheritedState.state = state
+	inheritedState.Unlock()
+	log.Info("supervisord is started by upgrade")
+}
+
+// hasUpgradeState checks if supervisord is started by upgrade before the
+// upgrade state is loaded. The upgraded supervisord is already a daemon if the
+// previous one is, so it must not be daemonized again
+func hasUpgradeState() bool {
+	_, ok := os.LookupEnv(upgradeEnv)
+	return ok
+}
+
+// upgradeEnviron gets the environment of the new supervisord with the
+// upgrade state. The mark of go-daemon is removed, otherwise the new
+// supervisord started as daemon takes itself as a daemon child and fails to
+// get the daemon context from its parent
+func upgradeEnviron(environ []string, state []byte) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, e := range environ {
+		if !strings.HasPrefix(e, goDaemonMark+"=") && !strings.HasPrefix(e, upgradeEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	return append(env, fmt.Sprintf("%s=%s", upgradeEnv, state))
+}
+
+// consumeUpgraded checks if supervisord is started by upgrade and the
+// processes are not adopted yet, it returns true only once
+func consumeUpgraded() bool {
+	inheritedState.Lock()
+	defer inheritedState.Unlock()
+	upgraded := inheritedState.state != nil && !inheritedState.adopted
+	inheritedState.adopted = true
+	return upgraded
+}
+
+// isStartedByUpgrade checks if supervisord is started by upgrade
+func isStartedByUpgrade() bool {
+	inheritedState.Lock()
+	defer inheritedState.Unlock()
+	return inheritedState.state != nil
+}
+
+// takeInheritedListener gets the listening socket passed by the previous
+// supervisord with same protocol and address, nil if there is no such socket
+func takeInheritedListener(protocol string, addr string) net.Listener {
+	inheritedState.Lock()
+	defer inheritedState.Unlock()
+	if inheritedState.state == nil {
+		return nil
+	}
+	listeners := inheritedState.state.Listeners
+	for i, l := range listeners {
+		if l.Protocol != protocol || l.Addr != addr {
+			continue
+		}
+		inheritedState.state.Listeners = append(listeners[:i:i], listeners[i+1:]...)
+		f := os.NewFile(uintptr(l.Fd), fmt.Sprintf("%s:%s", protocol, addr))
+		defer f.Close()
+		listener, err := net.FileListener(f)
+		if err != nil {
+			log.WithFields(log.Fields{"addr": addr, "protocol": protocol}).Error("fail to use inherited listener: ", err)
+			return nil
+		}
+		return listener
+	}
+	return nil
+}
+
+// takeInheritedSockets gets the listening sockets of the programs passed by
+// the previous supervisord, nil if supervisord is not started by upgrade
+func takeInheritedSockets() map[string]int {
+	inheritedState.Lock()
+	defer inheritedState.Unlock()
+	if inheritedState.state == nil {
+		return nil
+	}
+	sockets := inheritedState.state.Sockets
+	inheritedState.state.Sockets = nil
+	return sockets
+}
+
+// closeInheritedListeners closes the listening sockets passed by the previous
+// supervisord which are not used because the http server config is changed
+func closeInheritedListeners() {
+	inheritedState.Lock()
+	defer inheritedState.Unlock()
+	if inheritedState.state == nil {
+		return
+	}
+	for _, l := range inheritedState.state.Listeners {
+		log.WithFields(log.Fields{"addr": l.Addr, "protocol": l.Protocol}).Info("close the unused inherited listener")
+		os.NewFile(uintptr(l.Fd), l.Addr).Close()
+	}
+	inheritedState.state.Listeners = nil
+}
+
+// inheritListeners makes the listening sockets of the http servers to be
+// inherited by the new supervisord. The duplicated sockets are returned to be
+// closed if the upgrade is failed
+func (p *XMLRPC) inheritListeners() ([]inheritedListener, []*os.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]inheritedListener, 0)
+	files := make([]*os.File, 0)
+	for protocol, listener := range p.listeners {
+		fileListener, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("can't get the socket of %s listener", protocol)
+		}
+		// the duplicated socket is kept open until exec
+		f, err := fileListener.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+		files = append(files, f)
+		fd := int(f.Fd())
+		if err = process.SetCloseOnExec(fd, false); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+		result = append(result, inheritedListener{Protocol: protocol, Addr: p.listenAddrs[protocol], Fd: fd})
+	}
+	return result, files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// checkUpgrade checks if supervisord can be upgraded: the running programs
+// must be adoptable and the supervisord binary must be runnable
+func (s *Supervisor) checkUpgrade() (string, error) {
+	supervisordConf, ok := s.config.GetSupervisord()
+	if s.procMgr.GetStateStore() == nil || !ok || !supervisordConf.GetBool("adopt_processes", false) {
+		return "", fmt.Errorf("FAILED upgrade requires statefile and adopt_processes=true")
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("FAILED fail to find the supervisord binary: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, binary, "version").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("FAILED the supervisord binary %s is not runnable: %v %s", binary, err, out)
+	}
+	return binary, nil
+}
+
+// Upgrade re-executes the supervisord binary without stopping the programs.
+// The running programs with their pipes and the listening sockets of the
+// http servers are passed to the new supervisord
+func (s *Supervisor) Upgrade(r *http.Request, args *struct{}, reply *struct{ Ret bool }) error {
+	binary, err := s.checkUpgrade()
+	s.audit(r, "upgrade", "", "", err)
+	if err != nil {
+		return err
+	}
+	reply.Ret = true
+	log.WithFields(log.Fields{"binary": binary}).Info("received rpc request to upgrade supervisord")
+	go func() {
+		// let the reply be sent
+		time.Sleep(1 * time.Second)
+		s.upgrade(binary)
+	}()
+	return nil
+}
+
+// upgradeBySignal upgrades supervisord when SIGUSR2 is received
+func (s *Supervisor) upgradeBySignal() {
+	binary, err := s.checkUpgrade()
+	s.auditSignal("SIGUSR2", "upgrade", err)
+	if err != nil {
+		log.Error("fail to upgrade supervisord: ", err)
+		return
+	}
+	log.WithFields(log.Fields{"binary": binary}).Info("received signal to upgrade supervisord")
+	s.upgrade(binary)
+}
+
+// upgrade re-executes the supervisord binary, it returns only if failed
+func (s *Supervisor) upgrade(binary string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var listenerFiles []*os.File
+	// the programs and the http servers keep running if the upgrade is failed
+	defer func() {
+		closeFiles(listenerFiles)
+		s.procMgr.CancelUpgrade()
+		// the event listeners are stopped for upgrade
+		s.startEventListeners()
+	}()
+	if err := s.procMgr.PrepareUpgrade(); err != nil {
+		log.Error("fail to upgrade supervisord: ", err)
+		return
+	}
+	listeners, listenerFiles, err := s.xmlRPC.inheritListeners()
+	if err != nil {
+		log.Error("fail to upgrade supervisord: ", err)
+		return
+	}
+	sockets, err := s.procMgr.InheritSockets()
+	if err != nil {
+		log.Error("fail to upgrade supervisord: ", err)
+		return
+	}
+	b, _ := json.Marshal(&upgradeState{Listeners: listeners, Sockets: sockets})
+	env := upgradeEnviron(os.Environ(), b)
+	log.WithFields(log.Fields{"binary": binary}).Info("re-execute supervisord")
+	err = execSupervisord(binary, os.Args, env)
+	log.Error("fail to re-execute supervisord: ", err)
+}
@@ -0,0 +1,196 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ochinchina/go-daemon"
+	"golang.org/x/sys/unix"
+)
+
+// setUpgradeEnv sets the upgrade state passed by the previous supervisord,
+// the loaded state is cleared after the test
+func setUpgradeEnv(t *testing.T, state *upgradeState) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(upgradeEnv, string(b))
+	t.Cleanup(func() {
+		inheritedState.Lock()
+		inheritedState.state = nil
+		inheritedState.adopted = false
+		inheritedState.Unlock()
+	})
+}
+
+// inheritTestListener creates the listening socket to be inherited and
+// returns the duplicated file descriptor of the socket, it is owned by the
+// inherited state like the one passed by the previous supervisord
+func inheritTestListener(t *testing.T) (net.Listener, int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	rawConn, err := l.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := -1
+	if err = rawConn.Control(func(s uintptr) { fd, err = unix.Dup(int(s)) }); err != nil {
+		t.Fatal(err)
+	}
+	return l, fd
+}
+
+func TestLoadUpgradeState(t *testing.T) {
+	loadUpgradeState()
+	if isStartedByUpgrade() {
+		t.Fatal("expected supervisord is not started by upgrade")
+	}
+
+	l, fd := inheritTestListener(t)
+	defer unix.Close(fd)
+	setUpgradeEnv(t, &upgradeState{Listeners: []inheritedListener{{Protocol: "tcp", Addr: l.Addr().String(), Fd: fd}},
+		Sockets: map[string]int{"tcp://127.0.0.1:8080": fd}})
+	loadUpgradeState()
+	if _, ok := os.LookupEnv(upgradeEnv); ok {
+		t.Error("expected the upgrade state is not passed to the programs")
+	}
+	if flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil || flags&unix.FD_CLOEXEC == 0 {
+		t.Error("expected the inherited socket is close-on-exec")
+	}
+	if !isStartedByUpgrade() {
+		t.Error("expected supervisord is started by upgrade")
+	}
+	if !consumeUpgraded() || consumeUpgraded() {
+		t.Error("expected the upgraded processes are adopted only once")
+	}
+	if sockets := takeInheritedSockets(); len(sockets) != 1 || sockets["tcp://127.0.0.1:8080"] != fd {
+		t.Errorf("unexpected inherited sockets %v", sockets)
+	}
+	if sockets := takeInheritedSockets(); len(sockets) != 0 {
+		t.Errorf("expected the inherited sockets are taken once, got %v", sockets)
+	}
+}
+
+func TestTakeInheritedListener(t *testing.T) {
+	l, fd := inheritTestListener(t)
+	_, unused := inheritTestListener(t)
+	addr := l.Addr().String()
+	setUpgradeEnv(t, &upgradeState{Listeners: []inheritedListener{
+		{Protocol: "tcp", Addr: addr, Fd: fd},
+		{Protocol: "tcp", Addr: "127.0.0.1:1", Fd: unused}}})
+	loadUpgradeState()
+
+	if listener := takeInheritedListener("unix", addr); listener != nil {
+		t.Error("expected no inherited listener of another protocol")
+	}
+	listener := takeInheritedListener("tcp", addr)
+	if listener == nil {
+		t.Fatal("expected the inherited listener")
+	}
+	defer listener.Close()
+	if listener.Addr().String() != addr {
+		t.Errorf("expected the listener on %s, got %s", addr, listener.Addr())
+	}
+	// the inherited listener accepts the connections of the socket
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if accepted, err := listener.Accept(); err != nil {
+		t.Errorf("expected the connection is accepted by the inherited listener: %v", err)
+	} else {
+		accepted.Close()
+	}
+	if takeInheritedListener("tcp", addr) != nil {
+		t.Error("expected the inherited listener is taken once")
+	}
+
+	closeInheritedListeners()
+	if _, err := unix.FcntlInt(uintptr(unused), unix.F_GETFD, 0); err == nil {
+		t.Error("expected the unused inherited listener is closed")
+	}
+}
+
+func TestInheritedUnixListenerKeepsPermission(t *testing.T) {
+	sockFile := filepath.Join(t.TempDir(), "supervisord.sock")
+	l, err := net.Listen("unix", sockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = os.Chmod(sockFile, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rawConn, err := l.(*net.UnixListener).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := -1
+	if err = rawConn.Control(func(s uintptr) { fd, err = unix.Dup(int(s)) }); err != nil {
+		t.Fatal(err)
+	}
+	setUpgradeEnv(t, &upgradeState{Listeners: []inheritedListener{{Protocol: "unix", Addr: sockFile, Fd: fd}}})
+	loadUpgradeState()
+
+	s := newTestSupervisor(t, "[program:test]\ncommand=/bin/cat\n")
+	server := NewXMLRPC()
+	defer server.Stop()
+	started := make(chan struct{})
+	go server.startHTTPServer("", "", "unix", sockFile, nil, &unixSocketPermission{mode: 0700, uid: -1, gid: -1}, s, nil, func() { close(started) })
+	<-started
+	// the permission set before the privileges were dropped is kept
+	fileInfo, err := os.Stat(sockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo.Mode().Perm() != 0755 {
+		t.Errorf("expected the permission of the inherited socket is not changed, got %v", fileInfo.Mode().Perm())
+	}
+}
+
+func TestUpgradeDaemon(t *testing.T) {
+	// supervisord started as daemon is the child reborn by go-daemon
+	t.Setenv(goDaemonMark, "1")
+	t.Setenv(upgradeEnv, "stale")
+	if !daemon.WasReborn() {
+		t.Fatal("expected the daemon child of go-daemon")
+	}
+
+	environ := os.Environ()
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, e := range environ {
+			kv := strings.SplitN(e, "=", 2)
+			os.Setenv(kv[0], kv[1])
+		}
+	})
+	// the environment of the re-executed supervisord
+	env := upgradeEnviron(environ, []byte(`{"listeners":[]}`))
+	os.Clearenv()
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		os.Setenv(kv[0], kv[1])
+	}
+	if daemon.WasReborn() {
+		t.Error("expected the upgraded supervisord is not taken as the daemon child")
+	}
+	if os.Getenv(upgradeEnv) != `{"listeners":[]}` {
+		t.Errorf("expected the upgrade state is passed once, got %v", env)
+	}
+	// the upgraded supervisord keeps the pid of the daemon
+	if !hasUpgradeState() {
+		t.Error("expected the upgraded supervisord is not daemonized again")
+	}
+}
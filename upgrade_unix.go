@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// execSupervisord replaces the current supervisord with the binary, the pid
+// is not changed so the programs are still the children of supervisord
+func execSupervisord(binary string, args []string, env []string) error {
+	return syscall.Exec(binary, args, env)
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+)
+
+// execSupervisord returns error because exec is not supported on windows
+func execSupervisord(binary string, args []string, env []string) error {
+	return fmt.Errorf("upgrade is not supported on windows")
+}
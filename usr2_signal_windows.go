@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package main
+
+func initUSR2Signal(handler func()) {
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"crypto/sha1" //nolint:gosec
-	"encoding/hex"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -22,48 +22,86 @@ import (
 // XMLRPC mange the XML RPC servers
 // start XML RPC servers to accept the XML RPC request from client side
 type XMLRPC struct {
-	mu            sync.Mutex
-	listeners     map[string]net.Listener
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	// the listening addresses of the listeners
+	listenAddrs   map[string]string
 	procCollector prometheus.Collector
 }
 
 type httpBasicAuth struct {
 	user     string
 	password string
-	handler  http.Handler
+	// the TLS settings to authenticate the client certificate, nil if TLS is
+	// not enabled
+	tls *serverTLS
+	// get the users and roles, nil if they are not configured
+	accessControl func() *accessControl
+	handler       http.Handler
 }
 
-// create a new HttpBasicAuth object with username, password and the http request handler
-func newHTTPBasicAuth(user string, password string, handler http.Handler) *httpBasicAuth {
-	return &httpBasicAuth{user: user, password: password, handler: handler}
+// create a new HttpBasicAuth object with username, password, the TLS settings, the users and the http request handler
+func newHTTPBasicAuth(user string, password string, serverTLS *serverTLS, accessControl func() *accessControl, handler http.Handler) *httpBasicAuth {
+	return &httpBasicAuth{user: user, password: password, tls: serverTLS, accessControl: accessControl, handler: handler}
 }
 
 func (h *httpBasicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h.user == "" || h.password == "" {
-		h.handler.ServeHTTP(w, r)
+	user, ok := h.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"supervisor\"")
+		w.WriteHeader(401)
 		return
 	}
-	username, password, ok := r.BasicAuth()
-	if ok && username == h.user {
-		if strings.HasPrefix(h.password, "{SHA}") {
-			hash := sha1.New() //nolint:gosec
-			io.WriteString(hash, password)
-			if hex.EncodeToString(hash.Sum(nil)) == h.password[5:] {
-				h.handler.ServeHTTP(w, r)
-				return
+	if user != nil {
+		r = withRequestUser(r, user)
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// authenticate gets the user of the request by the bearer token, the
+// credentials of the peer process of the unix socket, the client certificate
+// or the basic auth. Returns nil user if no authentication is needed
+func (h *httpBasicAuth) authenticate(r *http.Request) (*requestUser, bool) {
+	ac := h.accessControl()
+	if ac != nil {
+		if token, ok := getBearerToken(r); ok {
+			return ac.authenticateToken(token)
+		}
+		if cred := getRequestPeerCredentials(r); cred != nil {
+			if user, ok := ac.authenticatePeer(cred); ok {
+				return user, true
 			}
-		} else if password == h.password {
-			h.handler.ServeHTTP(w, r)
-			return
 		}
 	}
-	w.Header().Set("WWW-Authenticate", "Basic realm=\"supervisor\"")
-	w.WriteHeader(401)
+	// the verified client certificate mapped to the user needs no password
+	if certUser, ok := h.tls.getClientUser(r.TLS); ok && (ac != nil || h.user == "" || certUser == h.user) {
+		return h.newUser(certUser), true
+	}
+	if ac == nil && (h.user == "" || h.password == "") {
+		return nil, true
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	if h.user != "" && h.password != "" && username == h.user && checkPassword(h.password, password) {
+		return h.newUser(username), true
+	}
+	if ac != nil && ac.authenticate(username, password) {
+		return h.newUser(username), true
+	}
+	return nil, false
+}
+
+// newUser creates the authenticated user, the user configured in the http
+// server section has all the permissions
+func (h *httpBasicAuth) newUser(name string) *requestUser {
+	return &requestUser{name: name, admin: h.user != "" && name == h.user}
 }
 
 // NewXMLRPC create a new XML RPC object
 func NewXMLRPC() *XMLRPC {
-	return &XMLRPC{listeners: make(map[string]net.Listener)}
+	return &XMLRPC{listeners: make(map[string]net.Listener), listenAddrs: make(map[string]string)}
 }
 
 // Stop network listening
@@ -75,19 +113,21 @@ func (p *XMLRPC) Stop() {
 		listener.Close()
 	}
 	p.listeners = make(map[string]net.Listener)
+	p.listenAddrs = make(map[string]string)
 }
 
 // StartUnixHTTPServer start http server on unix domain socket with path listenAddr. If both user and password are not empty, the user
-// must provide user and password for basic authentication when making an XML RPC request.
-func (p *XMLRPC) StartUnixHTTPServer(user string, password string, listenAddr string, s *Supervisor, startedCb func()) {
-	os.Remove(listenAddr)
-	p.startHTTPServer(user, password, "unix", listenAddr, s, make(map[string]string), startedCb)
+// must provide user and password for basic authentication when making an XML RPC request. The mode and the owner of the
+// socket file are changed by socketPerm.
+func (p *XMLRPC) StartUnixHTTPServer(user string, password string, listenAddr string, socketPerm *unixSocketPermission, s *Supervisor, startedCb func()) {
+	p.startHTTPServer(user, password, "unix", listenAddr, nil, socketPerm, s, make(map[string]string), startedCb)
 }
 
 // StartInetHTTPServer start http server on tcp with path listenAddr. If both user and password are not empty, the user
-// must provide user and password for basic authentication when making an XML RPC request.
-func (p *XMLRPC) StartInetHTTPServer(user string, password string, listenAddr string, s *Supervisor, remoteSupervisors map[string]string, startedCb func()) {
-	p.startHTTPServer(user, password, "tcp", listenAddr, s, remoteSupervisors, startedCb)
+// must provide user and password for basic authentication when making an XML RPC request. The http server is served
+// over TLS if serverTLS is not nil, a verified client certificate mapped to the user needs no password.
+func (p *XMLRPC) StartInetHTTPServer(user string, password string, listenAddr string, serverTLS *serverTLS, s *Supervisor, remoteSupervisors map[string]string, startedCb func()) {
+	p.startHTTPServer(user, password, "tcp", listenAddr, serverTLS, nil, s, remoteSupervisors, startedCb)
 }
 
 func (p *XMLRPC) isHTTPServerStartedOnProtocol(protocol string) bool {
@@ -97,6 +137,74 @@ func (p *XMLRPC) isHTTPServerStartedOnProtocol(protocol string) bool {
 	return ok
 }
 
+// programLogFileSystem serves only the log files of the program and their
+// backups in the log directory, the log files of other programs in the same
+// directory are hidden
+type programLogFileSystem struct {
+	dir     http.Dir
+	program string
+	procMgr *process.Manager
+}
+
+// Open opens the log directory or a log file of the program
+func (fs *programLogFileSystem) Open(name string) (http.File, error) {
+	f, err := fs.dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Trim(name, "/") == "" {
+		return &programLogDir{File: f, fs: fs}, nil
+	}
+	if strings.Contains(strings.Trim(name, "/"), "/") || !fs.isLogFile(path.Base(name)) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
+
+// isLogFile checks if the file is a stdout or stderr log file of the program
+// in the log directory, or a backup of it like <log file>.1.gz
+func (fs *programLogFileSystem) isLogFile(name string) bool {
+	dir := filepath.Clean(string(fs.dir))
+	found := false
+	fs.procMgr.ForEachProcess(func(proc *process.Process) {
+		if found || proc.GetConfig().GetProgramName() != fs.program {
+			return
+		}
+		logFiles := strings.Split(proc.GetStdoutLogfile()+","+proc.GetStderrLogfile(), ",")
+		for _, logFile := range logFiles {
+			logFile = strings.TrimSpace(logFile)
+			if logFile == "" || filepath.Dir(logFile) != dir {
+				continue
+			}
+			base := filepath.Base(logFile)
+			if name == base || strings.HasPrefix(name, base+".") {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+// programLogDir lists only the log files of the program in the log directory
+type programLogDir struct {
+	http.File
+	fs *programLogFileSystem
+}
+
+// Readdir reads the log files of the program in the directory
+func (d *programLogDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	logInfos := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && d.fs.isLogFile(info.Name()) {
+			logInfos = append(logInfos, info)
+		}
+	}
+	return logInfos, err
+}
+
 func readFile(path string) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -146,7 +254,7 @@ func readLogHtml(writer http.ResponseWriter, request *http.Request) {
 	writer.Write(b)
 }
 
-func (p *XMLRPC) startHTTPServer(user string, password string, protocol string, listenAddr string, s *Supervisor, remoteSupervisors map[string]string, startedCb func()) {
+func (p *XMLRPC) startHTTPServer(user string, password string, protocol string, listenAddr string, serverTLS *serverTLS, socketPerm *unixSocketPermission, s *Supervisor, remoteSupervisors map[string]string, startedCb func()) {
 	if p.isHTTPServerStartedOnProtocol(protocol) {
 		startedCb()
 		return
@@ -161,25 +269,32 @@ func (p *XMLRPC) startHTTPServer(user string, password string, protocol string,
 	prometheus.Register(p.procCollector)
 	p.mu.Unlock()
 	mux := http.NewServeMux()
-	mux.Handle("/RPC2", newHTTPBasicAuth(user, password, p.createRPCServer(s)))
+	// authenticate the user and check the permissions of the user with the rule
+	auth := func(rule accessRule, handler http.Handler) http.Handler {
+		return newHTTPBasicAuth(user, password, serverTLS, s.getAccessControl, newAccessHandler(s, rule, handler))
+	}
+	mux.Handle("/RPC2", auth(rpcAccessRule, p.createRPCServer(s)))
 
 	progRestHandler := NewSupervisorRestful(s).AddRemoteSupervisors(remoteSupervisors).CreateProgramHandler()
-	mux.Handle("/program/", newHTTPBasicAuth(user, password, progRestHandler))
+	mux.Handle("/program/", auth(restAccessRule, progRestHandler))
 
 	supervisorRestHandler := NewSupervisorRestful(s).AddRemoteSupervisors(remoteSupervisors).CreateSupervisorHandler()
-	mux.Handle("/supervisor/", newHTTPBasicAuth(user, password, supervisorRestHandler))
+	mux.Handle("/supervisor/", auth(restAccessRule, supervisorRestHandler))
+
+	eventStreamHandler := NewEventStream(s).CreateHandler()
+	mux.Handle("/events/", auth(newPermissionRule(permissionView), eventStreamHandler))
 
 	// 有bug已弃用
 	logtailHandler := NewLogtail(s).CreateHandler()
-	mux.Handle("/logtail/", newHTTPBasicAuth(user, password, logtailHandler))
+	mux.Handle("/logtail/", auth(newPathPermissionRule(permissionLog, "/logtail/"), logtailHandler))
 
 	webguiHandler := NewSupervisorWebgui(s).CreateHandler()
-	mux.Handle("/", newHTTPBasicAuth(user, password, webguiHandler))
+	mux.Handle("/", auth(newPermissionRule(permissionView), webguiHandler))
 
 	// conf 文件
 	confHandler := NewConfApi(s).CreateHandler()
-	mux.Handle("/conf/", newHTTPBasicAuth(user, password, confHandler))
-	mux.HandleFunc("/confFile", func(writer http.ResponseWriter, request *http.Request) {
+	mux.Handle("/conf/", auth(newPathPermissionRule(permissionConfig, "/conf/"), confHandler))
+	mux.Handle("/confFile", auth(newPermissionRule(permissionConfig), http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		b, err := readFile("webgui/conf.html")
 		if err != nil {
 			writer.WriteHeader(http.StatusNotFound)
@@ -188,12 +303,12 @@ func (p *XMLRPC) startHTTPServer(user string, password string, protocol string,
 
 		writer.WriteHeader(http.StatusOK)
 		writer.Write(b)
-	})
+	})))
 
 	// 读log.html文件
-	mux.HandleFunc("/log", readLogHtml)
+	mux.Handle("/log", auth(newPermissionRule(permissionLog), http.HandlerFunc(readLogHtml)))
 
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", auth(newPermissionRule(permissionView), promhttp.Handler()))
 
 	// 注册日志路由,可以查看日志目录
 	entryList := s.config.GetPrograms()
@@ -209,21 +324,49 @@ func (p *XMLRPC) startHTTPServer(user string, password string, protocol string,
 		}
 		dir := filepath.Dir(filePath)
 		prefix := "/log/" + realName + "/"
-		mux.Handle(prefix, newHTTPBasicAuth(user, password,
-			http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))))
+		mux.Handle(prefix, auth(newProgramPermissionRule(permissionLog, realName),
+			http.StripPrefix(prefix, http.FileServer(&programLogFileSystem{dir: http.Dir(dir), program: realName, procMgr: s.procMgr}))))
 	}
 
-	listener, err := net.Listen(protocol, listenAddr)
+	// use the listening socket passed by the previous supervisord on upgrade
+	listener := takeInheritedListener(protocol, listenAddr)
+	inherited := listener != nil
+	var err error
+	if inherited {
+		log.WithFields(log.Fields{"addr": listenAddr, "protocol": protocol}).Info("use the inherited listener")
+	} else {
+		if protocol == "unix" {
+			os.Remove(listenAddr)
+		}
+		listener, err = net.Listen(protocol, listenAddr)
+	}
+	// the socket file of the inherited listener keeps its permission, it
+	// can't be changed after the privileges are dropped
+	if err == nil && socketPerm != nil && !inherited {
+		if err = socketPerm.apply(listenAddr); err != nil {
+			listener.Close()
+		}
+	}
 	if err == nil {
 		log.WithFields(log.Fields{"addr": listenAddr, "protocol": protocol}).Info("success to listen on address")
 		p.mu.Lock()
 		p.listeners[protocol] = listener
+		p.listenAddrs[protocol] = listenAddr
 		p.mu.Unlock()
 		startedCb()
-		http.Serve(listener, mux)
+		if serverTLS != nil {
+			// the plain listener is kept to be passed to the new supervisord on upgrade
+			listener = tls.NewListener(listener, serverTLS.TLSConfig())
+		}
+		server := &http.Server{Handler: mux}
+		if protocol == "unix" {
+			// the peer process is authenticated by its uid and gid
+			server.ConnContext = withPeerCredentials
+		}
+		server.Serve(listener)
 	} else {
 		startedCb()
-		log.WithFields(log.Fields{"addr": listenAddr, "protocol": protocol}).Fatal("fail to listen on address")
+		log.WithFields(log.Fields{"addr": listenAddr, "protocol": protocol}).Fatal("fail to listen on address: ", err)
 	}
 
 }
@@ -241,8 +384,13 @@ func (p *XMLRPC) createRPCServer(s *Supervisor) *rpc.Server {
 	xmlrpcCodec.RegisterAlias("supervisor.readLog", "Supervisor.ReadLog")
 	xmlrpcCodec.RegisterAlias("supervisor.clearLog", "Supervisor.ClearLog")
 	xmlrpcCodec.RegisterAlias("supervisor.shutdown", "Supervisor.Shutdown")
+	xmlrpcCodec.RegisterAlias("supervisor.upgrade", "Supervisor.Upgrade")
 	xmlrpcCodec.RegisterAlias("supervisor.restart", "Supervisor.Restart")
 	xmlrpcCodec.RegisterAlias("supervisor.getProcessInfo", "Supervisor.GetProcessInfo")
+	xmlrpcCodec.RegisterAlias("supervisor.getProcessHistory", "Supervisor.GetProcessHistory")
+	xmlrpcCodec.RegisterAlias("supervisor.searchProcessLogs", "Supervisor.SearchProcessLogs")
+	xmlrpcCodec.RegisterAlias("supervisor.resetProcessState", "Supervisor.ResetProcessState")
+	xmlrpcCodec.RegisterAlias("supervisor.rollingRestart", "Supervisor.RollingRestart")
 	xmlrpcCodec.RegisterAlias("supervisor.getSupervisorVersion", "Supervisor.GetVersion")
 	xmlrpcCodec.RegisterAlias("supervisor.getAllProcessInfo", "Supervisor.GetAllProcessInfo")
 	xmlrpcCodec.RegisterAlias("supervisor.startProcess", "Supervisor.StartProcess")
@@ -265,5 +413,8 @@ func (p *XMLRPC) createRPCServer(s *Supervisor) *rpc.Server {
 	xmlrpcCodec.RegisterAlias("supervisor.tailProcessStderrLog", "Supervisor.TailProcessStderrLog")
 	xmlrpcCodec.RegisterAlias("supervisor.clearProcessLogs", "Supervisor.ClearProcessLogs")
 	xmlrpcCodec.RegisterAlias("supervisor.clearAllProcessLogs", "Supervisor.ClearAllProcessLogs")
+	xmlrpcCodec.RegisterAlias("supervisor.rotateLog", "Supervisor.RotateLog")
+	xmlrpcCodec.RegisterAlias("supervisor.rotateProcessLogs", "Supervisor.RotateProcessLogs")
+	xmlrpcCodec.RegisterAlias("supervisor.rotateAllProcessLogs", "Supervisor.RotateAllProcessLogs")
 	return RPC
 }
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -21,8 +22,13 @@ type XMLRPCClient struct {
 	serverurl string
 	user      string
 	password  string
-	timeout   time.Duration
-	verbose   bool
+	// the bearer token, it is sent instead of the user and password if set
+	token   string
+	timeout time.Duration
+	verbose bool
+	// the http client with the TLS configuration, nil to use the default
+	// http client
+	client *http.Client
 }
 
 // VersionReply the version reply message from supervisor
@@ -65,6 +71,34 @@ func (r *XMLRPCClient) SetPassword(password string) {
 	r.password = password
 }
 
+// SetToken sets the bearer token for http auth
+func (r *XMLRPCClient) SetToken(token string) {
+	r.token = token
+}
+
+// setAuth sets the bearer token or the basic auth of the request
+func (r *XMLRPCClient) setAuth(req *http.Request) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	} else if len(r.user) > 0 && len(r.password) > 0 {
+		req.SetBasicAuth(r.user, r.password)
+	}
+}
+
+// SetTLSConfig sets the TLS configuration of the https connection
+func (r *XMLRPCClient) SetTLSConfig(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	r.client = &http.Client{Transport: transport}
+}
+
+func (r *XMLRPCClient) httpClient() *http.Client {
+	if r.client == nil {
+		return http.DefaultClient
+	}
+	return r.client
+}
+
 // SetTimeout sets http request timeout
 func (r *XMLRPCClient) SetTimeout(timeout time.Duration) {
 	r.timeout = timeout
@@ -85,9 +119,7 @@ func (r *XMLRPCClient) createHTTPRequest(method string, url string, data interfa
 		return nil, err
 	}
 
-	if len(r.user) > 0 && len(r.password) > 0 {
-		req.SetBasicAuth(r.user, r.password)
-	}
+	r.setAuth(req)
 
 	req.Header.Set("Content-Type", "text/xml")
 
@@ -120,7 +152,7 @@ func (r *XMLRPCClient) postInetHTTP(method string, url string, data interface{},
 		req = req.WithContext(ctx)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.httpClient().Do(req)
 	if err != nil {
 		processBody(emptyReader, fmt.Errorf("Fail to send http request to supervisord: %s", err))
 		return
@@ -186,6 +218,72 @@ func (r *XMLRPCClient) post(method string, data interface{}, processBody func(io
 
 }
 
+// connCloser closes the unix socket connection after the response body is closed
+type connCloser struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (c *connCloser) Close() error {
+	c.ReadCloser.Close()
+	return c.conn.Close()
+}
+
+// GetStream sends http GET request to the path (like "/program/log/...") of
+// supervisord and returns the response body to be read as a stream without
+// timeout. The caller must close the returned body
+func (r *XMLRPCClient) GetStream(path string) (io.ReadCloser, error) {
+	myurl, err := url.Parse(r.serverurl)
+	if err != nil {
+		return nil, fmt.Errorf("Malform url:%s", r.serverurl)
+	}
+	var conn net.Conn
+	var req *http.Request
+	switch myurl.Scheme {
+	case "http", "https":
+		req, err = http.NewRequest("GET", r.serverurl+path, nil)
+	case "unix":
+		if conn, err = net.Dial("unix", myurl.Path); err != nil {
+			return nil, fmt.Errorf("Fail to connect unix socket path: %s. %s", r.serverurl, err)
+		}
+		req, err = http.NewRequest("GET", "http://unix"+path, nil)
+	default:
+		return nil, fmt.Errorf("Unsupported URL scheme:%s", myurl.Scheme)
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+	r.setAuth(req)
+
+	var resp *http.Response
+	if conn == nil {
+		resp, err = r.httpClient().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to send http request to supervisord: %s", err)
+		}
+	} else {
+		if err = req.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Fail to write to unix socket %s", r.serverurl)
+		}
+		resp, err = http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Fail to read response %s", err)
+		}
+		resp.Body = &connCloser{ReadCloser: resp.Body, conn: conn}
+	}
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Bad response with status code %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+	}
+	return resp.Body, nil
+}
+
 // GetVersion sends http request to acquire software version of supervisord
 func (r *XMLRPCClient) GetVersion() (reply VersionReply, err error) {
 	ins := struct{}{}
@@ -276,6 +374,19 @@ func (r *XMLRPCClient) Shutdown() (reply ShutdownReply, err error) {
 	return
 }
 
+// Upgrade requests supervisord to re-execute its binary without stopping the programs
+func (r *XMLRPCClient) Upgrade() (reply ShutdownReply, err error) {
+	ins := struct{}{}
+	r.post("supervisor.upgrade", &ins, func(body io.ReadCloser, procError error) {
+		err = procError
+		if err == nil {
+			err = xml.DecodeClientResponse(body, &reply)
+		}
+	})
+
+	return
+}
+
 // ReloadConfig requests supervisord to reload its configuration
 func (r *XMLRPCClient) ReloadConfig() (reply types.ReloadConfigResult, err error) {
 	ins := struct{}{}
@@ -351,6 +462,76 @@ func (r *XMLRPCClient) GetProcessInfo(process string) (reply types.ProcessInfo,
 	return
 }
 
+// GetProcessHistory requests the past runs of the process
+func (r *XMLRPCClient) GetProcessHistory(process string) (reply []types.ProcessRun, err error) {
+	ins := struct{ Name string }{process}
+	result := struct{ Reply []types.ProcessRun }{}
+	r.post("supervisor.getProcessHistory", &ins, func(body io.ReadCloser, procError error) {
+		err = procError
+		if err == nil {
+			err = xml.DecodeClientResponse(body, &result)
+			if err == nil {
+				reply = result.Reply
+			} else if r.verbose {
+				fmt.Printf("Fail to decode to []types.ProcessRun\n")
+			}
+		}
+	})
+
+	return
+}
+
+// ResetProcessState requests to forget the desired state of the processes,
+// all the processes if no process is given. Returns the processes whose
+// state is forgotten
+func (r *XMLRPCClient) ResetProcessState(processes []string) (reply []string, err error) {
+	if len(processes) == 0 {
+		processes = []string{"all"}
+	}
+	ins := struct{ Names []string }{processes}
+
+	xmlProcMgr := NewXMLProcessorManager()
+	reply = make([]string, 0)
+	xmlProcMgr.AddLeafProcessor("methodResponse/params/param/value/array/data/value/string", func(value string) {
+		reply = append(reply, value)
+	})
+	xmlProcMgr.AddLeafProcessor("methodResponse/fault/value/struct/member/value/string", func(value string) {
+		err = fmt.Errorf("%s", value)
+	})
+	r.post("supervisor.resetProcessState", &ins, func(body io.ReadCloser, procError error) {
+		err = procError
+		if err == nil {
+			xmlProcMgr.ProcessXML(body)
+		}
+	})
+	return
+}
+
+// RollingRestart requests to restart the processes of the program or group
+// batchSize at a time. Returns the processes restarted successfully
+func (r *XMLRPCClient) RollingRestart(name string, batchSize int) (reply []string, err error) {
+	ins := struct {
+		Name      string
+		BatchSize int
+	}{name, batchSize}
+
+	xmlProcMgr := NewXMLProcessorManager()
+	reply = make([]string, 0)
+	xmlProcMgr.AddLeafProcessor("methodResponse/params/param/value/array/data/value/string", func(value string) {
+		reply = append(reply, value)
+	})
+	xmlProcMgr.AddLeafProcessor("methodResponse/fault/value/struct/member/value/string", func(value string) {
+		err = fmt.Errorf("%s", value)
+	})
+	r.post("supervisor.rollingRestart", &ins, func(body io.ReadCloser, procError error) {
+		err = procError
+		if err == nil {
+			xmlProcMgr.ProcessXML(body)
+		}
+	})
+	return
+}
+
 // StartProcess Start a process
 func (r *XMLRPCClient) StartProcess(process string, wait bool) (reply types.BooleanReply, err error) {
 	ins := struct {